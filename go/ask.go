@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	// askTopK is how many of the most relevant messages !ask retrieves
+	askTopK = 8
+	// askNeighbors is how many messages either side of each one are included for context
+	askNeighbors = 2
+	// maxEmbeddingLength is where long messages are cut off before being embedded
+	maxEmbeddingLength = 2000
+	// embeddingBatchSize is how many messages are embedded at once when backfilling
+	embeddingBatchSize = 100
+)
+
+// askArgs are the parsed arguments to !ask
+type askArgs struct {
+	Range    rangeSpec // Only look at messages in this range, nil looks at everything we have
+	Question string
+}
+
+// maxAskRangeWords is the most words a time range before the question can use, eg. "from 9am to 11am"
+const maxAskRangeWords = 4
+
+func parseAskArgs(name string, args []string) (interface{}, error) {
+	// The question can start with a time range, eg. "!ask yesterday what did we decide?".
+	// The longest range that leaves a question wins.
+	if len(args) == 0 {
+		return nil, errMissingArgs
+	}
+	for n := min(maxAskRangeWords, len(args)-1); n > 0; n-- {
+		if window, err := parseTimeRange(args[:n]); err == nil {
+			return askArgs{Range: window, Question: strings.Join(args[n:], " ")}, nil
+		}
+	}
+	return askArgs{Question: strings.Join(args, " ")}, nil
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "ask",
+		Usage:       "[time range] <question>",
+		Description: "Ask a question",
+		Slow:        true,
+		GroupTarget: true,
+		ParseArgs:   parseAskArgs,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			a := args.(askArgs)
+			var window TimeRange
+			if a.Range != nil {
+				window = a.Range(time.Now().In(ctx.groupLocation(req.TraceContext, req.GroupId)))
+			}
+			return ctx.askCommand(req, window, a.Question)
+		},
+	})
+}
+
+func (ctx *AppContext) askCommand(req *RequestContext, window TimeRange, question string) error {
+	// Answer a question using the chat log in the window as context.
+	// Without embeddings the whole log in the window is sent along with the question.
+	if ctx.Embedder == nil {
+		prompt := question
+		prompt = prompt + "\nTry to use the chat log to answer this question. If the answer is not provided in the chat log above,"
+		prompt = prompt + "ignore the chat log and provide the best answer you can. "
+		prompt = prompt + "Do not be overly verbose in your answers unless asked. Responses under 1000 chars are preferred."
+		return ctx.summaryCommand(req, window, 0, prompt)
+	}
+
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	askCtx, span := tracer.Start(req.TraceContext, "askCommand")
+	defer span.End()
+
+	excerpts, err := ctx.retrieveRelevantMessages(askCtx, req.GroupId, question, window)
+	if err != nil {
+		ctx.MessagePoster(req, "Failed to search the chat history: "+err.Error(), "")
+		return err
+	}
+
+	prompt := "Below are excerpts from a group chat which may be relevant to a question, " +
+		"each with the time it was sent and who sent it.\n\n"
+	if len(excerpts) == 0 {
+		prompt = "Nothing relevant to the question was found in the group chat.\n\n"
+	}
+	for _, msg := range excerpts {
+		sent := ctx.groupTime(req.TraceContext, req.GroupId, msg.Timestamp, "2006-01-02 15:04")
+		prompt += fmt.Sprintf("[%s] %s: %s\n", sent, msg.SourceName, msg.Message)
+	}
+	prompt += "\nQuestion from " + req.SourceName + ": " + question + "\n\n" +
+		"Try to use the chat excerpts to answer this question. If the answer is not in them, " +
+		"provide the best answer you can and say it didn't come from the chat. " +
+		"Do not be overly verbose in your answers unless asked. Responses under 1000 chars are preferred."
+	prompt += ctx.languageInstruction(askCtx, req.GroupId)
+
+	resp, err := ctx.summaryProvider(askCtx, req.GroupId).Generate(askCtx, textRequest(prompt))
+	if err != nil {
+		log.Println("Failed to answer question:", err)
+		ctx.MessagePoster(req, "Failed to answer question: "+err.Error(), "")
+		return err
+	}
+	ctx.postLongMessage(req, resp.Text)
+	return nil
+}
+
+func (ctx *AppContext) retrieveRelevantMessages(traceCtx context.Context, groupId string, question string, window TimeRange) ([]StoredMessage, error) {
+	// Find the messages in the window most similar to the question, and the
+	// messages around each of them so the model can follow the conversation
+	resp, err := ctx.Embedder.Embed(traceCtx, []string{question})
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed question: %w", err)
+	}
+	all, err := ctx.Store.FetchEmbeddings(traceCtx, groupId, ctx.Embedder.Model())
+	if err != nil {
+		return nil, err
+	}
+	var embeddings []MessageEmbedding
+	for _, embedding := range all {
+		if window.Contains(embedding.Timestamp) {
+			embeddings = append(embeddings, embedding)
+		}
+	}
+
+	found := map[int64]StoredMessage{}
+	for _, hit := range topKSimilar(resp.Vectors[0], embeddings, askTopK) {
+		if hit.Score <= 0 {
+			continue
+		}
+		messages, err := ctx.Store.FetchAround(traceCtx, groupId, hit.Timestamp, askNeighbors)
+		if err != nil {
+			return nil, err
+		}
+		// A question and its answer can be far apart, so follow replies to and from the hit
+		thread, err := ctx.Store.FetchThread(traceCtx, groupId, hit.MessageId)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range append(messages, thread...) {
+			if window.Contains(msg.Timestamp) {
+				found[msg.Id] = msg
+			}
+		}
+	}
+
+	var excerpts []StoredMessage
+	for _, msg := range found {
+		excerpts = append(excerpts, msg)
+	}
+	sort.Slice(excerpts, func(i, j int) bool {
+		if excerpts[i].Timestamp == excerpts[j].Timestamp {
+			return excerpts[i].Id < excerpts[j].Id
+		}
+		return excerpts[i].Timestamp < excerpts[j].Timestamp
+	})
+	return excerpts, nil
+}
+
+func isEmbeddable(message string) bool {
+	// Commands don't say anything worth retrieving later
+	message = strings.TrimSpace(message)
+	return message != "" && !strings.HasPrefix(message, "!")
+}
+
+func embeddingText(msg StoredMessage) string {
+	// Include the sender, so questions about who said something can match
+	text := msg.SourceName + ": " + msg.Message
+	if runes := []rune(text); len(runes) > maxEmbeddingLength {
+		text = string(runes[:maxEmbeddingLength])
+	}
+	return text
+}
+
+func (ctx *AppContext) embedMessages(traceCtx context.Context, messages []StoredMessage) error {
+	// Embed the messages and store the vectors
+	var texts []string
+	for _, msg := range messages {
+		texts = append(texts, embeddingText(msg))
+	}
+	resp, err := ctx.Embedder.Embed(traceCtx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed messages: %w", err)
+	}
+	for i, msg := range messages {
+		if err := ctx.Store.SaveEmbedding(traceCtx, msg.Id, ctx.Embedder.Model(), resp.Vectors[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embedInBackground embeds a message without waiting for the provider. Until
+// it's done !ask just doesn't find the message, so failures are only logged.
+func (ctx *AppContext) embedInBackground(traceCtx context.Context, msg StoredMessage) {
+	traceCtx = context.WithoutCancel(traceCtx)
+	ctx.embedding.Add(1)
+	go func() {
+		defer ctx.embedding.Done()
+		if err := ctx.embedMessage(traceCtx, msg); err != nil {
+			log.Println("Failed to embed message:", err)
+		}
+	}()
+}
+
+// embedMessage embeds a message as it's sent or edited. It's paid for by its
+// group and sender, like describing its images, but isn't rate limited:
+// skipping messages would leave gaps in the history !ask searches.
+func (ctx *AppContext) embedMessage(traceCtx context.Context, msg StoredMessage) error {
+	owner := usageOwner{GroupId: msg.GroupId, Member: memberId(msg.SourceUuid, msg.SourceNumber), Command: "embedding"}
+	traceCtx = context.WithValue(traceCtx, usageOwnerKey{}, owner)
+	if refusal := ctx.checkBudget(traceCtx, owner.GroupId, owner.Member, time.Now()); refusal != "" {
+		return fmt.Errorf("over budget: %s", refusal)
+	}
+	return ctx.embedMessages(traceCtx, []StoredMessage{msg})
+}
+
+func (ctx *AppContext) backfillEmbeddings() {
+	// Embed messages stored before embeddings were enabled, or with another model,
+	// newest first so recent history is searchable soonest. Nobody asked for
+	// this, so its usage is recorded against no group and no budget is checked.
+	tracer := otel.Tracer("signal-bot")
+	backfillCtx, span := tracer.Start(ctx.TraceContext, "backfillEmbeddings")
+	defer span.End()
+
+	total := 0
+	for {
+		messages, err := ctx.Store.FetchUnembedded(backfillCtx, ctx.Embedder.Model(), embeddingBatchSize)
+		if err != nil {
+			log.Println("Failed to fetch messages to embed:", err)
+			return
+		}
+		if len(messages) == 0 {
+			break
+		}
+		if err := ctx.embedMessages(backfillCtx, messages); err != nil {
+			log.Println("Failed to backfill embeddings:", err)
+			return
+		}
+		total += len(messages)
+	}
+	if total > 0 {
+		log.Println("Embedded", total, "existing messages")
+	}
+}
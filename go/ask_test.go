@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keywordEmbedder puts text mentioning the keyword at right angles to everything
+// else, so retrieval finds exactly the messages we expect
+type keywordEmbedder struct {
+	keyword string
+}
+
+func (e *keywordEmbedder) Name() string  { return "keyword" }
+func (e *keywordEmbedder) Model() string { return "keyword" }
+func (e *keywordEmbedder) Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error) {
+	var vectors [][]float32
+	for _, text := range texts {
+		if strings.Contains(text, e.keyword) {
+			vectors = append(vectors, []float32{1, 0})
+		} else {
+			vectors = append(vectors, []float32{0, 1})
+		}
+	}
+	return &EmbeddingResponse{Vectors: vectors, Usage: Usage{InputTokens: len(texts)}}, nil
+}
+
+func TestAskRetrievesRelevantMessages(t *testing.T) {
+	Config = map[string]string{"BOTNAME": "Robo"}
+	ctx := &AppContext{
+		Store:           newTestStore(t),
+		Embedder:        &keywordEmbedder{keyword: "hiking"},
+		SummaryProvider: &debugProvider{},
+		TraceContext:    context.Background(),
+	}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+
+	// Messages arrive through saveMessage, which embeds them in the background
+	history := []struct {
+		group, name, message string
+	}{
+		{"groupOne", "Alice", "good morning"},
+		{"groupOne", "Bob", "anyone seen my keys"},
+		{"groupOne", "Carol", "the hiking trail map is at https://example.com/trail"},
+		{"groupOne", "Alice", "thanks Carol"},
+		{"groupOne", "Bob", "what time is lunch"},
+		{"groupOne", "Dave", "noon"},
+		{"groupOne", "Erin", "ok"},
+		{"groupOne", "Bob", "!summary"},
+		{"groupTwo", "Mallory", "a secret hiking trail map"},
+	}
+	for i, h := range history {
+		req := &RequestContext{GroupId: h.group, SourceName: h.name, Timestamp: int64(i + 1), TraceContext: context.Background()}
+		if err := ctx.saveMessage(req, h.message, nil, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	ctx.embedding.Wait()
+	embeddings, err := ctx.Store.FetchEmbeddings(context.Background(), "groupOne", "keyword")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(embeddings) != 7 {
+		t.Errorf("expected every message but the command to be embedded, got %d", len(embeddings))
+	}
+
+	req := &RequestContext{GroupId: "groupOne", SourceName: "Dave", Timestamp: 100, TraceContext: context.Background()}
+	if err := ctx.askCommand(req, TimeRange{}, "where is the hiking trail map"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 {
+		t.Fatalf("expected one reply, got %q", replies)
+	}
+	prompt := replies[0]
+	// The match and its neighbours are included, the rest of the log and other groups aren't
+	for _, expected := range []string{"Carol: the hiking trail map", "Alice: good morning", "Bob: what time is lunch", "Question from Dave"} {
+		if !strings.Contains(prompt, expected) {
+			t.Errorf("expected prompt to contain %q, got %q", expected, prompt)
+		}
+	}
+	for _, unexpected := range []string{"Dave: noon", "Erin: ok", "Mallory"} {
+		if strings.Contains(prompt, unexpected) {
+			t.Errorf("expected prompt not to contain %q, got %q", unexpected, prompt)
+		}
+	}
+
+	// Nothing outside the time range is retrieved
+	replies = nil
+	if err := ctx.askCommand(req, TimeRange{Start: time.UnixMilli(4)}, "where is the hiking trail map"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0], "Nothing relevant") {
+		t.Errorf("expected nothing to be found after the match, got %q", replies)
+	}
+}
+
+func TestParseAskArgs(t *testing.T) {
+	now := time.Date(2024, 12, 4, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		args     string
+		question string
+		start    time.Time
+	}{
+		{"what did we decide?", "what did we decide?", time.Time{}},
+		{"yesterday what did we decide?", "what did we decide?", time.Date(2024, 12, 3, 0, 0, 0, 0, time.UTC)},
+		{"from 9am to 11am who joined?", "who joined?", time.Date(2024, 12, 4, 9, 0, 0, 0, time.UTC)},
+		{"today", "today", time.Time{}},
+	}
+	for _, test := range tests {
+		args, err := parseAskArgs("ask", strings.Fields(test.args))
+		if err != nil {
+			t.Fatalf("parseAskArgs(%q): unexpected error: %v", test.args, err)
+		}
+		parsed := args.(askArgs)
+		var start time.Time
+		if parsed.Range != nil {
+			start = parsed.Range(now).Start
+		}
+		if parsed.Question != test.question || !start.Equal(test.start) {
+			t.Errorf("parseAskArgs(%q): unexpected question %q from %v", test.args, parsed.Question, start)
+		}
+	}
+	if _, err := parseAskArgs("ask", nil); err != errMissingArgs {
+		t.Errorf("expected errMissingArgs, got %v", err)
+	}
+}
+
+func TestBackfillEmbeddings(t *testing.T) {
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+
+	// Save messages before embeddings are enabled
+	for i, message := range []string{"first message", "!ping", "second message"} {
+		req := &RequestContext{GroupId: "groupOne", SourceName: "Alice", Timestamp: int64(i + 1), TraceContext: context.Background()}
+		if err := ctx.saveMessage(req, message, nil, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ctx.Embedder = &debugEmbeddingProvider{}
+	ctx.backfillEmbeddings()
+	embeddings, err := ctx.Store.FetchEmbeddings(context.Background(), "groupOne", "debug")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(embeddings) != 2 {
+		t.Errorf("expected 2 messages to be embedded, got %d", len(embeddings))
+	}
+}
+
+// stuckEmbedder doesn't answer until it's released, like a provider that's down
+type stuckEmbedder struct {
+	release chan struct{}
+}
+
+func (e *stuckEmbedder) Name() string  { return "stuck" }
+func (e *stuckEmbedder) Model() string { return "stuck" }
+func (e *stuckEmbedder) Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error) {
+	<-e.release
+	return &EmbeddingResponse{Vectors: make([][]float32, len(texts))}, nil
+}
+
+func TestSavingDoesNotWaitForEmbeddings(t *testing.T) {
+	Config = map[string]string{}
+	embedder := &stuckEmbedder{release: make(chan struct{})}
+	ctx := &AppContext{Store: newTestStore(t), Embedder: embedder, TraceContext: context.Background()}
+	req := &RequestContext{GroupId: "groupOne", SourceName: "Alice", Timestamp: 1, TraceContext: context.Background()}
+
+	saved := make(chan error)
+	go func() { saved <- ctx.saveMessage(req, "hello", nil, nil) }()
+	select {
+	case err := <-saved:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the message to be saved without waiting for the embedding")
+	}
+	close(embedder.release)
+	ctx.embedding.Wait()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// catchupDefaultWindow is how far back !catchup goes for members we've never seen
+const catchupDefaultWindow = 24 * time.Hour
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "catchup",
+		Usage:       "[dm]",
+		Description: "Summarize what you missed since you were last here",
+		Slow:        true,
+		GroupTarget: true,
+		ParseArgs: func(name string, args []string) (interface{}, error) {
+			for _, arg := range args {
+				if !strings.EqualFold(arg, "dm") {
+					return nil, fmt.Errorf("unknown option %s", arg)
+				}
+			}
+			return catchupArgs{DirectMessage: len(args) > 0}, nil
+		},
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.catchupCommand(req)
+		},
+	})
+}
+
+// catchupArgs are the parsed arguments to !catchup
+type catchupArgs struct {
+	DirectMessage bool // Reply to the requester privately rather than in the group
+}
+
+func (a catchupArgs) PrivateReply() bool {
+	return a.DirectMessage
+}
+
+func memberId(uuid string, number string) string {
+	// Members are identified by their UUID, which never changes, when we have it
+	if uuid != "" {
+		return uuid
+	}
+	return number
+}
+
+func directReply(req *RequestContext) *RequestContext {
+	// Copy the request, sending replies to the requester rather than the group.
+	// They're sent the same way as replies to the member's direct messages.
+	direct := *req
+	direct.Recipient = recipientFor(directPrefix + memberId(req.SourceUuid, req.SourceNumber))
+	return &direct
+}
+
+func (ctx *AppContext) markSeen(req *RequestContext) {
+	// Remember that the sender was here. This runs after any command in the
+	// message, so !catchup sees when they were here before this message.
+	member := memberId(req.SourceUuid, req.SourceNumber)
+	if member == "" {
+		return
+	}
+	if err := ctx.Store.MarkSeen(req.TraceContext, req.GroupId, member, req.Timestamp); err != nil {
+		log.Println("Failed to mark member as seen:", err)
+	}
+}
+
+func (ctx *AppContext) processReceipt(envelope *Envelope) {
+	// Read receipts only arrive for the bot's own messages, which tells us the
+	// member has read the group up to that message
+	receipt := envelope.ReceiptMessage
+	if !receipt.IsRead && !receipt.IsViewed {
+		return
+	}
+	member := memberId(envelope.SourceUuid, envelope.SourceNumber)
+	if member == "" {
+		return
+	}
+
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	receiptCtx, span := tracer.Start(ctx.TraceContext, "processReceipt")
+	defer span.End()
+
+	if err := ctx.Store.MarkRead(receiptCtx, member, receipt.Timestamps); err != nil {
+		log.Println("Failed to process read receipt:", err)
+	}
+}
+
+func (ctx *AppContext) catchupCommand(req *RequestContext) error {
+	// Summarize everything in the group since the requester was last seen,
+	// up to the message asking for it
+	member := memberId(req.SourceUuid, req.SourceNumber)
+	lastSeen, err := ctx.Store.LastSeen(req.TraceContext, req.GroupId, member)
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't work out when you were last here: "+err.Error(), "")
+		return err
+	}
+
+	start := lastSeen + 1
+	intro := ""
+	if lastSeen == 0 {
+		start = time.Now().Add(-catchupDefaultWindow).UnixMilli()
+		intro = "I haven't seen you here before, so here's what happened in the last 24 hours."
+	}
+	messages, err := ctx.Store.FetchRange(req.TraceContext, req.GroupId, start, req.Timestamp)
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't fetch the messages you missed: "+err.Error(), "")
+		return err
+	}
+	if len(messages) == 0 {
+		ctx.MessagePoster(req, "You haven't missed anything, there have been no messages since you were last here.", "")
+		return nil
+	}
+
+	if intro == "" {
+		intro = fmt.Sprintf("You've missed %d messages since %s.", len(messages), ctx.groupTime(req.TraceContext, req.GroupId, lastSeen, "Mon Jan 2 15:04"))
+	}
+	ctx.MessagePoster(req, intro, "")
+	return ctx.summaryCommand(req, TimeRange{Start: time.UnixMilli(start), End: time.UnixMilli(req.Timestamp)}, 0, "")
+}
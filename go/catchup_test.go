@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSummaryArgs(t *testing.T) {
+	args, err := parseSummaryArgs("summary", []string{"since-me", "dm"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(summaryArgs); !parsed.SinceMe || !parsed.DirectMessage {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	args, err = parseSummaryArgs("summary", []string{"dm", "50"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(summaryArgs); parsed.SinceMe || !parsed.DirectMessage || parsed.Count != 50 {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+}
+
+func TestDirectReply(t *testing.T) {
+	req := &RequestContext{GroupId: "groupOne", Conversation: "groupOne", Recipient: encodeGroupIdToBase64("groupOne"), SourceUuid: "alice-uuid"}
+	if !isConversationReply(req) {
+		t.Errorf("expected replies to go to the group")
+	}
+	direct := directReply(req)
+	if direct.Recipient != "alice-uuid" || isConversationReply(direct) {
+		t.Errorf("expected replies to go to the sender, got %s", direct.Recipient)
+	}
+	if req.Recipient != encodeGroupIdToBase64("groupOne") {
+		t.Errorf("the original request must not be changed")
+	}
+}
+
+func TestCatchupSummarizesFromLastSeen(t *testing.T) {
+	Config = map[string]string{"BOTNAME": "Robo", "PHONE": "+123456789"}
+	ctx := &AppContext{Store: newTestStore(t), SummaryProvider: &debugProvider{}, TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, req.Recipient+": "+message)
+	}
+
+	// Messages arrive through processSignalMessage, which keeps track of who was here
+	now := time.Now().UnixMilli()
+	send := func(name, uuid, text string, timestamp int64) {
+		ctx.processSignalMessage(&SignalMessage{Envelope: &Envelope{
+			SourceName: name,
+			SourceUuid: uuid,
+			Timestamp:  timestamp,
+			DataMessage: &DataMessage{
+				Timestamp: timestamp,
+				Message:   text,
+				GroupInfo: &GroupInfo{GroupId: "groupOne"},
+			},
+		}})
+	}
+	send("Alice", "alice-uuid", "before you left", now-5000)
+	send("Bob", "bob-uuid", "bye for now", now-4000)
+	send("Alice", "alice-uuid", "you missed this", now-3000)
+	send("Alice", "alice-uuid", "and this", now-2000)
+
+	replies = nil
+	send("Bob", "bob-uuid", "!catchup dm", now-1000)
+	if len(replies) != 2 {
+		t.Fatalf("expected an introduction and a summary, got %q", replies)
+	}
+	if !strings.HasPrefix(replies[0], "bob-uuid: You've missed 2 messages") {
+		t.Errorf("unexpected introduction %q", replies[0])
+	}
+	summary := replies[1]
+	if !strings.HasPrefix(summary, "bob-uuid: ") || !strings.Contains(summary, "you missed this") || strings.Contains(summary, "before you left") {
+		t.Errorf("expected a private summary of what Bob missed, got %q", summary)
+	}
+
+	// Asking again straight away, there's nothing new
+	replies = nil
+	send("Bob", "bob-uuid", "!summary since-me", now)
+	if len(replies) != 1 || !strings.Contains(replies[0], "You haven't missed anything") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+}
+
+func TestReadReceiptsMarkMembersAsSeen(t *testing.T) {
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	botMessage := StoredMessage{Timestamp: 5000, SourceName: "Robo", Message: "a summary", GroupId: "groupOne"}
+	if err := ctx.Store.SaveMessage(context.Background(), &botMessage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx.processSignalMessage(&SignalMessage{Envelope: &Envelope{
+		SourceUuid:     "carol-uuid",
+		ReceiptMessage: &ReceiptMessage{IsRead: true, Timestamps: []int64{5000, 9999}},
+	}})
+	lastSeen, err := ctx.Store.LastSeen(context.Background(), "groupOne", "carol-uuid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lastSeen != 5000 {
+		t.Errorf("expected Carol to have been seen at 5000, got %d", lastSeen)
+	}
+
+	// An older receipt doesn't move the time backwards
+	if err := ctx.Store.MarkSeen(context.Background(), "groupOne", "carol-uuid", 1000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lastSeen, _ := ctx.Store.LastSeen(context.Background(), "groupOne", "carol-uuid"); lastSeen != 5000 {
+		t.Errorf("expected the last seen time to stay at 5000, got %d", lastSeen)
+	}
+}
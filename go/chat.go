@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+)
+
+// noResponse is what the model replies with when it decides not to respond
+const noResponse = "<NO_RESPONSE>"
+
+func (ctx *AppContext) chatCommand(req *RequestContext, msgBody string, mentions []Mention) error {
+	// Talk to people who mention or name the bot, using the group's recent
+	// history as the conversation so far. Direct messages are always to the bot.
+	if !isDirectConversation(req.GroupId) && !checkIfMentioned(mentions) && !checkIfNamed(msgBody) {
+		return nil
+	}
+
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	chatCtx, span := tracer.Start(req.TraceContext, "chatCommand")
+	defer span.End()
+
+	provider := ctx.chatProvider(chatCtx, req.GroupId)
+	if provider == nil {
+		return nil
+	}
+	chatCtx = withUsageOwner(chatCtx, req, "chat")
+	if refusal := ctx.checkLimits(req, "chat"); refusal != "" {
+		ctx.MessagePoster(req, refusal, "")
+		return nil
+	}
+	systemPrompt, err := loadChatbotInitMessage()
+	if err != nil {
+		return err
+	}
+	systemPrompt += ctx.languageInstruction(chatCtx, req.GroupId)
+
+	// The message we're replying to has already been saved, so it's the last user turn
+	history, err := ctx.fetchChatbotHistoryFromDb(chatCtx, req.GroupId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch chat history: %w", err)
+	}
+	conversation := buildChatConversation(history)
+	if len(conversation) == 0 {
+		return fmt.Errorf("no chat history found for group %s", req.GroupId)
+	}
+
+	stopTyping := ctx.showTyping(req)
+	resp, err := provider.Generate(chatCtx, LLMRequest{
+		SystemPrompt: systemPrompt,
+		Messages:     conversation,
+	})
+	stopTyping()
+	if err != nil {
+		log.Println("Failed to generate chat response:", err)
+		ctx.MessagePoster(req, "Sorry, I couldn't come up with a response: "+err.Error(), "")
+		return err
+	}
+
+	reply := strings.TrimSpace(resp.Text)
+	if reply == "" || strings.Contains(reply, noResponse) {
+		return nil
+	}
+	ctx.postLongMessage(req, reply)
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errMissingArgs is returned by argument parsers when a command needs arguments but got none
+var errMissingArgs = errors.New("missing arguments")
+
+// Command describes a single bot command.
+// Commands register themselves with Commands.Register() from an init() function
+// in the file that implements them.
+type Command struct {
+	Name        string   // The name of the command, without the leading !
+	Aliases     []string // Other names the command can be called by, without the leading !
+	Usage       string   // The arguments the command takes, eg. "<text>"
+	Description string   // A short description, shown in !help
+	// Permission is who can run the command unless a group changes it with
+	// !admin permit. Empty means everyone.
+	Permission PermissionLevel
+	// GroupTarget is whether the command can be used on one of the sender's
+	// groups from a direct message, eg. !summary @GroupName 12h
+	GroupTarget bool
+	// Slow is whether the command takes long enough that the bot reacts to it
+	// while it's working, and shows it's typing
+	Slow bool
+	// ParseArgs turns the words following the command into the value passed to Handler.
+	// name is the name the command was called with, which may be an alias.
+	// If ParseArgs is nil, the words are passed to Handler unchanged as a []string.
+	// Parsed arguments which implement privateReplyArgs can ask for replies to
+	// be sent privately to the sender.
+	ParseArgs func(name string, args []string) (interface{}, error)
+	// Handler runs the command. Handlers are responsible for replying to the user,
+	// any error returned is logged.
+	Handler func(ctx *AppContext, req *RequestContext, args interface{}) error
+}
+
+// CommandRegistry holds all of the commands the bot knows about
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+	lookup   map[string]*Command
+}
+
+// Commands is the registry used by processMessage
+var Commands = NewCommandRegistry()
+
+func NewCommandRegistry() *CommandRegistry {
+	return &CommandRegistry{
+		commands: make(map[string]*Command),
+		lookup:   make(map[string]*Command),
+	}
+}
+
+// Register adds a command to the registry. It panics if the name or one of the
+// aliases is already taken, as that's always a programming error.
+func (r *CommandRegistry) Register(cmd *Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cmd.Name == "" || cmd.Handler == nil {
+		panic("commands must have a name and a handler")
+	}
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		if _, ok := r.lookup[name]; ok {
+			panic(fmt.Sprintf("command %s is already registered", name))
+		}
+		r.lookup[name] = cmd
+	}
+	r.commands[cmd.Name] = cmd
+}
+
+// Lookup finds a command by name or alias. The leading ! is optional.
+func (r *CommandRegistry) Lookup(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.lookup[strings.TrimPrefix(name, "!")]
+	return cmd, ok
+}
+
+// List returns all of the registered commands, sorted by name
+func (r *CommandRegistry) List() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var commands []*Command
+	for _, cmd := range r.commands {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// Synopsis returns the command's name and usage, eg. "!imagine <text>"
+func (cmd *Command) Synopsis() string {
+	if cmd.Usage == "" {
+		return "!" + cmd.Name
+	}
+	return "!" + cmd.Name + " " + cmd.Usage
+}
+
+// HelpText returns the detailed help for a single command
+func (cmd *Command) HelpText() string {
+	text := fmt.Sprintf("%s - %s", cmd.Synopsis(), cmd.Description)
+	if len(cmd.Aliases) > 0 {
+		text += "\nAliases: !" + strings.Join(cmd.Aliases, ", !")
+	}
+	if cmd.GroupTarget {
+		text += fmt.Sprintf("\nIn a direct message, name one of your groups to use it there, eg. !%s @GroupName", cmd.Name)
+	}
+	return text
+}
+
+// HelpText returns the list of available commands
+func (r *CommandRegistry) HelpText() string {
+	message := "Available commands:\n"
+	for _, cmd := range r.List() {
+		message += fmt.Sprintf("%s - %s\n", cmd.Synopsis(), cmd.Description)
+	}
+	message += "Use !help <command> for more details\n"
+	return message
+}
+
+// dispatchCommand runs the command in msgBody, if there is one.
+// It returns false if msgBody isn't a known command.
+func (ctx *AppContext) dispatchCommand(req *RequestContext, msgBody string) bool {
+	words := strings.Fields(msgBody)
+	if len(words) == 0 || !strings.HasPrefix(words[0], "!") {
+		return false
+	}
+	name := strings.TrimPrefix(words[0], "!")
+	cmd, ok := Commands.Lookup(name)
+	if !ok {
+		return false
+	}
+	// In a busy group it's clearer which command a reply is for if it quotes it
+	req.QuoteReply = true
+	// Reactions go on the message itself, wherever the replies go
+	trigger := req
+
+	// In a direct message, the command can be used on one of the sender's groups.
+	// Replies still come back privately, and the direct message isn't marked as
+	// seen in the group.
+	args := words[1:]
+	if isDirectConversation(req.GroupId) && cmd.GroupTarget && len(args) > 0 && strings.HasPrefix(args[0], "@") {
+		groupId, rest, err := ctx.resolveGroupTarget(req, args)
+		if err != nil {
+			ctx.MessagePoster(req, "Sorry, "+err.Error()+".", "")
+			return true
+		}
+		target := *req
+		target.GroupId = groupId
+		req, args = &target, rest
+	}
+	if !ctx.commandEnabled(req.TraceContext, req.GroupId, cmd) {
+		ctx.MessagePoster(req, fmt.Sprintf("Sorry, !%s is turned off in this group.", cmd.Name), "")
+		return true
+	}
+	if refusal := ctx.checkPermission(req, cmd); refusal != "" {
+		ctx.MessagePoster(req, refusal, "")
+		return true
+	}
+
+	var parsed interface{} = args
+	if cmd.ParseArgs != nil {
+		var err error
+		parsed, err = cmd.ParseArgs(name, args)
+		if err != nil {
+			log.Printf("Invalid arguments to !%s: %v", name, err)
+			message := "Usage: " + cmd.Synopsis()
+			if !errors.Is(err, errMissingArgs) {
+				message = fmt.Sprintf("%s\n%s", err.Error(), message)
+			}
+			ctx.MessagePoster(req, message, "")
+			return true
+		}
+	}
+
+	// Replies sent privately, eg. for !summary dm, are redirected before the
+	// command starts, so the bot is seen typing where the reply will appear
+	if private, ok := parsed.(privateReplyArgs); ok && private.PrivateReply() {
+		req = directReply(req)
+	}
+
+	// Providers called by the command record their usage against the sender
+	req.TraceContext = withUsageOwner(req.TraceContext, req, cmd.Name)
+	if refusal := ctx.checkLimits(req, cmd.Name); refusal != "" {
+		ctx.MessagePoster(req, refusal, "")
+		return true
+	}
+
+	// Let the sender know a slow command is underway, and then how it went
+	if cmd.Slow {
+		ctx.react(trigger, reactionWorking)
+		stopTyping := ctx.showTyping(req)
+		err := cmd.Handler(ctx, req, parsed)
+		stopTyping()
+		if err != nil {
+			log.Printf("Command !%s failed: %v", name, err)
+			ctx.react(trigger, reactionFailed)
+		} else {
+			ctx.react(trigger, reactionDone)
+		}
+		return true
+	}
+	if err := cmd.Handler(ctx, req, parsed); err != nil {
+		log.Printf("Command !%s failed: %v", name, err)
+	}
+	return true
+}
+
+// privateReplyArgs are parsed arguments which can ask for a private reply
+type privateReplyArgs interface {
+	PrivateReply() bool
+}
+
+// requireArgs is an argument parser for commands that take free text
+func requireArgs(name string, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errMissingArgs
+	}
+	return strings.Join(args, " "), nil
+}
+
+func (ctx *AppContext) helpCommand(req *RequestContext, args []string) error {
+	// With no arguments list every command, otherwise describe the one requested
+	if len(args) == 0 {
+		ctx.MessagePoster(req, Commands.HelpText(), "")
+		return nil
+	}
+	cmd, ok := Commands.Lookup(args[0])
+	if !ok {
+		ctx.MessagePoster(req, fmt.Sprintf("Unknown command: %s\n%s", args[0], Commands.HelpText()), "")
+		return nil
+	}
+	ctx.MessagePoster(req, cmd.HelpText(), "")
+	return nil
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "help",
+		Usage:       "[command]",
+		Description: "Display this help message, or the help for a command",
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.helpCommand(req, args.([]string))
+		},
+	})
+	Commands.Register(&Command{
+		Name:        "ping",
+		Description: "Check the bot is alive and how long messages take to reach it",
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			elapsedMs := time.Now().UnixMilli() - req.Timestamp
+			ctx.MessagePoster(req, fmt.Sprintf("Pong! Elapsed time: %d ms", elapsedMs), "")
+			return nil
+		},
+	})
+	Commands.Register(&Command{
+		Name:        "marco",
+		Description: "Polo!",
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			elapsedMs := time.Now().UnixMilli() - req.Timestamp
+			// Pick a random response from the list
+			responses := []string{"Polo!", "Polo! 🏊", "Tasty pollo! 🤽", "Polo? 🤽‍♂️", "....(polo) 🤽‍♀️", "Polloooooo! 🏊‍♂️", "POLO! 🏊‍♀️"}
+			ctx.MessagePoster(req, fmt.Sprintf("%s (%d ms)", responses[rand.IntN(len(responses))], elapsedMs), "")
+			return nil
+		},
+	})
+}
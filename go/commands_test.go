@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCommandRegistryLookup(t *testing.T) {
+	r := NewCommandRegistry()
+	r.Register(&Command{
+		Name:    "test",
+		Aliases: []string{"alias"},
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error { return nil },
+	})
+
+	for _, name := range []string{"test", "!test", "alias", "!alias"} {
+		cmd, ok := r.Lookup(name)
+		if !ok || cmd.Name != "test" {
+			t.Errorf("expected to find command test as %s", name)
+		}
+	}
+	if _, ok := r.Lookup("missing"); ok {
+		t.Errorf("expected missing command to not be found")
+	}
+
+	// Registering the same alias twice is a programming error
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected duplicate registration to panic")
+		}
+	}()
+	r.Register(&Command{
+		Name:    "other",
+		Aliases: []string{"alias"},
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error { return nil },
+	})
+}
+
+func TestDispatchCommand(t *testing.T) {
+	var replies []string
+	ctx := &AppContext{
+		MessagePoster: func(_ *RequestContext, message, _ string) {
+			replies = append(replies, message)
+		},
+	}
+	req := &RequestContext{}
+
+	tests := []struct {
+		name       string
+		msgBody    string
+		dispatched bool
+		reply      string
+	}{
+		{
+			name:       "Not a command",
+			msgBody:    "hello there",
+			dispatched: false,
+		},
+		{
+			name:       "Unknown command",
+			msgBody:    "!nosuchcommand",
+			dispatched: false,
+		},
+		{
+			name:       "Help for a single command",
+			msgBody:    "!help dream",
+			dispatched: true,
+			reply: "!imagine <text> - Generate an image (other options: !opine, !dream, !nightmare, !hallucinate, !trip)\n" +
+				"Aliases: !opine, !dream, !nightmare, !hallucinate, !trip",
+		},
+		{
+			name:       "Missing arguments replies with usage",
+			msgBody:    "!ask",
+			dispatched: true,
+			reply:      "Usage: !ask [time range] <question>",
+		},
+		{
+			name:       "Invalid arguments replies with the error and usage",
+			msgBody:    "!summary abc",
+			dispatched: true,
+			reply:      "I don't understand the time range \"abc\", " + timeRangeHelp + "\nUsage: !summary <num_msgs|time range|since-me> [dm]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies = nil
+			if got := ctx.dispatchCommand(req, tt.msgBody); got != tt.dispatched {
+				t.Fatalf("dispatchCommand() = %v, want %v", got, tt.dispatched)
+			}
+			if tt.reply == "" {
+				if len(replies) != 0 {
+					t.Errorf("expected no reply, got %q", replies)
+				}
+				return
+			}
+			if len(replies) != 1 || replies[0] != tt.reply {
+				t.Errorf("expected reply %q, got %q", tt.reply, replies)
+			}
+		})
+	}
+}
+
+func TestRequireArgs(t *testing.T) {
+	if _, err := requireArgs("ask", nil); !errors.Is(err, errMissingArgs) {
+		t.Errorf("expected errMissingArgs, got %v", err)
+	}
+	got, err := requireArgs("ask", []string{"what", "is", "this?"})
+	if err != nil || got != "what is this?" {
+		t.Errorf("requireArgs() = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	// Wednesday afternoon
+	now := time.Date(2024, 12, 4, 15, 30, 0, 0, loc)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"0 8 * * *", time.Date(2024, 12, 5, 8, 0, 0, 0, loc)},
+		{"45 15 * * *", time.Date(2024, 12, 4, 15, 45, 0, 0, loc)},
+		{"30 15 * * *", time.Date(2024, 12, 5, 15, 30, 0, 0, loc)},
+		{"*/20 * * * *", time.Date(2024, 12, 4, 15, 40, 0, 0, loc)},
+		{"0 9 * * mon-fri", time.Date(2024, 12, 5, 9, 0, 0, 0, loc)},
+		{"0 9 * * sat,sun", time.Date(2024, 12, 7, 9, 0, 0, 0, loc)},
+		{"0 9 * * 7", time.Date(2024, 12, 8, 9, 0, 0, 0, loc)},
+		{"0 0 1 * *", time.Date(2025, 1, 1, 0, 0, 0, 0, loc)},
+		{"0 0 1 jun *", time.Date(2025, 6, 1, 0, 0, 0, 0, loc)},
+		// Either day will do when both are restricted
+		{"0 12 25 * fri", time.Date(2024, 12, 6, 12, 0, 0, 0, loc)},
+		{"@daily", time.Date(2024, 12, 5, 0, 0, 0, 0, loc)},
+		{"@weekly", time.Date(2024, 12, 8, 0, 0, 0, 0, loc)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		spec, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): unexpected error: %v", test.expr, err)
+			continue
+		}
+		if next := spec.Next(now); !next.Equal(test.expected) {
+			t.Errorf("parseCron(%q).Next: expected %v, got %v", test.expr, test.expected, next)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "0 8 * *", "60 8 * * *", "0 24 * * *", "0 8 0 * *", "0 8 * 13 *", "0 8 * * 8", "0 8-6 * * *", "*/0 * * * *", "0 8 * * funday", "@sometimes"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q): expected an error", expr)
+		}
+	}
+}
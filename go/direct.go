@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// directPrefix starts the id direct messages with a member are stored under,
+// in place of a groupId. Group ids are base64, so can't contain a colon.
+const directPrefix = "direct:"
+
+// conversationId returns the id a message's history is kept under: its
+// group's id, or for a direct message, one for the sender. It's empty for
+// messages which aren't part of a conversation with the bot.
+func conversationId(envelope *Envelope, content *DataMessage) string {
+	if groupId := content.GroupId(); groupId != "" {
+		return groupId
+	}
+	// Our own direct messages to other people, synced from our other devices
+	if envelope.SyncMessage != nil {
+		return ""
+	}
+	member := memberId(envelope.SourceUuid, envelope.SourceNumber)
+	if member == "" {
+		return ""
+	}
+	return directPrefix + member
+}
+
+func isDirectConversation(groupId string) bool {
+	return strings.HasPrefix(groupId, directPrefix)
+}
+
+// recipientFor returns where messages to a conversation are sent
+func recipientFor(groupId string) string {
+	if isDirectConversation(groupId) {
+		return strings.TrimPrefix(groupId, directPrefix)
+	}
+	return encodeGroupIdToBase64(groupId)
+}
+
+// conversationFor returns the id of the conversation messages sent to
+// recipient are kept under, the reverse of recipientFor
+func conversationFor(recipient string) string {
+	if encoded, ok := strings.CutPrefix(recipient, "group."); ok {
+		if groupId, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			return string(groupId)
+		}
+	}
+	return directPrefix + recipient
+}
+
+func isConversationReply(req *RequestContext) bool {
+	// Whether replies to this request go to the conversation it came from,
+	// rather than privately to the sender. A direct message naming a group
+	// is still answered in the direct conversation.
+	return req.Conversation != "" && conversationFor(req.Recipient) == req.Conversation
+}
+
+// signalGroup is a group the bot's account is in, as listed by signal-cli-rest-api
+type signalGroup struct {
+	Name       string   `json:"name"`
+	Id         string   `json:"id"`          // group.<base64 groupId>
+	InternalId string   `json:"internal_id"` // The raw groupId
+	Members    []string `json:"members"`     // Phone numbers, or UUIDs for members who hide theirs
+}
+
+func fetchSignalGroups(traceCtx context.Context) ([]signalGroup, error) {
+	// List the groups from the REST API at {config.url}/v1/groups/{config.phone}
+	url := fmt.Sprintf("http://%s/v1/groups/%s", Config["URL"], url.PathEscape(Config["PHONE"]))
+	request, err := http.NewRequestWithContext(traceCtx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list groups: received status code %d: %s", resp.StatusCode, string(body))
+	}
+	var groups []signalGroup
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse groups: %w", err)
+	}
+	return groups, nil
+}
+
+// sharesGroup reports whether the sender is in any of the bot's groups. Only
+// they can message the bot directly, so strangers can't use it.
+func sharesGroup(traceCtx context.Context, req *RequestContext) (bool, error) {
+	groups, err := fetchSignalGroups(traceCtx)
+	if err != nil {
+		return false, err
+	}
+	for _, group := range groups {
+		for _, member := range group.Members {
+			if isMember(req, member) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// resolveGroupTarget finds the group named at the start of args, eg. "@Book
+// Club 12h", which the sender must be in. It returns the group's id and the
+// rest of args. Group names can have spaces in, so the longest match wins.
+func (ctx *AppContext) resolveGroupTarget(req *RequestContext, args []string) (string, []string, error) {
+	groups, err := fetchSignalGroups(req.TraceContext)
+	if err != nil {
+		return "", nil, fmt.Errorf("I couldn't look up your groups: %w", err)
+	}
+	for n := len(args); n > 0; n-- {
+		candidate := strings.TrimPrefix(strings.Join(args[:n], " "), "@")
+		for _, group := range groups {
+			if !strings.EqualFold(group.Name, candidate) {
+				continue
+			}
+			for _, member := range group.Members {
+				if isMember(req, member) {
+					return group.InternalId, args[n:], nil
+				}
+			}
+		}
+	}
+	return "", nil, errors.New("you're not in a group of mine with that name")
+}
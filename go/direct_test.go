@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDirectMessages(t *testing.T) {
+	// Fake the signal-cli REST API's list of groups
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/groups/+123456789" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`[{"name":"Book Club","id":"group.Z3JvdXBPbmU=","internal_id":"groupOne","members":["+15550001111","bob-uuid"]},` +
+			`{"name":"Book","id":"group.Z3JvdXBUd28=","internal_id":"groupTwo","members":["+15550001111"]}]`))
+	}))
+	defer server.Close()
+
+	Config = map[string]string{"BOTNAME": "Robo", "PHONE": "+123456789", "URL": strings.TrimPrefix(server.URL, "http://")}
+	ctx := &AppContext{Store: newTestStore(t), SummaryProvider: &debugProvider{}, TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, req.Recipient+": "+message)
+	}
+
+	now := time.Now().UnixMilli()
+	send := func(uuid, number, text string, groupId string, timestamp int64) {
+		content := &DataMessage{Timestamp: timestamp, Message: text}
+		if groupId != "" {
+			content.GroupInfo = &GroupInfo{GroupId: groupId}
+		}
+		ctx.processSignalMessage(&SignalMessage{Envelope: &Envelope{
+			SourceName: "Alice", SourceUuid: uuid, SourceNumber: number, Timestamp: timestamp, DataMessage: content,
+		}})
+	}
+	send("alice-uuid", "+15550001111", "chapter three was great", "groupOne", now-5000)
+
+	// Direct messages are a conversation of their own, and replies go to the sender
+	replies = nil
+	send("alice-uuid", "+15550001111", "!ping", "", now-4000)
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "alice-uuid: Pong!") {
+		t.Errorf("expected a private reply, got %q", replies)
+	}
+	messages, err := ctx.Store.FetchRange(context.Background(), "direct:alice-uuid", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Message != "!ping" {
+		t.Errorf("expected the direct message to be stored, got %+v", messages)
+	}
+
+	// Commands can be used on the sender's groups, with the longest matching name winning
+	replies = nil
+	send("alice-uuid", "+15550001111", "!summary @book club 12h", "", now-3000)
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "alice-uuid: ") || !strings.Contains(replies[0], "chapter three was great") {
+		t.Errorf("expected a private summary of the group, got %q", replies)
+	}
+	if lastSeen, _ := ctx.Store.LastSeen(context.Background(), "groupOne", "alice-uuid"); lastSeen != now-5000 {
+		t.Errorf("expected a direct message not to count as being seen in the group, got %d", lastSeen)
+	}
+
+	// But only groups they're in, and only commands which read a group's history
+	replies = nil
+	send("alice-uuid", "+15550001111", "!summary @Poetry 12h", "", now-2500)
+	send("alice-uuid", "+15550001111", "!config @Book Club", "", now-1000)
+	if len(replies) != 2 || replies[0] != "alice-uuid: Sorry, you're not in a group of mine with that name." ||
+		replies[1] != "alice-uuid: Sorry, only the bot's owners can use !config in a direct message." {
+		t.Errorf("unexpected replies %q", replies)
+	}
+
+	// Strangers who know the bot's number are ignored
+	replies = nil
+	send("carol-uuid", "+15550003333", "!summary @Book Club 12h", "", now-2000)
+	if len(replies) != 0 {
+		t.Errorf("expected a stranger's direct message to be ignored, got %q", replies)
+	}
+	if messages, _ := ctx.Store.FetchRange(context.Background(), "direct:carol-uuid", 0, 0); len(messages) != 0 {
+		t.Errorf("expected a stranger's direct message not to be stored, got %+v", messages)
+	}
+
+	// Our own direct messages to other people, from our other devices, aren't for the bot
+	replies = nil
+	ctx.processSignalMessage(&SignalMessage{Envelope: &Envelope{
+		SourceName: "Robo", SourceNumber: "+123456789", Timestamp: now,
+		SyncMessage: &SyncMessage{SentMessage: &SentMessage{DataMessage: DataMessage{Message: "!ping"}, Destination: "+15550001111"}},
+	}})
+	if len(replies) != 0 {
+		t.Errorf("expected our own direct messages to be ignored, got %q", replies)
+	}
+}
+
+func TestGroupCommandsInDirectMessagesAreAnsweredThere(t *testing.T) {
+	// Fake the signal-cli REST API's list of groups and send endpoint
+	var payloads []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/groups/+123456789" {
+			w.Write([]byte(`[{"name":"Book Club","id":"group.Z3JvdXBPbmU=","internal_id":"groupOne","members":["+15550001111"]}]`))
+			return
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected body: %v", err)
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"timestamp":"1733066028521"}`))
+	}))
+	defer server.Close()
+
+	Config = map[string]string{"BOTNAME": "Robo", "PHONE": "+123456789", "URL": strings.TrimPrefix(server.URL, "http://")}
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	ctx.MessagePoster = ctx.sendMessage
+	ctx.processSignalMessage(&SignalMessage{Envelope: &Envelope{
+		SourceName: "Alice", SourceUuid: "alice-uuid", SourceNumber: "+15550001111", Timestamp: 1733066000000,
+		DataMessage: &DataMessage{Timestamp: 1733066000000, Message: "!usage @Book Club"},
+	}})
+
+	// The reply is about the group, but goes to the direct conversation the
+	// command came from, so it quotes the command and is kept with it
+	if len(payloads) != 1 {
+		t.Fatalf("expected one reply, got %v", payloads)
+	}
+	if recipients := payloads[0]["recipients"].([]any); len(recipients) != 1 || recipients[0] != "alice-uuid" ||
+		payloads[0]["quote_timestamp"] != float64(1733066000000) || payloads[0]["quote_message"] != "!usage @Book Club" {
+		t.Errorf("expected a private reply quoting the command, got %v", payloads[0])
+	}
+	messages, err := ctx.Store.FetchRange(context.Background(), "direct:alice-uuid", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 2 || messages[1].SourceName != "Robo" || messages[1].ParentId != messages[0].Id {
+		t.Errorf("expected the reply to be stored as a reply to the command, got %+v", messages)
+	}
+	if group, _ := ctx.Store.FetchRange(context.Background(), "groupOne", 0, 0); len(group) != 0 {
+		t.Errorf("expected nothing to be stored in the group, got %+v", group)
+	}
+}
+
+func TestRecipientFor(t *testing.T) {
+	if recipient := recipientFor("direct:alice-uuid"); recipient != "alice-uuid" {
+		t.Errorf("expected direct messages to go to the member, got %s", recipient)
+	}
+	if recipient := recipientFor("groupOne"); recipient != encodeGroupIdToBase64("groupOne") {
+		t.Errorf("expected group messages to go to the group, got %s", recipient)
+	}
+	for _, conversation := range []string{"direct:alice-uuid", "groupOne"} {
+		if got := conversationFor(recipientFor(conversation)); got != conversation {
+			t.Errorf("expected messages to %s to be kept under %s, got %s", recipientFor(conversation), conversation, got)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// EmbeddingProvider turns text into vectors, so messages can be found by
+// what they mean rather than the exact words used.
+// Providers are created once at startup and must be safe for concurrent use.
+type EmbeddingProvider interface {
+	// Name returns the name the provider was registered with
+	Name() string
+	// Model returns the model used. Vectors from different models can't be compared.
+	Model() string
+	// Embed returns one vector for each of texts, in the same order
+	Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error)
+}
+
+// EmbeddingResponse is the vectors made by a provider
+type EmbeddingResponse struct {
+	Vectors [][]float32
+	Usage   Usage // What the request used, zero if the provider doesn't say
+}
+
+type embeddingProviderFactory func(opts llmProviderOptions) (EmbeddingProvider, error)
+
+// embeddingProviders holds every embedding provider, keyed by the name used in
+// EMBEDDING_PROVIDER. Providers register themselves from an init() function in
+// the same file as their LLMProvider.
+var embeddingProviders = map[string]embeddingProviderFactory{}
+
+func registerEmbeddingProvider(name string, factory embeddingProviderFactory) {
+	if _, ok := embeddingProviders[name]; ok {
+		panic(fmt.Sprintf("embedding provider %s is already registered", name))
+	}
+	embeddingProviders[name] = factory
+}
+
+func newEmbeddingProvider(name string, opts llmProviderOptions) (EmbeddingProvider, error) {
+	factory, ok := embeddingProviders[name]
+	if !ok {
+		var names []string
+		for name := range embeddingProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown embedding provider %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return factory(opts)
+}
+
+// encodeVector packs a vector into bytes for storage
+func encodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+	}
+	return buf
+}
+
+// decodeVector unpacks a vector stored by encodeVector
+func decodeVector(buf []byte) ([]float32, error) {
+	if len(buf)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector of %d bytes", len(buf))
+	}
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return vector, nil
+}
+
+// cosineSimilarity returns how alike two vectors are, from -1 to 1.
+// Vectors of different lengths, or with no magnitude, are never alike.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// ScoredMessage is a message and how closely it matched a query
+type ScoredMessage struct {
+	MessageId int64
+	Timestamp int64
+	Score     float64
+}
+
+// topKSimilar returns the k embeddings most similar to query, best first
+func topKSimilar(query []float32, embeddings []MessageEmbedding, k int) []ScoredMessage {
+	scored := make([]ScoredMessage, 0, len(embeddings))
+	for _, embedding := range embeddings {
+		scored = append(scored, ScoredMessage{
+			MessageId: embedding.MessageId,
+			Timestamp: embedding.Timestamp,
+			Score:     cosineSimilarity(query, embedding.Vector),
+		})
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+	return scored
+}
+
+// debugEmbeddingDims is the size of the vectors made by the debug provider
+const debugEmbeddingDims = 256
+
+// debugEmbeddingProvider hashes each word into a bucket, so messages sharing
+// words are similar. It's no good at meaning, but it's deterministic and
+// handy for testing without an API key.
+type debugEmbeddingProvider struct{}
+
+func (p *debugEmbeddingProvider) Name() string {
+	return "debug"
+}
+
+func (p *debugEmbeddingProvider) Model() string {
+	return "debug"
+}
+
+func (p *debugEmbeddingProvider) Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error) {
+	var vectors [][]float32
+	for _, text := range texts {
+		vector := make([]float32, debugEmbeddingDims)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vector[h.Sum32()%debugEmbeddingDims]++
+		}
+		vectors = append(vectors, vector)
+	}
+	return &EmbeddingResponse{Vectors: vectors}, nil
+}
+
+func init() {
+	registerEmbeddingProvider("debug", func(opts llmProviderOptions) (EmbeddingProvider, error) {
+		return &debugEmbeddingProvider{}, nil
+	})
+}
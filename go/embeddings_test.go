@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestEncodeVector(t *testing.T) {
+	vector := []float32{0, 1.5, -2.25, float32(math.Pi)}
+	decoded, err := decodeVector(encodeVector(vector))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(decoded) != len(vector) {
+		t.Fatalf("expected %d values, got %d", len(vector), len(decoded))
+	}
+	for i := range vector {
+		if decoded[i] != vector[i] {
+			t.Errorf("value %d: expected %v, got %v", i, vector[i], decoded[i])
+		}
+	}
+	if _, err := decodeVector([]byte{1, 2, 3}); err == nil {
+		t.Errorf("expected an error for a truncated vector")
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     []float32
+		expected float64
+	}{
+		{[]float32{1, 0}, []float32{2, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 1}, 0},
+		{[]float32{1, 0}, []float32{-1, 0}, -1},
+		{[]float32{1, 0}, []float32{1, 0, 0}, 0},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, test := range tests {
+		result := cosineSimilarity(test.a, test.b)
+		if math.Abs(result-test.expected) > 1e-9 {
+			t.Errorf("cosineSimilarity(%v, %v): expected %v, got %v", test.a, test.b, test.expected, result)
+		}
+	}
+}
+
+func TestTopKSimilar(t *testing.T) {
+	embeddings := []MessageEmbedding{
+		{MessageId: 1, Vector: []float32{0, 1}},
+		{MessageId: 2, Vector: []float32{1, 0}},
+		{MessageId: 3, Vector: []float32{1, 1}},
+	}
+	hits := topKSimilar([]float32{1, 0.1}, embeddings, 2)
+	if len(hits) != 2 || hits[0].MessageId != 2 || hits[1].MessageId != 3 {
+		t.Errorf("unexpected hits %+v", hits)
+	}
+}
+
+func TestDebugEmbeddingProvider(t *testing.T) {
+	embedder, err := newEmbeddingProvider("debug", llmProviderOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := embedder.Embed(context.Background(), []string{"Pizza on Friday?", "friday PIZZA", "the weather is nice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cosineSimilarity(resp.Vectors[0], resp.Vectors[1]) <= cosineSimilarity(resp.Vectors[0], resp.Vectors[2]) {
+		t.Errorf("expected messages sharing words to be more similar")
+	}
+
+	if _, err := newEmbeddingProvider("nope", llmProviderOptions{}); err == nil {
+		t.Errorf("expected an error for an unknown provider")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// The types in this file model the JSON messages sent by signal-cli-rest-api,
+// both over the json-rpc websocket and from the /v1/receive REST endpoint.
+// Every field is optional in practice, so nested messages are pointers and
+// callers must check for nil.
+
+// SignalMessage is the top level object for every received message
+type SignalMessage struct {
+	Envelope *Envelope `json:"envelope"`
+	Account  string    `json:"account"`
+}
+
+// Envelope wraps exactly one kind of message: data, edit, sync, receipt or typing
+type Envelope struct {
+	Source                   string          `json:"source"`
+	SourceNumber             string          `json:"sourceNumber"`
+	SourceUuid               string          `json:"sourceUuid"`
+	SourceName               string          `json:"sourceName"`
+	SourceDevice             int             `json:"sourceDevice"`
+	Timestamp                int64           `json:"timestamp"`
+	ServerReceivedTimestamp  int64           `json:"serverReceivedTimestamp,omitempty"`
+	ServerDeliveredTimestamp int64           `json:"serverDeliveredTimestamp,omitempty"`
+	DataMessage              *DataMessage    `json:"dataMessage,omitempty"`
+	EditMessage              *EditMessage    `json:"editMessage,omitempty"`
+	SyncMessage              *SyncMessage    `json:"syncMessage,omitempty"`
+	ReceiptMessage           *ReceiptMessage `json:"receiptMessage,omitempty"`
+	TypingMessage            *TypingMessage  `json:"typingMessage,omitempty"`
+}
+
+// DataMessage is a message sent by another member, to a group or directly to us
+type DataMessage struct {
+	Timestamp        int64         `json:"timestamp"`
+	Message          string        `json:"message"`
+	ExpiresInSeconds int           `json:"expiresInSeconds"`
+	ViewOnce         bool          `json:"viewOnce"`
+	Attachments      []Attachment  `json:"attachments,omitempty"`
+	GroupInfo        *GroupInfo    `json:"groupInfo,omitempty"`
+	Mentions         []Mention     `json:"mentions,omitempty"`
+	Quote            *Quote        `json:"quote,omitempty"`
+	Reaction         *Reaction     `json:"reaction,omitempty"`
+	RemoteDelete     *RemoteDelete `json:"remoteDelete,omitempty"`
+	Sticker          *Sticker      `json:"sticker,omitempty"`
+	Previews         []Preview     `json:"previews,omitempty"`
+}
+
+// EditMessage replaces the text of a previously sent message
+type EditMessage struct {
+	TargetSentTimestamp int64        `json:"targetSentTimestamp"`
+	DataMessage         *DataMessage `json:"dataMessage"`
+}
+
+// SyncMessage is sent by our own linked devices
+type SyncMessage struct {
+	SentMessage  *SentMessage  `json:"sentMessage,omitempty"`
+	ReadMessages []ReadMessage `json:"readMessages,omitempty"`
+}
+
+// SentMessage is a message our account sent from another device.
+// It carries the same fields as a DataMessage, plus the destination.
+type SentMessage struct {
+	DataMessage
+	Destination       string       `json:"destination,omitempty"`
+	DestinationNumber string       `json:"destinationNumber,omitempty"`
+	DestinationUuid   string       `json:"destinationUuid,omitempty"`
+	EditMessage       *EditMessage `json:"editMessage,omitempty"`
+}
+
+// ReadMessage says that our account read a message on another device
+type ReadMessage struct {
+	Sender       string `json:"sender"`
+	SenderNumber string `json:"senderNumber"`
+	SenderUuid   string `json:"senderUuid"`
+	Timestamp    int64  `json:"timestamp"`
+}
+
+// ReceiptMessage says that a message we sent was delivered, read or viewed
+type ReceiptMessage struct {
+	When       int64   `json:"when"`
+	IsDelivery bool    `json:"isDelivery"`
+	IsRead     bool    `json:"isRead"`
+	IsViewed   bool    `json:"isViewed"`
+	Timestamps []int64 `json:"timestamps"`
+}
+
+// TypingMessage says that someone started or stopped typing
+type TypingMessage struct {
+	Action    string `json:"action"` // STARTED or STOPPED
+	Timestamp int64  `json:"timestamp"`
+	GroupId   string `json:"groupId,omitempty"`
+}
+
+type GroupInfo struct {
+	GroupId   string `json:"groupId"`
+	GroupName string `json:"groupName,omitempty"`
+	Revision  int    `json:"revision,omitempty"`
+	Type      string `json:"type,omitempty"`
+}
+
+type Attachment struct {
+	ContentType     string `json:"contentType"`
+	Filename        string `json:"filename"`
+	Id              string `json:"id"`
+	Size            int64  `json:"size"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	Caption         string `json:"caption"`
+	UploadTimestamp int64  `json:"uploadTimestamp"`
+}
+
+type Mention struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+	Uuid   string `json:"uuid"`
+	Start  int    `json:"start"`
+	Length int    `json:"length"`
+}
+
+// Quote is the message being replied to
+type Quote struct {
+	Id           int64             `json:"id"` // The timestamp of the quoted message
+	Author       string            `json:"author"`
+	AuthorNumber string            `json:"authorNumber"`
+	AuthorUuid   string            `json:"authorUuid"`
+	Text         string            `json:"text"`
+	Attachments  []QuoteAttachment `json:"attachments,omitempty"`
+	Mentions     []Mention         `json:"mentions,omitempty"`
+}
+
+type QuoteAttachment struct {
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+}
+
+// Reaction is an emoji reaction to, or the removal of a reaction from, another message
+type Reaction struct {
+	Emoji               string `json:"emoji"`
+	TargetAuthor        string `json:"targetAuthor"`
+	TargetAuthorNumber  string `json:"targetAuthorNumber"`
+	TargetAuthorUuid    string `json:"targetAuthorUuid"`
+	TargetSentTimestamp int64  `json:"targetSentTimestamp"`
+	IsRemove            bool   `json:"isRemove"`
+}
+
+// RemoteDelete is a request to delete a previously sent message
+type RemoteDelete struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
+type Sticker struct {
+	PackId    string `json:"packId"`
+	StickerId int    `json:"stickerId"`
+}
+
+type Preview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// decodeSignalMessage parses a single message from signal-cli-rest-api.
+// Unlike a bare type assertion, unexpected payloads return an error rather than panicking.
+func decodeSignalMessage(data []byte) (*SignalMessage, error) {
+	var msg SignalMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	if msg.Envelope == nil {
+		return nil, errors.New("message has no envelope")
+	}
+	return &msg, nil
+}
+
+// Content returns the data message carried by the envelope, whether it was
+// sent by someone else or synced from one of our own devices.
+// It returns nil for receipts, typing indicators and other non-data messages.
+func (e *Envelope) Content() *DataMessage {
+	if e.DataMessage != nil {
+		return e.DataMessage
+	}
+	if e.SyncMessage != nil && e.SyncMessage.SentMessage != nil {
+		return &e.SyncMessage.SentMessage.DataMessage
+	}
+	return nil
+}
+
+// Edit returns the edit carried by the envelope, whether it was made by
+// someone else or synced from one of our own devices, or nil if it isn't one.
+func (e *Envelope) Edit() *EditMessage {
+	if e.EditMessage != nil {
+		return e.EditMessage
+	}
+	if e.SyncMessage != nil && e.SyncMessage.SentMessage != nil {
+		return e.SyncMessage.SentMessage.EditMessage
+	}
+	return nil
+}
+
+// Sender returns the best name we have for whoever sent the envelope.
+// Not every envelope has a sourceName, so fall back to the number and then the UUID.
+func (e *Envelope) Sender() string {
+	for _, name := range []string{e.SourceName, e.SourceNumber, e.SourceUuid, e.Source} {
+		if name != "" {
+			return name
+		}
+	}
+	return "Unknown"
+}
+
+// GroupId returns the raw groupId of the message, or an empty string if it wasn't sent to a group
+func (d *DataMessage) GroupId() string {
+	if d.GroupInfo == nil {
+		return ""
+	}
+	return d.GroupInfo.GroupId
+}
+
+// decodeSignalMessages parses the array of messages returned by the /v1/receive REST endpoint.
+// Messages which can't be decoded are logged and skipped so one bad message doesn't
+// lose the rest of the batch.
+func decodeSignalMessages(data []byte) ([]*SignalMessage, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal messages: %w", err)
+	}
+	messages := make([]*SignalMessage, 0, len(raw))
+	for _, item := range raw {
+		msg, err := decodeSignalMessage(item)
+		if err != nil {
+			log.Printf("Skipping message: %v\n%s\n", err, string(item))
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
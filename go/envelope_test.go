@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecodeSignalMessage(t *testing.T) {
+	// Define the test data
+	testData := `{
+        "envelope": {
+            "source": "+1234567890",
+            "sourceNumber": "+1234567890",
+            "sourceUuid": "<fake_uuid>",
+            "sourceName": "Test User",
+            "sourceDevice": 1,
+            "timestamp": 1733066028521,
+            "dataMessage": {
+                "timestamp": 1733066028521,
+                "message": "Testing messages",
+                "expiresInSeconds": 0,
+                "viewOnce": false,
+                "attachments": [
+                    {
+                        "contentType": "image/jpeg",
+                        "filename": "galaxy.jpg",
+                        "id": "r4aFDRWmi_z2dfVh5iqC.jpg",
+                        "size": 273635,
+                        "width": 2048,
+                        "height": 2048,
+                        "caption": null,
+                        "uploadTimestamp": null
+                    }
+                ],
+                "mentions": [
+                    {"name": "+1987654321", "number": "+1987654321", "uuid": "<bot_uuid>", "start": 0, "length": 1}
+                ],
+                "quote": {
+                    "id": 1733066000000,
+                    "author": "+1987654321",
+                    "authorNumber": "+1987654321",
+                    "authorUuid": "<bot_uuid>",
+                    "text": "Quoted text",
+                    "attachments": []
+                },
+                "groupInfo": {
+                    "groupId": "VGVzdA==",
+                    "type": "DELIVER"
+                }
+            }
+        },
+        "account": "+1234567890"
+    }`
+
+	msg, err := decodeSignalMessage([]byte(testData))
+	if err != nil {
+		t.Fatalf("decodeSignalMessage returned an error: %v", err)
+	}
+
+	content := msg.Envelope.Content()
+	if content == nil {
+		t.Fatalf("Expected content to be non-nil")
+	}
+	if content.Message != "Testing messages" {
+		t.Errorf("Expected message %s, got %s", "Testing messages", content.Message)
+	}
+	if content.GroupId() != "VGVzdA==" {
+		t.Errorf("Expected groupId %s, got %s", "VGVzdA==", content.GroupId())
+	}
+	if len(content.Attachments) != 1 || content.Attachments[0].Id != "r4aFDRWmi_z2dfVh5iqC.jpg" {
+		t.Errorf("Unexpected attachments: %+v", content.Attachments)
+	}
+	if len(content.Mentions) != 1 || content.Mentions[0].Number != "+1987654321" {
+		t.Errorf("Unexpected mentions: %+v", content.Mentions)
+	}
+	if content.Quote == nil || content.Quote.Id != 1733066000000 || content.Quote.Text != "Quoted text" {
+		t.Errorf("Unexpected quote: %+v", content.Quote)
+	}
+}
+
+func TestDecodeSignalMessageKinds(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		expectErr  bool
+		hasContent bool
+		sender     string
+	}{
+		{
+			name:      "Invalid JSON",
+			data:      `{"envelope":`,
+			expectErr: true,
+		},
+		{
+			name:      "No envelope",
+			data:      `{"account":"+1234567890"}`,
+			expectErr: true,
+		},
+		{
+			name:      "Wrong types",
+			data:      `{"envelope":{"timestamp":"yesterday"}}`,
+			expectErr: true,
+		},
+		{
+			name:   "Read receipt",
+			data:   `{"envelope":{"sourceNumber":"+1234567890","timestamp":1,"receiptMessage":{"when":1,"isRead":true,"timestamps":[1]}}}`,
+			sender: "+1234567890",
+		},
+		{
+			name:   "Typing indicator without a sourceName",
+			data:   `{"envelope":{"sourceUuid":"<fake_uuid>","timestamp":1,"typingMessage":{"action":"STARTED","timestamp":1}}}`,
+			sender: "<fake_uuid>",
+		},
+		{
+			name:       "Sync message",
+			data:       `{"envelope":{"sourceName":"Me","timestamp":1,"syncMessage":{"sentMessage":{"message":"hi","groupInfo":{"groupId":"VGVzdA=="}}}}}`,
+			hasContent: true,
+			sender:     "Me",
+		},
+		{
+			name:   "Sync read messages",
+			data:   `{"envelope":{"sourceName":"Me","timestamp":1,"syncMessage":{"readMessages":[{"sender":"+1","timestamp":1}]}}}`,
+			sender: "Me",
+		},
+		{
+			name:       "Reaction",
+			data:       `{"envelope":{"sourceName":"Test User","timestamp":1,"dataMessage":{"reaction":{"emoji":"👍","targetSentTimestamp":1,"isRemove":false}}}}`,
+			hasContent: true,
+			sender:     "Test User",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := decodeSignalMessage([]byte(tt.data))
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("decodeSignalMessage() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if (msg.Envelope.Content() != nil) != tt.hasContent {
+				t.Errorf("Content() = %v, want content %v", msg.Envelope.Content(), tt.hasContent)
+			}
+			if msg.Envelope.Sender() != tt.sender {
+				t.Errorf("Sender() = %s, want %s", msg.Envelope.Sender(), tt.sender)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The reactions the bot acknowledges slow commands with
+const (
+	reactionWorking = "⏳"
+	reactionDone    = "✅"
+	reactionFailed  = "❌"
+)
+
+// typingRefreshInterval is how often the typing indicator is sent again while
+// the bot is working. Signal stops showing it after about 15 seconds.
+const typingRefreshInterval = 10 * time.Second
+
+func (ctx *AppContext) react(req *RequestContext, emoji string) {
+	// Scheduled digests have no message to react to
+	if ctx.ReactionSender == nil || memberId(req.SourceUuid, req.SourceNumber) == "" {
+		return
+	}
+	ctx.ReactionSender(req, emoji)
+}
+
+// showTyping shows the bot typing in the request's conversation until the
+// returned function is called
+func (ctx *AppContext) showTyping(req *RequestContext) func() {
+	if ctx.TypingIndicator == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(typingRefreshInterval)
+		defer ticker.Stop()
+		ctx.TypingIndicator(req, true)
+		for {
+			select {
+			case <-done:
+				ctx.TypingIndicator(req, false)
+				return
+			case <-ticker.C:
+				ctx.TypingIndicator(req, true)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (ctx *AppContext) sendReaction(req *RequestContext, emoji string) {
+	// React to the message which triggered the request. Each member has one
+	// reaction on a message, so this replaces any the bot already made.
+	payload := map[string]any{
+		"reaction":      emoji,
+		"recipient":     req.Recipient,
+		"target_author": memberId(req.SourceUuid, req.SourceNumber),
+		"timestamp":     req.Timestamp,
+	}
+	if err := callSignalAPI(req.TraceContext, "POST", "/v1/reactions/", payload); err != nil {
+		log.Println("Failed to send reaction:", err)
+	}
+}
+
+func (ctx *AppContext) sendTyping(req *RequestContext, typing bool) {
+	method := "PUT"
+	if !typing {
+		method = "DELETE"
+	}
+	payload := map[string]any{"recipient": req.Recipient}
+	if err := callSignalAPI(req.TraceContext, method, "/v1/typing-indicator/", payload); err != nil {
+		log.Println("Failed to send typing indicator:", err)
+	}
+}
+
+func callSignalAPI(traceCtx context.Context, method string, path string, payload map[string]any) error {
+	// Send payload to {config.url}{path}{config.phone}, for endpoints which only reply with a status
+	if traceCtx == nil {
+		traceCtx = context.Background()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	request, err := http.NewRequestWithContext(traceCtx, method,
+		"http://"+Config["URL"]+path+url.PathEscape(Config["PHONE"]), bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("received status code %d: %s", res.StatusCode, string(resBody))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSlowCommandsAreAcknowledged(t *testing.T) {
+	Config = map[string]string{"SUMMARY_PROVIDER": "debug", "IMAGE_GEN_PROVIDER": "none"}
+	ctx := &AppContext{Store: newTestStore(t), SummaryProvider: &debugProvider{}, TraceContext: context.Background()}
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {}
+	var mu sync.Mutex
+	var events, typingIn []string
+	ctx.ReactionSender = func(req *RequestContext, emoji string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, emoji)
+	}
+	ctx.TypingIndicator = func(req *RequestContext, typing bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		typingIn = append(typingIn, req.Recipient)
+		if typing {
+			events = append(events, "typing")
+		} else {
+			events = append(events, "stopped")
+		}
+	}
+	req := func() *RequestContext {
+		return &RequestContext{GroupId: "groupOne", Recipient: encodeGroupIdToBase64("groupOne"), SourceUuid: "alice-uuid",
+			Timestamp: 1000, TraceContext: context.Background()}
+	}
+	if err := ctx.Store.SaveMessage(context.Background(), &StoredMessage{Timestamp: 500, SourceName: "Bob", Message: "hello", GroupId: "groupOne"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx.dispatchCommand(req(), "!summary 10")
+	if strings.Join(events, " ") != "⏳ typing stopped ✅" {
+		t.Errorf("unexpected events %q", events)
+	}
+
+	events = nil
+	ctx.dispatchCommand(req(), "!imagine a cat")
+	if strings.Join(events, " ") != "⏳ typing stopped ❌" {
+		t.Errorf("expected a failed command to be marked, got %q", events)
+	}
+
+	// Quick commands are just answered
+	events = nil
+	ctx.dispatchCommand(req(), "!ping")
+	if len(events) != 0 {
+		t.Errorf("expected no reactions to !ping, got %q", events)
+	}
+
+	// The bot is seen typing where the reply will appear, but reacts to the command where it was sent
+	events, typingIn = nil, nil
+	ctx.dispatchCommand(req(), "!summary 10 dm")
+	if strings.Join(events, " ") != "⏳ typing stopped ✅" || strings.Join(typingIn, " ") != "alice-uuid alice-uuid" {
+		t.Errorf("expected a private summary to show typing to the sender, got %q in %q", events, typingIn)
+	}
+}
+
+func TestSendReaction(t *testing.T) {
+	// Fake the signal-cli REST API's reaction and typing endpoints
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected body: %v", err)
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/v1/reactions/+123456789" &&
+			(payload["reaction"] != "✅" || payload["target_author"] != "alice-uuid" || payload["timestamp"] != float64(1000)) {
+			t.Errorf("unexpected reaction %v", payload)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	Config = map[string]string{"PHONE": "+123456789", "URL": strings.TrimPrefix(server.URL, "http://")}
+	ctx := &AppContext{}
+	req := &RequestContext{GroupId: "groupOne", Recipient: encodeGroupIdToBase64("groupOne"), SourceUuid: "alice-uuid",
+		SourceNumber: "+15550001111", Timestamp: 1000, TraceContext: context.Background()}
+	ctx.sendReaction(req, "✅")
+	ctx.sendTyping(req, true)
+	ctx.sendTyping(req, false)
+	expected := "POST /v1/reactions/+123456789, PUT /v1/typing-indicator/+123456789, DELETE /v1/typing-indicator/+123456789"
+	if strings.Join(requests, ", ") != expected {
+		t.Errorf("expected %q, got %q", expected, requests)
+	}
+}
+
+func TestOwnReactionsAreNotStored(t *testing.T) {
+	Config = map[string]string{"PHONE": "+123456789"}
+	store := newTestStore(t)
+	ctx := &AppContext{Store: store, TraceContext: context.Background()}
+	msg := StoredMessage{Timestamp: 1000, SourceName: "Alice", SourceUuid: "alice-uuid", Message: "!summary", GroupId: "groupOne"}
+	if err := store.SaveMessage(context.Background(), &msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx.processMessage(`{"envelope":{"sourceNumber":"+123456789","sourceUuid":"bot-uuid","timestamp":2000,"syncMessage":{"sentMessage":` +
+		`{"reaction":{"emoji":"⏳","targetAuthorUuid":"alice-uuid","targetSentTimestamp":1000},"groupInfo":{"groupId":"groupOne"}}}}}`)
+	messages, err := store.FetchRange(context.Background(), "groupOne", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 || len(messages[0].Reactions) != 0 {
+		t.Errorf("expected the bot's own reaction not to be stored, got %+v", messages)
+	}
+}
@@ -5,7 +5,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -17,6 +17,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/otel"
 )
@@ -30,164 +31,60 @@ func makeOutputDir(dir string) error {
 	return nil
 }
 
-func getMessageRoot(message string) (map[string]interface{}, map[string]interface{}, error) {
-	// Parse the message and return the root message object
-
-	var msgStruct map[string]interface{}
-	container := make(map[string]interface{})
-	err := json.Unmarshal([]byte(message), &container)
-	if err != nil {
-		log.Printf("Failed to unmarshal message: %s\n%s\n", message, err)
-		return nil, nil, err
-	}
-
-	if dataMessage, ok := container["envelope"].(map[string]interface{})["dataMessage"]; ok {
-		msgStruct = dataMessage.(map[string]interface{})
-	} else if syncMessage, ok := container["envelope"].(map[string]interface{})["syncMessage"]; ok {
-		if sentMessage, ok := syncMessage.(map[string]interface{})["sentMessage"]; ok {
-			msgStruct = sentMessage.(map[string]interface{})
-		} else {
-			return nil, nil, nil
-		}
-	}
-	return container, msgStruct, nil
-}
-
-func getMentions(msgStruct map[string]interface{}) []map[string]string {
-	// Get the mentions from the message. They look like:
-	// "mentions":[{"name":"+13604644445","number":"+13604644445","uuid":"eaf45c04-21ee-4a66-938e-5711e44c5c02","start":0,"length":1}]
-	mentions := make([]map[string]string, 0)
-	if mentionsData, ok := msgStruct["mentions"].([]interface{}); ok {
-		for _, mention := range mentionsData {
-			mentionMap := mention.(map[string]interface{})
-			if mentionMap["number"] == nil {
-				mentionMap["number"] = mentionMap["name"]
-			}
-			mentions = append(mentions, map[string]string{
-				"name":   mentionMap["name"].(string),
-				"number": mentionMap["number"].(string),
-			})
-		}
-	}
-	return mentions
-}
-
 func encodeGroupIdToBase64(groupId string) string {
 	// Convert the groupId to base64
 	groupIdBase64 := base64.StdEncoding.EncodeToString([]byte(groupId))
 	return fmt.Sprintf("group.%s", groupIdBase64)
 }
 
-func (ctx *AppContext) dbWorker() {
-	// Open the database connection
-	db, err := sql.Open("sqlite3", Config["STATEDB"])
-	if err != nil {
-		log.Println("Failed to open database:", err)
-		return
-	}
-	defer db.Close()
-
-	// Loop forever, processing messages from the channel
-	for {
-		func() {
-			query := <-ctx.DbQueryChan
-			// Process the message
-			stmt, prep_err := db.Prepare(query.query)
-			if prep_err != nil {
-				log.Println("Failed to prepare statement:", err)
-				return
-			}
-			defer stmt.Close()
-
-			// If the query is a SELECT, we'll use stmt.Query.
-			// If the query is anything else, we'll use stmt.Exec.
-			if query.replyChan == nil {
-				_, exec_err := stmt.Exec(query.values...)
-				if exec_err != nil {
-					log.Println("Failed to execute statement:", err)
-					return
-				}
-				return
-			}
-
-			rows, query_err := stmt.Query(query.values...)
-			if query_err != nil {
-				log.Println("Failed to execute statement:", err)
-				return
-			}
-			// If we got rows back we need to put them on the response channel
-			if rows != nil && query.replyChan != nil {
-				query.replyChan <- dbReply{rows, nil}
-			}
-		}()
-	}
-}
-
-func (ctx *AppContext) saveMessage(container map[string]interface{}, msgStruct map[string]interface{}, mentions []map[string]string) {
+func (ctx *AppContext) saveMessage(req *RequestContext, message string, mentions []Mention, quote *Quote) error {
 	// Start a new span
 	tracer := otel.Tracer("signal-bot")
-	_, span := tracer.Start(ctx.TraceContext, "saveMessage")
+	saveCtx, span := tracer.Start(req.TraceContext, "saveMessage")
 	defer span.End()
 
-	// Marshal the mentions to a string
-	mentionsJson, err := json.Marshal(mentions)
-	if err != nil {
-		log.Println("Failed to marshal mentions:", err)
-		return
+	// Persist the message to the database.
+	// sourceNumber is empty for members who hide their phone number.
+	msg := StoredMessage{
+		Timestamp:    req.Timestamp,
+		SourceNumber: req.SourceNumber,
+		SourceName:   req.SourceName,
+		SourceUuid:   req.SourceUuid,
+		Message:      message,
+		GroupId:      req.GroupId,
+		Mentions:     mentions,
+	}
+	// Replies are linked to the message they quoted, so threads can be followed
+	if quote != nil {
+		msg.ParentId, msg.QuoteAuthor, msg.QuoteText = ctx.resolveQuote(saveCtx, req.GroupId, quote)
+	}
+	if err := ctx.Store.SaveMessage(saveCtx, &msg); err != nil {
+		return err
 	}
-	// Persist the message to the database at config.statedb
-	ts := container["envelope"].(map[string]interface{})["timestamp"]
-	sourceNumber := container["envelope"].(map[string]interface{})["sourceNumber"]
-	sourceName := container["envelope"].(map[string]interface{})["sourceName"]
-	message := msgStruct["message"]
-	groupId := msgStruct["groupInfo"].(map[string]interface{})["groupId"]
 
-	query := "INSERT INTO messages (timestamp, sourceNumber, sourceName, message, groupId, mentions) VALUES (?, ?, ?, ?, ?, ?)"
-	args := []interface{}{ts, sourceNumber, sourceName, message, groupId, string(mentionsJson)}
-	ctx.DbQueryChan <- dbQuery{query, args, nil}
+	// Index the message so !ask can find it later, without holding up the reply to it
+	if ctx.Embedder != nil && isEmbeddable(message) {
+		ctx.embedInBackground(saveCtx, msg)
+	}
+	return nil
 }
 
-func (ctx *AppContext) fetchLogsFromDB(starttime int, count int) (*sql.Rows, error) {
-	// Fetch logs from the database.
+func (ctx *AppContext) fetchLogsFromDB(traceCtx context.Context, groupId string, window TimeRange, count int) ([]StoredMessage, error) {
+	// Fetch logs for a single group from the database.
 	// If count is greater than zero, get that many logs.
-	// Then if starttime is not zero, get logs starting from that time.
-	// Return a map of the logs.
-	// If there are no logs, return an empty map.
-	var query string
-	var args []interface{}
+	// Otherwise get the logs in the window, which may be open at either end.
 	if count > 0 {
-		query = "SELECT sourceName || ': ' || message FROM messages ORDER BY timestamp DESC LIMIT ?"
-		args = []interface{}{count}
-	} else if starttime != 0 {
-		query = "SELECT sourceName || ': ' || message FROM messages WHERE timestamp >= ? ORDER BY timestamp ASC"
-		args = []interface{}{starttime}
-	} else {
-		return nil, errors.New("either hours or count must be provided")
-	}
-
-	// Send the query to the database and return the result
-	replyChan := make(chan dbReply, 1)
-	defer close(replyChan)
-	ctx.DbQueryChan <- dbQuery{query, args, replyChan}
-	rows := <-replyChan
-	if rows.rows != nil {
-		return rows.rows, nil
-	} else {
-		return nil, rows.err
+		return ctx.Store.FetchLastN(traceCtx, groupId, count)
 	}
+	start, end := window.Millis()
+	return ctx.Store.FetchRange(traceCtx, groupId, start, end)
 }
 
-func compileLogs(rows *sql.Rows) (string, error) {
+func compileLogs(messages []StoredMessage) (string, error) {
 	// Compile the logs into a string
 	var logs string
-	for rows.Next() {
-		var log string
-		err := rows.Scan(&log)
-		if err != nil {
-			fmt.Println("Failed to scan log:", err)
-			continue
-		}
-		logs += log + "\n"
+	for _, msg := range messages {
+		logs += formatLogLine(msg)
 	}
 	if logs == "" {
 		return "", errors.New("no logs found")
@@ -195,24 +92,16 @@ func compileLogs(rows *sql.Rows) (string, error) {
 	return logs, nil
 }
 
-func getNumberFromString(number string) (int, error) {
-	// Get the number from the string using regular expressions
-	re := regexp.MustCompile(`\d+`)
-	match := re.FindString(number)
-	if match == "" {
-		return 0, errors.New("no number found in the string")
-	}
-	num, err := strconv.Atoi(match)
-	if err != nil {
-		return 0, err
-	}
-	return num, nil
+func formatLogLine(msg StoredMessage) string {
+	// A single line of the chat log sent to the model, with the message it
+	// replied to and the reactions to it
+	return msg.SourceName + formatQuote(msg) + ": " + msg.Message + formatReactions(msg.Reactions) + "\n"
 }
 
-func (ctx *AppContext) sendMessage(message string, attachment string) {
+func (ctx *AppContext) sendMessage(req *RequestContext, message string, attachment string) {
 	// Start a new span
 	tracer := otel.Tracer("signal-bot")
-	_, span := tracer.Start(ctx.TraceContext, "sendMessage")
+	_, span := tracer.Start(req.TraceContext, "sendMessage")
 	defer span.End()
 
 	// If attachment is not nil, it's the path to a file.
@@ -220,8 +109,9 @@ func (ctx *AppContext) sendMessage(message string, attachment string) {
 	payload := map[string]any{
 		"message":    message,
 		"number":     Config["PHONE"],
-		"recipients": ctx.Recipients,
+		"recipients": []string{req.Recipient},
 	}
+	addQuote(payload, req)
 
 	if attachment != "" {
 		_, err := os.Stat(attachment)
@@ -254,17 +144,113 @@ func (ctx *AppContext) sendMessage(message string, attachment string) {
 		bytes.NewBuffer(body))
 	if err != nil {
 		log.Println("Failed to send message:", err)
+		return
 	}
 	request.Header.Add("Content-Type", "application/json")
 	client := &http.Client{}
 	res, err := client.Do(request)
 	if err != nil {
 		log.Println("Failed to send message:", err)
+		return
 	}
 	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Println("Failed to read send response:", err)
+		return
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		log.Printf("Failed to send message: received status code %d: %s", res.StatusCode, string(resBody))
+		return
+	}
+
+	// Record what we said, using the timestamp Signal assigned to the message
+	timestamp, err := parseSendResponse(resBody)
+	if err != nil {
+		log.Println("Failed to parse send response:", err)
+		timestamp = time.Now().UnixMilli()
+	}
+	ctx.saveOutgoingMessage(req, message, attachment, timestamp)
+}
+
+func addQuote(payload map[string]any, req *RequestContext) {
+	// Quote the message being replied to, so it's clear which request a reply is for.
+	// A message can only be quoted in the conversation it was sent to.
+	author := req.SourceUuid
+	if author == "" {
+		author = req.SourceNumber
+	}
+	if !req.QuoteReply || author == "" || !isConversationReply(req) {
+		return
+	}
+	payload["quote_timestamp"] = req.Timestamp
+	payload["quote_author"] = author
+	payload["quote_message"] = req.Message
+}
+
+// postLongMessage sends a reply which may be too long for one message. Only
+// the first part quotes the request, the rest follow on from it.
+func (ctx *AppContext) postLongMessage(req *RequestContext, message string) {
+	for i, chunk := range splitLongMessage(message) {
+		if i == 1 && req.QuoteReply {
+			rest := *req
+			rest.QuoteReply = false
+			req = &rest
+		}
+		ctx.MessagePoster(req, chunk, "")
+	}
+}
+
+func parseSendResponse(body []byte) (int64, error) {
+	// The send API replies with {"timestamp": "1733066028521"}. Older versions
+	// return the timestamp as a number rather than a string.
+	var response struct {
+		Timestamp json.RawMessage `json:"timestamp"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, err
+	}
+	timestamp, err := strconv.ParseInt(strings.Trim(string(response.Timestamp), `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %s: %w", string(response.Timestamp), err)
+	}
+	return timestamp, nil
+}
+
+func (ctx *AppContext) saveOutgoingMessage(req *RequestContext, message string, attachment string, timestamp int64) {
+	// Persist a message the bot sent in the conversation it was sent to, which
+	// for private replies is the member's direct conversation with the bot.
+	var attachments []string
+	if attachment != "" {
+		attachments = append(attachments, attachment)
+	}
+	msg := StoredMessage{
+		Timestamp:    timestamp,
+		SourceNumber: Config["PHONE"],
+		SourceName:   Config["BOTNAME"],
+		Message:      message,
+		GroupId:      conversationFor(req.Recipient),
+		Attachments:  attachments,
+	}
+
+	// Replies in the conversation the trigger was sent to are part of its
+	// thread. The trigger is identified the same way Signal does, by its author
+	// and timestamp. Scheduled digests weren't asked for by anyone, so have no trigger.
+	if replyToAuthor := memberId(req.SourceUuid, req.SourceNumber); replyToAuthor != "" && isConversationReply(req) {
+		msg.ReplyToTimestamp, msg.ReplyToAuthor = req.Timestamp, replyToAuthor
+		trigger, err := ctx.Store.FetchMessage(req.TraceContext, req.Conversation, req.Timestamp, req.SourceUuid, req.SourceNumber)
+		if err != nil {
+			log.Println("Failed to fetch the message replied to:", err)
+		} else if trigger != nil {
+			msg.ParentId, msg.QuoteAuthor, msg.QuoteText = trigger.Id, trigger.SourceName, trigger.Message
+		}
+	}
+	if err := ctx.Store.SaveMessage(req.TraceContext, &msg); err != nil {
+		log.Println("Failed to save outgoing message:", err)
+	}
 }
 
-func Printer(message string, attachment string) {
+func Printer(req *RequestContext, message string, attachment string) {
 	fmt.Println("RESPONSE: " + message)
 	fmt.Println("ATTACHMENT: " + attachment)
 }
@@ -318,21 +304,23 @@ func splitLongMessage(summary string) []string {
 	return chunks
 }
 
-func (ctx *AppContext) getImageData(msgStruct map[string]interface{}) ([]string, error) {
+func (ctx *AppContext) getImageData(req *RequestContext, content *DataMessage) ([]string, error) {
 	// If the message contains attachments, fetch and process them.
 	var imageData []string
-	if attachments, ok := msgStruct["attachments"].([]interface{}); ok {
-		for _, attachment := range attachments {
-			attachmentMap := attachment.(map[string]interface{})
-			// If the attachment is an image, call the imageProcessCommand function
-			if attachmentMap["contentType"] == "image/jpeg" {
-				imageAnalysis, err := ctx.ImageAnalyzer(attachmentMap["id"].(string))
-				if err != nil {
-					log.Println("Failed to process image:", err)
-				} else {
-					// Append the image analysis to the message body
-					imageData = append(imageData, imageAnalysis)
-				}
+	for _, attachment := range content.Attachments {
+		// If the attachment is an image, call the imageProcessCommand function
+		if attachment.ContentType == "image/jpeg" {
+			// Replying to every photo would be noisy, so images over the limits just aren't described
+			if refusal := ctx.checkLimits(req, "image_analysis"); refusal != "" {
+				log.Println("Not analyzing image:", refusal)
+				continue
+			}
+			imageAnalysis, err := ctx.ImageAnalyzer(withUsageOwner(req.TraceContext, req, "image_analysis"), attachment.Id)
+			if err != nil {
+				log.Println("Failed to process image:", err)
+			} else {
+				// Append the image analysis to the message body
+				imageData = append(imageData, imageAnalysis)
 			}
 		}
 	}
@@ -1,110 +1,65 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-
-	openai "github.com/sashabaranov/go-openai"
+	"strings"
 )
 
-func ValidateChatConfig() error {
-	// Validate the correct configuration is set
-	if Config["OPENAI_API_KEY"] == "" {
-		return fmt.Errorf("OPENAI_API_KEY is not set")
-	}
-
-	// Get the model name, validate that it's set
-	if Config["OPENAI_CHAT_MODEL"] == "" {
-		return fmt.Errorf("OPENAI_CHAT_MODEL is not set")
-	}
-	return nil
-}
-
-func getChatModelName() (string, error) {
-	modelName := Config["OPENAI_CHAT_MODEL"]
-	// Rather than trying to use reflection magic, we'll map the models we accept.
-	// This mean we'll have to keep this list up to date as OpenAI add more
-	// models but it's not a big lift.
-	allowedModels := map[string]string{
-		"GPT4o":     openai.GPT4o,
-		"GPT4oMini": openai.GPT4oMini,
-	}
-	// If modelName is not in the allowedModels map, return an error.
-	// We reuse the existing modelName variable here.
-	if _, ok := allowedModels[modelName]; !ok {
-		return "", fmt.Errorf("model %s is not supported", modelName)
-	}
-	modelName = allowedModels[modelName]
-	return modelName, nil
-}
-
-func (ctx *AppContext) InitChatHistory() ([]openai.ChatCompletionMessage, error) {
-	// Initialize the chat history with the bot's initialization message
-	messages := []openai.ChatCompletionMessage{}
+func loadChatbotInitMessage() (string, error) {
+	// Load the bot's initialization message, used as the system prompt for chat
 	initMsg, err := os.ReadFile("chatbot_init_msg.txt")
 	if err != nil {
-		return messages, fmt.Errorf("failed to read initialization message: %v", err)
+		return "", fmt.Errorf("failed to read initialization message: %v", err)
 	}
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleSystem,
-		Content: fmt.Sprintf(string(initMsg), Config["BOTNAME"]),
-	})
-	return messages, nil
+
+	return fmt.Sprintf(string(initMsg), Config["BOTNAME"]), nil
 }
 
-func checkIfMentioned(mentions []map[string]string) bool {
+func checkIfMentioned(mentions []Mention) bool {
 	for _, mention := range mentions {
-		if mention["name"] == Config["BOTNAME"] {
+		if mention.Name != "" && mention.Name == Config["BOTNAME"] {
 			return true
-		} else if mention["number"] == Config["PHONE"] {
+		} else if mention.Number != "" && mention.Number == Config["PHONE"] {
 			return true
 		}
 	}
 	return false
 }
 
-func (ctx *AppContext) fetchChatbotHistoryFromDb() []map[string]string {
-	// Hydrate the chat history from the database
-	// Send the query to the database and return the result
-	query := fmt.Sprintf(`WITH last_100_messages AS (
-    SELECT sourceName, message, created_at FROM messages
-    WHERE sourceName != '%s'
-      AND NOT EXISTS (
-          SELECT 1 FROM json_each(messages.mentions)
-          WHERE json_each.value = '%s'
-      )
-      AND message NOT LIKE '%%%s%%'
-    ORDER BY created_at DESC
-    LIMIT 100
-)
-SELECT sourceName, message, created_at FROM messages WHERE sourceName = '%s'
-UNION ALL
-SELECT sourceName, message, created_at FROM messages
-WHERE EXISTS (
-    SELECT 1 FROM json_each(messages.mentions)
-    WHERE json_each.value = '%s'
-)
-UNION ALL
-SELECT sourceName, message, created_at FROM messages WHERE message LIKE '%%%s%%'
-UNION ALL
-SELECT sourceName, message, created_at FROM last_100_messages
-ORDER BY created_at ASC;
-`, Config["BOTNAME"], Config["BOTNAME"], Config["BOTNAME"], Config["BOTNAME"], Config["BOTNAME"], Config["BOTNAME"])
+func checkIfNamed(message string) bool {
+	// Check if the bot's name appears anywhere in the message
+	botName := strings.ToLower(Config["BOTNAME"])
+	return botName != "" && strings.Contains(strings.ToLower(message), botName)
+}
 
-	replyChan := make(chan dbReply, 1)
-	defer close(replyChan)
-	ctx.DbQueryChan <- dbQuery{query, nil, replyChan}
-	rows := <-replyChan
-	// Get the results from the db. Store the results in an array of arrays as [sourceName, message]
-	var chatHistory []map[string]string
-	for rows.rows.Next() {
-		var sourceName, message, created_at string
-		err := rows.rows.Scan(&sourceName, &message, &created_at)
-		if err != nil {
-			fmt.Println("Failed to scan log:", err)
+func buildChatConversation(history []StoredMessage) []LLMMessage {
+	// Turn the stored history into a conversation. The bot's own messages are
+	// assistant turns, everyone else's are user turns prefixed with their name.
+	// Consecutive messages from the same side are merged, as some providers
+	// require the turns to alternate, and the conversation must start with a user.
+	var conversation []LLMMessage
+	for _, entry := range history {
+		role, content := "user", entry.SourceName+": "+entry.Message
+		if entry.SourceName == Config["BOTNAME"] {
+			role, content = "assistant", entry.Message
+		}
+		if len(conversation) == 0 && role == "assistant" {
 			continue
 		}
-		chatHistory = append(chatHistory, map[string]string{"sourceName": sourceName, "message": message})
+		if last := len(conversation) - 1; last >= 0 && conversation[last].Role == role {
+			conversation[last].Content += "\n" + content
+			continue
+		}
+		conversation = append(conversation, LLMMessage{Role: role, Content: content})
 	}
-	return chatHistory
+	return conversation
+}
+
+func (ctx *AppContext) fetchChatbotHistoryFromDb(traceCtx context.Context, groupId string) ([]StoredMessage, error) {
+	// Hydrate the chat history for a single group from the database.
+	// This is every message from, mentioning or naming the bot, plus the last
+	// 100 messages for context, up to 100 of each.
+	return ctx.Store.FetchChatHistory(traceCtx, groupId, Config["BOTNAME"], Config["PHONE"], 100)
 }
@@ -1,77 +1,22 @@
 package main
 
 import (
+	"context"
+	"strings"
 	"testing"
-
-	openai "github.com/sashabaranov/go-openai"
 )
 
-func TestGetChatModelName(t *testing.T) {
-	tests := []struct {
-		name      string
-		config    map[string]string
-		want      string
-		expectErr bool
-	}{
-		{
-			name: "Valid model GPT4o",
-			config: map[string]string{
-				"OPENAI_CHAT_MODEL": "GPT4o",
-			},
-			want:      openai.GPT4o,
-			expectErr: false,
-		},
-		{
-			name: "Valid model GPT4oMini",
-			config: map[string]string{
-				"OPENAI_CHAT_MODEL": "GPT4oMini",
-			},
-			want:      openai.GPT4oMini,
-			expectErr: false,
-		},
-		{
-			name: "Invalid model",
-			config: map[string]string{
-				"OPENAI_CHAT_MODEL": "InvalidModel",
-			},
-			want:      "",
-			expectErr: true,
-		},
-		{
-			name: "Empty model",
-			config: map[string]string{
-				"OPENAI_CHAT_MODEL": "",
-			},
-			want:      "",
-			expectErr: true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			Config = tt.config
-			got, err := getChatModelName()
-			if (err != nil) != tt.expectErr {
-				t.Errorf("getChatModelName() error = %v, expectErr %v", err, tt.expectErr)
-				return
-			}
-			if got != tt.want {
-				t.Errorf("getChatModelName() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
 func TestCheckIfMentioned(t *testing.T) {
 	tests := []struct {
 		name     string
-		mentions []map[string]string
+		mentions []Mention
 		config   map[string]string
 		want     bool
 	}{
 		{
 			name: "Mentioned by name",
-			mentions: []map[string]string{
-				{"name": "BotName"},
+			mentions: []Mention{
+				{Name: "BotName"},
 			},
 			config: map[string]string{
 				"BOTNAME": "BotName",
@@ -80,8 +25,8 @@ func TestCheckIfMentioned(t *testing.T) {
 		},
 		{
 			name: "Mentioned by phone",
-			mentions: []map[string]string{
-				{"number": "1234567890"},
+			mentions: []Mention{
+				{Number: "1234567890"},
 			},
 			config: map[string]string{
 				"PHONE": "1234567890",
@@ -90,9 +35,9 @@ func TestCheckIfMentioned(t *testing.T) {
 		},
 		{
 			name: "Not mentioned",
-			mentions: []map[string]string{
-				{"name": "OtherName"},
-				{"number": "0987654321"},
+			mentions: []Mention{
+				{Name: "OtherName"},
+				{Number: "0987654321"},
 			},
 			config: map[string]string{
 				"BOTNAME": "BotName",
@@ -102,7 +47,7 @@ func TestCheckIfMentioned(t *testing.T) {
 		},
 		{
 			name:     "Empty mentions",
-			mentions: []map[string]string{},
+			mentions: []Mention{},
 			config: map[string]string{
 				"BOTNAME": "BotName",
 				"PHONE":   "1234567890",
@@ -120,3 +65,71 @@ func TestCheckIfMentioned(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckIfNamed(t *testing.T) {
+	Config = map[string]string{"BOTNAME": "Robo"}
+	if !checkIfNamed("hey robo, what's up?") {
+		t.Errorf("expected the bot to be named")
+	}
+	if checkIfNamed("nobody here") {
+		t.Errorf("expected the bot to not be named")
+	}
+	Config = map[string]string{"BOTNAME": ""}
+	if checkIfNamed("anything") {
+		t.Errorf("an empty BOTNAME should never match")
+	}
+}
+
+func TestBuildChatConversation(t *testing.T) {
+	Config = map[string]string{"BOTNAME": "Robo"}
+	history := []StoredMessage{
+		{SourceName: "Robo", Message: "A reply to something we can't see"},
+		{SourceName: "Alice", Message: "Hi Robo"},
+		{SourceName: "Robo", Message: "Hi Alice"},
+		{SourceName: "Bob", Message: "Robo, what's 2+2?"},
+		{SourceName: "Alice", Message: "Good question"},
+	}
+	want := []LLMMessage{
+		{Role: "user", Content: "Alice: Hi Robo"},
+		{Role: "assistant", Content: "Hi Alice"},
+		{Role: "user", Content: "Bob: Robo, what's 2+2?\nAlice: Good question"},
+	}
+
+	got := buildChatConversation(history)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d messages, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i].Role != want[i].Role || got[i].Content != want[i].Content {
+			t.Errorf("message %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFetchChatbotHistoryFromDb(t *testing.T) {
+	store := newTestStore(t)
+	_, err := store.db.Exec(`INSERT INTO messages (timestamp, sourceName, message, groupId, mentions) VALUES
+		(1, 'Alice', 'hello', 'groupOne', 'null'),
+		(2, 'Bob', 'hey', 'groupOne', '[{"name":"+123456789","number":"+123456789"}]'),
+		(3, 'Robo', 'hi Bob', 'groupOne', '[]'),
+		(4, 'Carol', 'secret', 'groupTwo', '[]')`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	Config = map[string]string{"BOTNAME": "Robo", "PHONE": "+123456789"}
+	ctx := &AppContext{Store: store}
+
+	history, err := ctx.fetchChatbotHistoryFromDb(context.Background(), "groupOne")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, entry := range history {
+		got = append(got, entry.SourceName+": "+entry.Message)
+	}
+	want := []string{"Alice: hello", "Bob: hey", "Robo: hi Bob"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected history %q, got %q", want, got)
+	}
+}
@@ -8,20 +8,36 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
-func (ctx *AppContext) removeOldMessages() {
+func (ctx *AppContext) removeOldMessages(groupId string) {
 	// Start a new span
 	tracer := otel.Tracer("signal-bot")
-	_, span := tracer.Start(ctx.TraceContext, "removeOldMessages")
+	pruneCtx, span := tracer.Start(ctx.TraceContext, "removeOldMessages")
 	defer span.End()
 
-	// Convert the value of config.MAX_AGE into an int
-	// We don't check for the err because we validated this in main()
-	maxAge, _ := strconv.Atoi(Config["MAX_AGE"])
+	// If a groupId is given only that group is cleaned up, otherwise all groups are.
+	// Each group can keep messages for longer or shorter than MAX_AGE.
+	groups := []string{groupId}
+	if groupId == "" {
+		var err error
+		if groups, err = ctx.Store.FetchGroups(pruneCtx); err != nil {
+			log.Println("Failed to fetch groups to clean up:", err)
+			return
+		}
+	}
+	for _, groupId := range groups {
+		// Convert the value of max_age into an int
+		// We don't check for the err because it was validated when it was set
+		maxAge, _ := strconv.Atoi(ctx.groupConfig(pruneCtx, groupId, "max_age"))
 
-	// Delete messages older than config.max_age from the database
-	query := "DELETE FROM messages WHERE timestamp < ?"
-	maxAgeInNs := time.Hour * time.Duration(maxAge)
-	args := []interface{}{time.Now().Add(-maxAgeInNs).Unix() * 1000}
-	log.Println("Removing messages older than", maxAge, "hours. Timestamp:", args[0])
-	ctx.DbQueryChan <- dbQuery{query, args, nil}
+		// Delete messages older than max_age from the database.
+		maxAgeInNs := time.Hour * time.Duration(maxAge)
+		before := time.Now().Add(-maxAgeInNs).UnixMilli()
+		log.Println("Removing messages older than", maxAge, "hours. Timestamp:", before, "Group:", groupId)
+		removed, err := ctx.Store.Prune(pruneCtx, groupId, before)
+		if err != nil {
+			log.Println("Failed to remove old messages:", err)
+			continue
+		}
+		log.Println("Removed", removed, "old messages")
+	}
 }
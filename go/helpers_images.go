@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
 )
 
+// imageAnalysisPrompt is sent along with every image we analyze
+const imageAnalysisPrompt = "Please describe this image in detail."
+
 func downloadImage(attachmentId string) (string, error) {
 	url := fmt.Sprintf("http://%s/v1/attachments/%s", Config["URL"], attachmentId)
 	resp, err := http.Get(url)
@@ -27,3 +31,29 @@ func downloadImage(attachmentId string) (string, error) {
 	attachment := base64.StdEncoding.EncodeToString(imageData)
 	return attachment, nil
 }
+
+func newImageAnalyzer(provider LLMProvider) ImageAnalysisFunc {
+	// Describe images using any provider which accepts images
+	return func(ctx context.Context, attachmentId string) (string, error) {
+		attachment, err := downloadImage(attachmentId)
+		if err != nil {
+			return "", err
+		}
+		req := LLMRequest{
+			Messages: []LLMMessage{
+				{
+					Role:    "user",
+					Content: imageAnalysisPrompt,
+					Images:  []string{attachment},
+				},
+			},
+			MaxTokens: 300,
+		}
+		resp, err := provider.Generate(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("Image analysis response: %s\n", resp.Text)
+		return resp.Text, nil
+	}
+}
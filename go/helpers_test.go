@@ -1,9 +1,13 @@
 package main
 
 import (
-	"database/sql"
-	"os"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -17,145 +21,226 @@ func TestEncodeGroupIdToBase64(t *testing.T) {
 	}
 }
 func TestCompileLogs(t *testing.T) {
-	// Set up a test sqlite database
-	os.Create("test_db.db")
-	defer os.Remove("test_db.db")
-	db, err := sql.Open("sqlite3", "test_db.db")
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	messages := []StoredMessage{
+		{SourceName: "Alice", Message: "Log 1"},
+		{SourceName: "Bob", Message: "Log 2"},
+		{SourceName: "Alice", Message: "Log 3"},
 	}
-	defer db.Close()
-	_, err = db.Exec("CREATE TABLE test (log TEXT)")
+
+	expectedResult := "Alice: Log 1\nBob: Log 2\nAlice: Log 3\n"
+	result, err := compileLogs(messages)
 	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+		t.Errorf("unexpected error: %v", err)
 	}
-	_, err = db.Exec("INSERT INTO test (log) VALUES ('Log 1'), ('Log 2'), ('Log 3')")
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+
+	if result != expectedResult {
+		t.Errorf("expected result %q, got %q", expectedResult, result)
 	}
 
-	// Select the rows from the test database
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
+	if _, err := compileLogs(nil); err == nil {
+		t.Errorf("expected error for no messages, got nil")
 	}
-	defer db.Close()
-	rows, err := db.Query("SELECT log FROM test")
+}
+func TestFetchLogsFromDBScopedToGroup(t *testing.T) {
+	// Set up a test sqlite database with messages from two groups
+	store := newTestStore(t)
+	_, err := store.db.Exec("INSERT INTO messages (timestamp, sourceName, message, groupId) VALUES " +
+		"(1, 'Alice', 'hello group one', 'groupOne'), " +
+		"(2, 'Bob', 'hello group two', 'groupTwo'), " +
+		"(3, 'Carol', 'bye group one', 'groupOne')")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	defer rows.Close()
+	ctx := &AppContext{Store: store}
 
-	expectedResult := "Log 1\nLog 2\nLog 3\n"
-	result, err := compileLogs(rows)
+	messages, err := ctx.fetchLogsFromDB(context.Background(), "groupOne", TimeRange{Start: time.UnixMilli(1)}, 0)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-	}
-
-	if result != expectedResult {
-		t.Errorf("expected result %q, got %q", expectedResult, result)
+		t.Fatalf("unexpected error: %v", err)
 	}
-}
-func TestGetNumberFromString(t *testing.T) {
-	// Test success case
-	input := "12h"
-	expectedResult := 12
-	result, err := getNumberFromString(input)
+	result, err := compileLogs(messages)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	expectedResult := "Alice: hello group one\nCarol: bye group one\n"
 	if result != expectedResult {
-		t.Errorf("expected result %d, got %d", expectedResult, result)
+		t.Errorf("expected result %q, got %q", expectedResult, result)
 	}
 
-	// Test success case
-	input = "12"
-	_, err = getNumberFromString(input)
+	// The last N messages come back oldest first
+	messages, err = ctx.fetchLogsFromDB(context.Background(), "groupOne", TimeRange{}, 1)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if result != expectedResult {
-		t.Errorf("expected result %d, got %d", expectedResult, result)
+	if len(messages) != 1 || messages[0].Message != "bye group one" {
+		t.Errorf("expected the last message in groupOne, got %+v", messages)
 	}
 
-	// Test failure case
-	input = "h"
-	_, err = getNumberFromString(input)
-	if err == nil {
-		t.Errorf("expected error, got nil")
+	// A missing groupId must never fall back to reading every group
+	if _, err := ctx.fetchLogsFromDB(context.Background(), "", TimeRange{}, 0); err == nil {
+		t.Errorf("expected error for empty groupId, got nil")
 	}
+}
 
-	// Test failure case
-	input = ""
-	_, err = getNumberFromString(input)
-	if err == nil {
-		t.Errorf("expected error, got nil")
+func TestParseSendResponse(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected int64
+		wantErr  bool
+	}{
+		{`{"timestamp":"1733066028521"}`, 1733066028521, false},
+		{`{"timestamp":1733066028521}`, 1733066028521, false},
+		{`{}`, 0, true},
+		{`not json`, 0, true},
+	}
+	for _, test := range tests {
+		result, err := parseSendResponse([]byte(test.body))
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseSendResponse(%s): unexpected error: %v", test.body, err)
+		}
+		if result != test.expected {
+			t.Errorf("parseSendResponse(%s): expected %d, got %d", test.body, test.expected, result)
+		}
 	}
 }
 
-func TestGetMessageRoot(t *testing.T) {
-	// Define the test data
-	testData := `{
-        "envelope": {
-            "source": "+1234567890",
-            "sourceNumber": "+1234567890",
-            "sourceUuid": "<fake_uuid>",
-            "sourceName": "Test User",
-            "sourceDevice": 1,
-            "timestamp": 1733066028521,
-            "dataMessage": {
-                "timestamp": 1733066028521,
-                "message": "Testing messages",
-                "expiresInSeconds": 0,
-                "viewOnce": false,
-                "attachments": [
-                    {
-                        "contentType": "image/jpeg",
-                        "filename": "galaxy.jpg",
-                        "id": "r4aFDRWmi_z2dfVh5iqC.jpg",
-                        "size": 273635,
-                        "width": 2048,
-                        "height": 2048,
-                        "caption": null,
-                        "uploadTimestamp": null
-                    }
-                ],
-                "groupInfo": {
-                    "groupId": "VGVzdA==",
-                    "type": "DELIVER"
-                }
-            }
-        },
-        "account": "+1234567890"
-    }`
-
-	// Call getMessageRoot with the test data
-	container, msgStruct, err := getMessageRoot(testData)
+func TestSendMessagePersistsOutgoingMessage(t *testing.T) {
+	// Fake the signal-cli REST API's send endpoint
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/send" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"timestamp":"1733066028521"}`))
+	}))
+	defer server.Close()
+
+	Config["URL"] = strings.TrimPrefix(server.URL, "http://")
+	Config["PHONE"] = "+123456789"
+	Config["BOTNAME"] = "Bot"
+	ctx := &AppContext{Store: newTestStore(t)}
+
+	req := &RequestContext{
+		GroupId:      "groupOne",
+		Conversation: "groupOne",
+		Recipient:    encodeGroupIdToBase64("groupOne"),
+		SourceName:   "Alice",
+		SourceUuid:   "alice-uuid",
+		Timestamp:    1733066000000,
+		TraceContext: context.Background(),
+	}
+	ctx.sendMessage(req, "pong", "")
+
+	messages, err := ctx.Store.FetchLastN(context.Background(), "groupOne", 10)
 	if err != nil {
-		t.Fatalf("getMessageRoot returned an error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-
-	// Check the container
-	if container == nil {
-		t.Fatalf("Expected container to be non-nil")
+	if len(messages) != 1 {
+		t.Fatalf("expected the outgoing message to be stored, got %+v", messages)
+	}
+	msg := messages[0]
+	if msg.Timestamp != 1733066028521 {
+		t.Errorf("expected the timestamp from the send API, got %d", msg.Timestamp)
+	}
+	if msg.SourceNumber != "+123456789" || msg.SourceName != "Bot" {
+		t.Errorf("expected the bot's identity, got %s %s", msg.SourceName, msg.SourceNumber)
 	}
+	if msg.Message != "pong" || len(msg.Attachments) != 0 {
+		t.Errorf("unexpected message %q with attachments %v", msg.Message, msg.Attachments)
+	}
+	if msg.ReplyToTimestamp != 1733066000000 || msg.ReplyToAuthor != "alice-uuid" {
+		t.Errorf("expected a link to the triggering message, got %d from %s", msg.ReplyToTimestamp, msg.ReplyToAuthor)
+	}
+}
 
-	// Check the msgStruct
-	if msgStruct == nil {
-		t.Fatalf("Expected msgStruct to be non-nil")
+func TestDigestsAreNotLinkedToATrigger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"timestamp":"1733066028521"}`))
+	}))
+	defer server.Close()
+
+	Config["URL"] = strings.TrimPrefix(server.URL, "http://")
+	Config["PHONE"] = "+123456789"
+	ctx := &AppContext{Store: newTestStore(t)}
+
+	// A member's message which happens to share the digest's timestamp
+	member := StoredMessage{Timestamp: 1733066000000, SourceName: "Alice", SourceUuid: "alice-uuid", Message: "hi", GroupId: "groupOne"}
+	if err := ctx.Store.SaveMessage(context.Background(), &member); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
+	req := &RequestContext{
+		GroupId:      "groupOne",
+		Conversation: "groupOne",
+		Recipient:    encodeGroupIdToBase64("groupOne"),
+		SourceName:   "schedule #1",
+		Timestamp:    1733066000000,
+		TraceContext: context.Background(),
+	}
+	ctx.sendMessage(req, "the digest", "")
 
-	// Check specific fields in msgStruct
-	expectedMessage := "Testing messages"
-	if msgStruct["message"] != expectedMessage {
-		t.Errorf("Expected message %s, got %s", expectedMessage, msgStruct["message"])
+	messages, err := ctx.Store.FetchRange(context.Background(), "groupOne", 1733066028521, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
+	if len(messages) != 1 {
+		t.Fatalf("expected the digest to be stored, got %+v", messages)
+	}
+	if digest := messages[0]; digest.ReplyToTimestamp != 0 || digest.ReplyToAuthor != "" || digest.ParentId != 0 || digest.QuoteAuthor != "" {
+		t.Errorf("expected the digest not to be linked to a message, got %+v", digest)
+	}
+}
 
-	expectedGroupId := "VGVzdA=="
-	groupInfo, ok := msgStruct["groupInfo"].(map[string]interface{})
-	if !ok {
-		t.Fatalf("Expected groupInfo to be of type map[string]interface{}")
+func TestSendMessageQuotesCommands(t *testing.T) {
+	// Fake the signal-cli REST API's send endpoint, keeping what was sent
+	var payloads []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("unexpected body: %v", err)
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"timestamp":"1733066028521"}`))
+	}))
+	defer server.Close()
+
+	Config["URL"] = strings.TrimPrefix(server.URL, "http://")
+	Config["PHONE"] = "+123456789"
+	ctx := &AppContext{Store: newTestStore(t)}
+	ctx.MessagePoster = ctx.sendMessage
+
+	req := &RequestContext{
+		GroupId:      "groupOne",
+		Conversation: "groupOne",
+		Recipient:    encodeGroupIdToBase64("groupOne"),
+		SourceName:   "Alice",
+		SourceNumber: "+15550001111",
+		Timestamp:    1733066000000,
+		Message:      "!summary 12h",
+		QuoteReply:   true,
+		TraceContext: context.Background(),
+	}
+	// Only the first part of a long reply quotes the request
+	ctx.postLongMessage(req, strings.Repeat("a", 2500))
+	if len(payloads) != 2 {
+		t.Fatalf("expected the reply to be sent in 2 parts, got %d", len(payloads))
+	}
+	if payloads[0]["quote_timestamp"] != float64(1733066000000) || payloads[0]["quote_author"] != "+15550001111" ||
+		payloads[0]["quote_message"] != "!summary 12h" {
+		t.Errorf("expected the first part to quote the request, got %v", payloads[0])
+	}
+	if _, ok := payloads[1]["quote_timestamp"]; ok {
+		t.Errorf("expected the second part not to quote the request, got %v", payloads[1])
+	}
+	if !req.QuoteReply {
+		t.Errorf("expected the request to be left unchanged")
 	}
-	if groupInfo["groupId"] != expectedGroupId {
-		t.Errorf("Expected groupId %s, got %s", expectedGroupId, groupInfo["groupId"])
+
+	// Private replies can't quote a message sent to the group
+	payloads = nil
+	req.Recipient = "+15550001111"
+	ctx.sendMessage(req, "psst", "")
+	if _, ok := payloads[0]["quote_timestamp"]; ok {
+		t.Errorf("expected a private reply not to quote the request, got %v", payloads[0])
 	}
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+)
+
+// imageDataPrefix separates a message's text from the descriptions of its images
+const imageDataPrefix = "\n(Image data: "
+
+// mostReactedLimit is how many of the most reacted to messages are pointed out in summaries
+const mostReactedLimit = 5
+
+func (ctx *AppContext) processEdit(envelope *Envelope, edit *EditMessage) {
+	// Edits replace the text of a message we already have. Signal edits always
+	// refer to the original message's timestamp, however often it's edited.
+	content := edit.DataMessage
+	if content == nil {
+		return
+	}
+	groupId := conversationId(envelope, content)
+	if groupId == "" {
+		return
+	}
+
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	editCtx, span := tracer.Start(ctx.TraceContext, "processEdit")
+	defer span.End()
+
+	msg, err := ctx.Store.FetchMessage(editCtx, groupId, edit.TargetSentTimestamp, envelope.SourceUuid, envelope.SourceNumber)
+	if err != nil {
+		log.Println("Failed to fetch the edited message:", err)
+		return
+	}
+	// The message may have been sent before the bot joined, or already pruned
+	if msg == nil || !sentBy(msg, envelope) {
+		return
+	}
+
+	// Edits only change the text, so keep the descriptions of any images
+	text := content.Message
+	if i := strings.Index(msg.Message, imageDataPrefix); i >= 0 {
+		text += msg.Message[i:]
+	}
+	if err := ctx.Store.EditMessage(editCtx, msg.Id, text, envelope.Timestamp); err != nil {
+		log.Println("Failed to edit message:", err)
+		return
+	}
+
+	// The old vector matches the old text
+	msg.Message = text
+	if ctx.Embedder != nil && isEmbeddable(text) {
+		ctx.embedInBackground(editCtx, *msg)
+	}
+}
+
+func (ctx *AppContext) processRemoteDelete(envelope *Envelope, content *DataMessage) {
+	// The author retracted the message, so forget it entirely
+	groupId := conversationId(envelope, content)
+	if groupId == "" {
+		return
+	}
+
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	deleteCtx, span := tracer.Start(ctx.TraceContext, "processRemoteDelete")
+	defer span.End()
+
+	msg, err := ctx.Store.FetchMessage(deleteCtx, groupId, content.RemoteDelete.Timestamp, envelope.SourceUuid, envelope.SourceNumber)
+	if err != nil {
+		log.Println("Failed to fetch the deleted message:", err)
+		return
+	}
+	if msg == nil || !sentBy(msg, envelope) {
+		return
+	}
+	if err := ctx.Store.DeleteMessage(deleteCtx, msg.Id); err != nil {
+		log.Println("Failed to delete message:", err)
+	}
+}
+
+func (ctx *AppContext) processReaction(envelope *Envelope, content *DataMessage) {
+	// Reactions are stored against the message they're for, one per member
+	member := memberId(envelope.SourceUuid, envelope.SourceNumber)
+	groupId := conversationId(envelope, content)
+	if groupId == "" || member == "" {
+		return
+	}
+	// The bot's own reactions acknowledge commands, they aren't the group's
+	if envelope.SourceNumber != "" && envelope.SourceNumber == Config["PHONE"] {
+		return
+	}
+
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	reactionCtx, span := tracer.Start(ctx.TraceContext, "processReaction")
+	defer span.End()
+
+	reaction := content.Reaction
+	msg, err := ctx.Store.FetchMessage(reactionCtx, groupId, reaction.TargetSentTimestamp,
+		reaction.TargetAuthorUuid, reaction.TargetAuthorNumber)
+	if err != nil {
+		log.Println("Failed to fetch the message reacted to:", err)
+		return
+	}
+	if msg == nil {
+		return
+	}
+	if reaction.IsRemove {
+		err = ctx.Store.RemoveReaction(reactionCtx, msg.Id, member)
+	} else {
+		err = ctx.Store.SaveReaction(reactionCtx, msg.Id, member, envelope.Sender(), reaction.Emoji, envelope.Timestamp)
+	}
+	if err != nil {
+		log.Println("Failed to save reaction:", err)
+	}
+}
+
+// sentBy reports whether the envelope came from the author of msg. Only the
+// author can edit or delete a message.
+func sentBy(msg *StoredMessage, envelope *Envelope) bool {
+	switch {
+	case msg.SourceUuid != "":
+		return msg.SourceUuid == envelope.SourceUuid
+	case msg.SourceNumber != "":
+		return msg.SourceNumber == envelope.SourceNumber
+	}
+	// Older messages from members who hide their number only have a name
+	return msg.SourceName == envelope.Sender()
+}
+
+// formatReactions summarizes a message's reactions, most popular first, eg. " [👍 3, ❤️ 1]"
+func formatReactions(reactions []string) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	counts := map[string]int{}
+	var emoji []string
+	for _, reaction := range reactions {
+		if counts[reaction] == 0 {
+			emoji = append(emoji, reaction)
+		}
+		counts[reaction]++
+	}
+	sort.SliceStable(emoji, func(i, j int) bool { return counts[emoji[i]] > counts[emoji[j]] })
+	var parts []string
+	for _, e := range emoji {
+		parts = append(parts, fmt.Sprintf("%s %d", e, counts[e]))
+	}
+	return " [" + strings.Join(parts, ", ") + "]"
+}
+
+// mostReactedPrompt points out the messages the group reacted to most, so the
+// summary can give them the weight the group did. It's empty if nobody reacted.
+func mostReactedPrompt(messages []StoredMessage) string {
+	var reacted []StoredMessage
+	for _, msg := range messages {
+		if len(msg.Reactions) > 0 {
+			reacted = append(reacted, msg)
+		}
+	}
+	if len(reacted) == 0 {
+		return ""
+	}
+	sort.SliceStable(reacted, func(i, j int) bool { return len(reacted[i].Reactions) > len(reacted[j].Reactions) })
+	if len(reacted) > mostReactedLimit {
+		reacted = reacted[:mostReactedLimit]
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("\nThese are the messages the group reacted to most, with their reactions in brackets:\n")
+	for _, msg := range reacted {
+		text := msg.Message
+		if i := strings.Index(text, imageDataPrefix); i >= 0 {
+			text = text[:i]
+		}
+		if len([]rune(text)) > 200 {
+			text = string([]rune(text)[:200]) + "..."
+		}
+		fmt.Fprintf(&prompt, "- %s: %s%s\n", msg.SourceName, text, formatReactions(msg.Reactions))
+	}
+	return prompt.String()
+}
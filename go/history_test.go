@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEditsDeletesAndReactions(t *testing.T) {
+	Config = map[string]string{}
+	store := newTestStore(t)
+	ctx := &AppContext{Store: store, TraceContext: context.Background()}
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {}
+	receive := func(uuid string, timestamp int64, dataMessage string) {
+		t.Helper()
+		ctx.processMessage(fmt.Sprintf(`{"envelope":{"sourceName":"%s","sourceUuid":"%s-uuid","timestamp":%d,%s}}`,
+			uuid, uuid, timestamp, dataMessage))
+	}
+	group := `"groupInfo":{"groupId":"groupOne"}`
+
+	receive("Alice", 1000, `"dataMessage":{"message":"lunch at noon",`+group+`}`)
+	receive("Bob", 2000, `"dataMessage":{"message":"sounds good",`+group+`}`)
+
+	// Only the author can edit a message, and the original text is kept
+	receive("Bob", 3000, `"editMessage":{"targetSentTimestamp":1000,"dataMessage":{"message":"lunch at two",`+group+`}}`)
+	receive("Alice", 3500, `"editMessage":{"targetSentTimestamp":1000,"dataMessage":{"message":"lunch at one",`+group+`}}`)
+
+	// Everyone gets one reaction to each message, which can be changed or removed
+	react := func(who string, timestamp int64, emoji string, remove bool) {
+		receive(who, timestamp, fmt.Sprintf(`"dataMessage":{"reaction":{"emoji":"%s","targetAuthorUuid":"Alice-uuid",`+
+			`"targetSentTimestamp":1000,"isRemove":%t},`+group+`}`, emoji, remove))
+	}
+	react("Bob", 4000, "👎", false)
+	react("Bob", 4100, "👍", false)
+	react("Carol", 4200, "👍", false)
+	react("Dave", 4300, "❤️", false)
+	react("Erin", 4400, "😮", false)
+	react("Erin", 4500, "😮", true)
+
+	// Only the author can delete a message
+	receive("Alice", 5000, `"dataMessage":{"remoteDelete":{"timestamp":2000},`+group+`}`)
+	receive("Bob", 5100, `"dataMessage":{"remoteDelete":{"timestamp":2000},`+group+`}`)
+
+	messages, err := store.FetchRange(context.Background(), "groupOne", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected Bob's message to be deleted, got %+v", messages)
+	}
+	msg := messages[0]
+	if msg.Message != "lunch at one" || msg.EditedAt != 3500 {
+		t.Errorf("expected Alice's edit to be kept, got %+v", msg)
+	}
+	if line := formatLogLine(msg); line != "Alice: lunch at one [👍 2, ❤️ 1]\n" {
+		t.Errorf("unexpected log line %q", line)
+	}
+}
+
+func TestMostReactedPrompt(t *testing.T) {
+	if prompt := mostReactedPrompt([]StoredMessage{{SourceName: "Alice", Message: "hi"}}); prompt != "" {
+		t.Errorf("expected no prompt without reactions, got %q", prompt)
+	}
+
+	messages := []StoredMessage{
+		{SourceName: "Alice", Message: "hi", Reactions: []string{"👋"}},
+		{SourceName: "Bob", Message: "the cat" + imageDataPrefix + "a cat on a sofa)", Reactions: []string{"😂", "❤️", "😂"}},
+	}
+	for i := 0; i < mostReactedLimit; i++ {
+		messages = append(messages, StoredMessage{SourceName: "Carol", Message: "ok", Reactions: []string{"👍", "👍"}})
+	}
+	prompt := mostReactedPrompt(messages)
+	lines := strings.Split(strings.TrimSpace(prompt), "\n")
+	if len(lines) != mostReactedLimit+1 || lines[1] != "- Bob: the cat [😂 2, ❤️ 1]" {
+		t.Errorf("unexpected prompt %q", prompt)
+	}
+	if strings.Contains(prompt, "Alice") {
+		t.Errorf("expected the least reacted message to be left out, got %q", prompt)
+	}
+}
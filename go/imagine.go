@@ -5,48 +5,95 @@ import (
 	"fmt"
 	"log"
 
+	openai "github.com/sashabaranov/go-openai"
+
 	"go.opentelemetry.io/otel"
 )
 
-func (ctx *AppContext) imagineCommand(requestor string, prompt string, flavor string) {
-	// Start a new span. During testing ctx.TraceContext may be nil so we need to check for that.
-	if ctx.TraceContext == nil {
-		ctx.TraceContext = context.Background()
+// imageGenModels are the models each image provider generates with
+var imageGenModels = map[string]string{"openai": openai.CreateImageModelDallE3, "google": "imagen-3.0-generate-002"}
+
+// imagineArgs are the parsed arguments to !imagine
+type imagineArgs struct {
+	Prompt string
+	Flavor string // The name the command was called with, eg. !dream
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "imagine",
+		Aliases:     []string{"opine", "dream", "nightmare", "hallucinate", "trip"},
+		Usage:       "<text>",
+		Description: "Generate an image (other options: !opine, !dream, !nightmare, !hallucinate, !trip)",
+		Slow:        true,
+		ParseArgs: func(name string, args []string) (interface{}, error) {
+			prompt, err := requireArgs(name, args)
+			if err != nil {
+				return nil, err
+			}
+			return imagineArgs{Prompt: prompt.(string), Flavor: "!" + name}, nil
+		},
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			a := args.(imagineArgs)
+			return ctx.imagineCommand(req, a.Prompt, a.Flavor)
+		},
+	})
+}
+
+func (ctx *AppContext) imagineCommand(req *RequestContext, prompt string, flavor string) error {
+	// Start a new span. During testing req.TraceContext may be nil so we need to check for that.
+	if req.TraceContext == nil {
+		req.TraceContext = context.Background()
 	}
 	tracer := otel.Tracer("signal-bot")
-	_, span := tracer.Start(ctx.TraceContext, "imagineCommand")
+	_, span := tracer.Start(req.TraceContext, "imagineCommand")
 	defer span.End()
 
+	requestor := req.SourceName
+
 	var filename, revisedPrompt string
 	var err error
 
 	// Generate an image from the text and send it to the send channel
 	fmt.Printf("Generating image for %s: %s\n", requestor, prompt)
 
+	// Images which can't be priced would be free as far as the budgets are concerned
+	provider := Config["IMAGE_GEN_PROVIDER"]
+	budgeted := monthlyBudget("GROUP_MONTHLY_BUDGET") > 0 || monthlyBudget("USER_MONTHLY_BUDGET") > 0
+	if model, known := imageGenModels[provider]; known && budgeted {
+		if _, ok := priceFor(model); !ok {
+			log.Printf("No price known for %s model %q, refusing to generate images while there's a budget", provider, model)
+			ctx.MessagePoster(req, "Sorry, I can't tell what images cost, so I can't make one while there's a budget.", "")
+			return fmt.Errorf("no price known for %s model %q", provider, model)
+		}
+	}
+
 	// Generate the image
-	switch Config["IMAGE_GEN_PROVIDER"] {
+	switch provider {
 	case "openai":
 		// Generate the image using OpenAI
-		filename, revisedPrompt, err = ctx.imagineOpenai(prompt, requestor, flavor)
+		filename, revisedPrompt, err = ctx.imagineOpenai(req, prompt, requestor, flavor)
 		if err != nil {
 			log.Println("Failed to generate image:", err)
-			ctx.MessagePoster("Failed to generate image: "+err.Error(), "")
-			return
+			ctx.MessagePoster(req, "Failed to generate image: "+err.Error(), "")
+			return err
 		}
 	case "google":
 		// Generate the image using Google
 		filename, revisedPrompt, err = imagineGoogle(prompt, requestor, flavor)
 		if err != nil {
 			log.Println("Failed to generate image:", err)
-			ctx.MessagePoster("Failed to generate image: "+err.Error(), "")
-			return
+			ctx.MessagePoster(req, "Failed to generate image: "+err.Error(), "")
+			return err
 		}
 	// Default case for other providers
 	default:
 		log.Println("Invalid image provider:", Config["IMAGE_GEN_PROVIDER"])
-		ctx.MessagePoster("Invalid image provider: "+Config["IMAGE_GEN_PROVIDER"], "")
-		return
+		ctx.MessagePoster(req, "Invalid image provider: "+Config["IMAGE_GEN_PROVIDER"], "")
+		return fmt.Errorf("invalid image provider: %s", Config["IMAGE_GEN_PROVIDER"])
 	}
 
-	ctx.MessagePoster(revisedPrompt, filename)
+	recordUsage(req.TraceContext, ctx.Store, provider, imageGenModels[provider], Usage{Images: 1})
+	ctx.MessagePoster(req, revisedPrompt, filename)
+	return nil
 }
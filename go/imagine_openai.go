@@ -13,13 +13,13 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
-func (ctx *AppContext) imagineOpenai(prompt string, requestor string, flavor string) (string, string, error) {
-	// Start a new span. During testing ctx.TraceContext may be nil so we need to check for that.
-	if ctx.TraceContext == nil {
-		ctx.TraceContext = context.Background()
+func (ctx *AppContext) imagineOpenai(req *RequestContext, prompt string, requestor string, flavor string) (string, string, error) {
+	// Start a new span. During testing req.TraceContext may be nil so we need to check for that.
+	if req.TraceContext == nil {
+		req.TraceContext = context.Background()
 	}
 	tracer := otel.Tracer("signal-bot")
-	_, span := tracer.Start(ctx.TraceContext, "imagineOpenai")
+	_, span := tracer.Start(req.TraceContext, "imagineOpenai")
 	defer span.End()
 	client := openai.NewClient(Config["OPENAI_API_KEY"])
 
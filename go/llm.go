@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The purposes a provider can be created for. Some providers are configured
+// with a different model for each.
+const (
+	PurposeSummary       = "summary"
+	PurposeChat          = "chat"
+	PurposeImageAnalysis = "image_analysis"
+)
+
+// LLMMessage is a single turn in a conversation
+type LLMMessage struct {
+	Role    string   // "user" or "assistant"
+	Content string   // The text of the message
+	Images  []string // Base64 encoded JPEG images sent along with the text
+}
+
+// LLMRequest is a request to generate text
+type LLMRequest struct {
+	SystemPrompt string
+	Messages     []LLMMessage
+	MaxTokens    int     // Zero uses the provider's default
+	Temperature  float32 // Zero uses the provider's default
+}
+
+// LLMResponse is the text generated by a provider
+type LLMResponse struct {
+	Text  string
+	Model string // The model which generated the text, as the provider's API names it
+	Usage Usage  // What the request used, zero if the provider doesn't say
+}
+
+// Usage is what a request to a provider used, as reported by the provider
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+	Images       int // Images generated
+}
+
+// LLMProvider generates text using a large language model.
+// Providers are created once at startup and must be safe for concurrent use.
+type LLMProvider interface {
+	// Name returns the name the provider was registered with
+	Name() string
+	// Generate runs the request and returns the generated text
+	Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+	// ContextWindow returns how many tokens the model accepts, prompt and reply together
+	ContextWindow() int
+	// EstimateTokens guesses how many tokens text uses with this model.
+	// It only needs to be close enough to decide how to split up long requests.
+	EstimateTokens(text string) int
+}
+
+// estimateTokens guesses the tokens in text from its length. Most tokenizers
+// average around four characters of English per token.
+func estimateTokens(text string, charsPerToken float64) int {
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / charsPerToken))
+}
+
+// llmProviderOptions configure a provider when it's created
+type llmProviderOptions struct {
+	Purpose string // One of the Purpose constants
+	Model   string // Overrides the model from the config, if set
+}
+
+type llmProviderFactory func(opts llmProviderOptions) (LLMProvider, error)
+
+// llmProviders holds every provider, keyed by the name used in the *_PROVIDER config.
+// Providers register themselves from an init() function in their own file.
+var llmProviders = map[string]llmProviderFactory{}
+
+func registerLLMProvider(name string, factory llmProviderFactory) {
+	if _, ok := llmProviders[name]; ok {
+		panic(fmt.Sprintf("LLM provider %s is already registered", name))
+	}
+	llmProviders[name] = factory
+}
+
+func newLLMProvider(name string, opts llmProviderOptions) (LLMProvider, error) {
+	factory, ok := llmProviders[name]
+	if !ok {
+		var names []string
+		for name := range llmProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown provider %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return factory(opts)
+}
+
+// textRequest builds a request with a single user message
+func textRequest(prompt string) LLMRequest {
+	return LLMRequest{Messages: []LLMMessage{{Role: "user", Content: prompt}}}
+}
+
+// debugProvider echoes the request back, which is handy for testing without an API key
+type debugProvider struct{}
+
+func (p *debugProvider) Name() string {
+	return "debug"
+}
+
+func (p *debugProvider) ContextWindow() int {
+	return 8192
+}
+
+func (p *debugProvider) EstimateTokens(text string) int {
+	return estimateTokens(text, 4)
+}
+
+func (p *debugProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	var parts []string
+	for _, message := range req.Messages {
+		parts = append(parts, fmt.Sprintf("%s: %s", message.Role, message.Content))
+	}
+	text := "DEBUG: " + strings.Join(parts, "\n")
+	usage := Usage{InputTokens: p.EstimateTokens(req.SystemPrompt + strings.Join(parts, "\n")), OutputTokens: p.EstimateTokens(text)}
+	return &LLMResponse{Text: text, Model: "debug", Usage: usage}, nil
+}
+
+func init() {
+	registerLLMProvider("debug", func(opts llmProviderOptions) (LLMProvider, error) {
+		return &debugProvider{}, nil
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+)
+
+const claudeAPIURL = "https://api.anthropic.com/v1/messages"
+
+// Every model we accept has a 200k token context window
+const claudeContextWindow = 200000
+
+// ClaudeContentBlock is a single piece of text or image in a message
+type ClaudeContentBlock struct {
+	Type   string             `json:"type"`
+	Text   string             `json:"text,omitempty"`
+	Source *ClaudeImageSource `json:"source,omitempty"`
+}
+
+type ClaudeImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// ClaudeMessage represents a message in the Claude API format
+type ClaudeMessage struct {
+	Role    string               `json:"role"`
+	Content []ClaudeContentBlock `json:"content"`
+}
+
+// ClaudeRequest represents a request to the Claude API
+type ClaudeRequest struct {
+	Model       string          `json:"model"`
+	System      string          `json:"system,omitempty"`
+	Messages    []ClaudeMessage `json:"messages"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature float32         `json:"temperature,omitempty"`
+}
+
+// ClaudeResponse represents a response from the Claude API
+type ClaudeResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Text string `json:"text"`
+	} `json:"content"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+func getClaudeModelName(modelName string) (string, error) {
+	// Map the models we accept
+	allowedModels := map[string]string{
+		"Claude37Sonnet": "claude-3-7-sonnet-20250219",
+		"Claude35Sonnet": "claude-3-5-sonnet-20241022",
+		"Claude35Haiku":  "claude-3-5-haiku-20241022",
+	}
+	// If modelName is not in the allowedModels map, return an error
+	if _, ok := allowedModels[modelName]; !ok {
+		return "", fmt.Errorf("model %s is not supported", modelName)
+	}
+	modelName = allowedModels[modelName]
+	return modelName, nil
+}
+
+// claudeProvider generates text using Anthropic's Messages API
+type claudeProvider struct {
+	apiURL string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func newClaudeProvider(opts llmProviderOptions) (LLMProvider, error) {
+	// Validate the correct configuration is set
+	if Config["CLAUDE_API_KEY"] == "" {
+		return nil, fmt.Errorf("CLAUDE_API_KEY is not set")
+	}
+	model := Config["CLAUDE_MODEL"]
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	if model == "" {
+		return nil, fmt.Errorf("CLAUDE_MODEL is not set")
+	}
+	modelName, err := getClaudeModelName(model)
+	if err != nil {
+		return nil, err
+	}
+	return &claudeProvider{
+		apiURL: claudeAPIURL,
+		apiKey: Config["CLAUDE_API_KEY"],
+		model:  modelName,
+		client: &http.Client{},
+	}, nil
+}
+
+func (p *claudeProvider) Name() string {
+	return "claude"
+}
+
+func (p *claudeProvider) ContextWindow() int {
+	return claudeContextWindow
+}
+
+func (p *claudeProvider) EstimateTokens(text string) int {
+	// Claude's tokenizer uses slightly more tokens than OpenAI's for the same text
+	return estimateTokens(text, 3.5)
+}
+
+func (p *claudeProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	ctx, span := tracer.Start(ctx, "claudeGenerate")
+	defer span.End()
+
+	claudeReq := ClaudeRequest{
+		Model:       p.model,
+		System:      req.SystemPrompt,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	// The API requires max_tokens to be set
+	if claudeReq.MaxTokens == 0 {
+		claudeReq.MaxTokens = 4096
+	}
+	for _, message := range req.Messages {
+		var content []ClaudeContentBlock
+		for _, image := range message.Images {
+			content = append(content, ClaudeContentBlock{
+				Type: "image",
+				Source: &ClaudeImageSource{
+					Type:      "base64",
+					MediaType: "image/jpeg",
+					Data:      image,
+				},
+			})
+		}
+		content = append(content, ClaudeContentBlock{Type: "text", Text: message.Content})
+		claudeReq.Messages = append(claudeReq.Messages, ClaudeMessage{Role: message.Role, Content: content})
+	}
+
+	claudeResp, err := p.send(ctx, claudeReq)
+	if err != nil {
+		return nil, err
+	}
+	if len(claudeResp.Content) == 0 {
+		return nil, fmt.Errorf("empty response from Claude API")
+	}
+	return &LLMResponse{
+		Text:  claudeResp.Content[0].Text,
+		Model: claudeResp.Model,
+		Usage: Usage{InputTokens: claudeResp.Usage.InputTokens, OutputTokens: claudeResp.Usage.OutputTokens},
+	}, nil
+}
+
+func (p *claudeProvider) send(ctx context.Context, req ClaudeRequest) (*ClaudeResponse, error) {
+	// Marshal the request to JSON
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	// Create the HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.apiURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+
+	// Set the headers
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	// Send the request
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to Claude API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	// Check for errors
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("claude API error: %s", string(respBody))
+	}
+
+	// Unmarshal the response
+	var claudeResp ClaudeResponse
+	err = json.Unmarshal(respBody, &claudeResp)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+
+	return &claudeResp, nil
+}
+
+func init() {
+	registerLLMProvider("claude", newClaudeProvider)
+}
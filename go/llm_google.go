@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+	"go.opentelemetry.io/otel"
+)
+
+// googleProvider generates text using Gemini models on Vertex AI
+type googleProvider struct {
+	client *genai.Client
+	model  string
+}
+
+func newGoogleProvider(opts llmProviderOptions) (LLMProvider, error) {
+	// Validate the correct configuration is set
+	for _, key := range []string{"GOOGLE_PROJECT_ID", "GOOGLE_LOCATION"} {
+		if Config[key] == "" {
+			return nil, fmt.Errorf("%s is not set", key)
+		}
+	}
+	model := Config["GOOGLE_TEXT_MODEL"]
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	if model == "" {
+		return nil, fmt.Errorf("GOOGLE_TEXT_MODEL is not set")
+	}
+
+	// The client is shared by every request for the life of the bot
+	client, err := genai.NewClient(context.Background(), Config["GOOGLE_PROJECT_ID"], Config["GOOGLE_LOCATION"])
+	if err != nil {
+		return nil, fmt.Errorf("error creating google vertex client: %w", err)
+	}
+	return &googleProvider{client: client, model: model}, nil
+}
+
+func (p *googleProvider) Name() string {
+	return "google"
+}
+
+func (p *googleProvider) ContextWindow() int {
+	// Gemini 1.5 and later accept a million tokens, older models far fewer
+	if strings.HasPrefix(p.model, "gemini-1.0") || p.model == "gemini-pro" {
+		return 32768
+	}
+	return 1048576
+}
+
+func (p *googleProvider) EstimateTokens(text string) int {
+	return estimateTokens(text, 4)
+}
+
+func (p *googleProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	ctx, span := tracer.Start(ctx, "googleGenerate")
+	defer span.End()
+
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("no messages to send")
+	}
+
+	// GenerativeModel holds per-request settings, so create one for each request
+	model := p.client.GenerativeModel(p.model)
+	model.SafetySettings = []*genai.SafetySetting{
+		{
+			Category:  genai.HarmCategoryHarassment,
+			Threshold: genai.HarmBlockOnlyHigh,
+		},
+		{
+			Category:  genai.HarmCategoryDangerousContent,
+			Threshold: genai.HarmBlockOnlyHigh,
+		},
+		{
+			Category:  genai.HarmCategorySexuallyExplicit,
+			Threshold: genai.HarmBlockOnlyHigh,
+		},
+		{
+			Category:  genai.HarmCategoryHateSpeech,
+			Threshold: genai.HarmBlockOnlyHigh,
+		},
+	}
+	if req.SystemPrompt != "" {
+		model.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(req.SystemPrompt)}}
+	}
+	if req.MaxTokens > 0 {
+		model.SetMaxOutputTokens(int32(req.MaxTokens))
+	}
+	if req.Temperature > 0 {
+		model.SetTemperature(req.Temperature)
+	}
+
+	// Everything but the last message is history, the last message is sent
+	chat := model.StartChat()
+	for _, message := range req.Messages[:len(req.Messages)-1] {
+		parts, err := googleParts(message)
+		if err != nil {
+			return nil, err
+		}
+		role := "user"
+		if message.Role == "assistant" {
+			role = "model"
+		}
+		chat.History = append(chat.History, &genai.Content{Role: role, Parts: parts})
+	}
+	parts, err := googleParts(req.Messages[len(req.Messages)-1])
+	if err != nil {
+		return nil, err
+	}
+	resp, err := chat.SendMessage(ctx, parts...)
+	if err != nil {
+		return nil, fmt.Errorf("error generating content: %w", err)
+	}
+
+	var text []string
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			text = append(text, fmt.Sprintf("%s", part))
+		}
+	}
+	response := &LLMResponse{Text: strings.Join(text, "\n"), Model: p.model}
+	if resp.UsageMetadata != nil {
+		response.Usage = Usage{
+			InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
+			OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+		}
+	}
+	return response, nil
+}
+
+func googleParts(message LLMMessage) ([]genai.Part, error) {
+	// Convert a message to the parts Vertex expects, decoding any images
+	var parts []genai.Part
+	for _, image := range message.Images {
+		data, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		parts = append(parts, genai.ImageData("jpeg", data))
+	}
+	return append(parts, genai.Text(message.Content)), nil
+}
+
+func init() {
+	registerLLMProvider("google", newGoogleProvider)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGoogleProvider(t *testing.T) {
+	setupTestEnv()
+	chatLog := "This is a chat log."
+
+	provider, err := newLLMProvider("google", llmProviderOptions{Purpose: PurposeSummary})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := provider.Generate(context.Background(), textRequest(getSummaryPromptFromFile()+"\n"+chatLog))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.Text == "" {
+		t.Errorf("expected a response from Google, got '%s'", resp.Text)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel"
+)
+
+// The local provider talks to a self-hosted model server so chat logs never
+// leave our own infrastructure. It's configured with:
+//   LOCAL_LLM_URL: the base URL of the server, eg. http://localhost:11434 for
+//     Ollama or http://localhost:8000/v1 for an OpenAI compatible server
+//   LOCAL_LLM_API: "openai" (the default) or "ollama"
+//   LOCAL_LLM_MODEL: the model to use, any name the server accepts
+//   LOCAL_LLM_VISION_MODEL: the model used for image analysis, defaults to LOCAL_LLM_MODEL
+//   LOCAL_LLM_API_KEY: sent as a bearer token, if the server needs one
+//   LOCAL_LLM_CONTEXT_WINDOW: the model's context window in tokens, defaults to 8192
+//   LOCAL_LLM_EMBEDDING_MODEL: the model used for embeddings, if EMBEDDING_PROVIDER is local
+
+// OllamaMessage is a message in the Ollama chat API format
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// OllamaOptions are the model parameters we set on Ollama requests
+type OllamaOptions struct {
+	Temperature float32 `json:"temperature,omitempty"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+	NumCtx      int     `json:"num_ctx,omitempty"`
+}
+
+// OllamaRequest is a request to Ollama's /api/chat endpoint
+type OllamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+// OllamaResponse is a non-streaming response from /api/chat
+type OllamaResponse struct {
+	Model           string        `json:"model"`
+	Message         OllamaMessage `json:"message"`
+	Error           string        `json:"error,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// defaultLocalContextWindow is used when LOCAL_LLM_CONTEXT_WINDOW isn't set.
+// It's on the small side for current models, but many servers default to it.
+const defaultLocalContextWindow = 8192
+
+// localProvider generates text using a self-hosted model server
+type localProvider struct {
+	baseURL string
+	api     string
+	apiKey  string
+	model   string
+	window  int
+	client  *http.Client
+	openai  *openai.Client
+}
+
+func newLocalProvider(opts llmProviderOptions) (LLMProvider, error) {
+	// Validate the correct configuration is set
+	if Config["LOCAL_LLM_URL"] == "" {
+		return nil, fmt.Errorf("LOCAL_LLM_URL is not set")
+	}
+	model := Config["LOCAL_LLM_MODEL"]
+	if opts.Purpose == PurposeImageAnalysis && Config["LOCAL_LLM_VISION_MODEL"] != "" {
+		model = Config["LOCAL_LLM_VISION_MODEL"]
+	}
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	// Any model the server knows about is accepted, there's no fixed list
+	if model == "" {
+		return nil, fmt.Errorf("LOCAL_LLM_MODEL is not set")
+	}
+
+	p := &localProvider{
+		baseURL: strings.TrimSuffix(Config["LOCAL_LLM_URL"], "/"),
+		api:     Config["LOCAL_LLM_API"],
+		apiKey:  Config["LOCAL_LLM_API_KEY"],
+		model:   model,
+		window:  defaultLocalContextWindow,
+		client:  &http.Client{},
+	}
+	if Config["LOCAL_LLM_CONTEXT_WINDOW"] != "" {
+		window, err := strconv.Atoi(Config["LOCAL_LLM_CONTEXT_WINDOW"])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("LOCAL_LLM_CONTEXT_WINDOW must be a positive number, not %s", Config["LOCAL_LLM_CONTEXT_WINDOW"])
+		}
+		p.window = window
+	}
+	switch p.api {
+	case "", "openai":
+		p.api = "openai"
+		config := openai.DefaultConfig(p.apiKey)
+		config.BaseURL = p.baseURL
+		p.openai = openai.NewClientWithConfig(config)
+	case "ollama":
+	default:
+		return nil, fmt.Errorf("LOCAL_LLM_API must be openai or ollama, not %s", p.api)
+	}
+	return p, nil
+}
+
+func (p *localProvider) Name() string {
+	return "local"
+}
+
+func (p *localProvider) ContextWindow() int {
+	return p.window
+}
+
+func (p *localProvider) EstimateTokens(text string) int {
+	return estimateTokens(text, 4)
+}
+
+func (p *localProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	ctx, span := tracer.Start(ctx, "localGenerate")
+	defer span.End()
+
+	if p.api == "ollama" {
+		return p.generateOllama(ctx, req)
+	}
+	return generateOpenaiCompatible(ctx, p.openai, p.model, req)
+}
+
+func (p *localProvider) generateOllama(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	ollamaReq := OllamaRequest{Model: p.model, Stream: false}
+	if req.SystemPrompt != "" {
+		ollamaReq.Messages = append(ollamaReq.Messages, OllamaMessage{Role: "system", Content: req.SystemPrompt})
+	}
+	for _, message := range req.Messages {
+		ollamaReq.Messages = append(ollamaReq.Messages, OllamaMessage{
+			Role:    message.Role,
+			Content: message.Content,
+			Images:  message.Images,
+		})
+	}
+	// Ollama cuts requests off at its own default context size unless told otherwise
+	ollamaReq.Options = &OllamaOptions{Temperature: req.Temperature, NumPredict: req.MaxTokens, NumCtx: p.window}
+
+	// Marshal the request to JSON
+	reqBody, err := json.Marshal(ollamaReq)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama API error: %s", string(respBody))
+	}
+
+	var ollamaResp OllamaResponse
+	if err := json.Unmarshal(respBody, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+	if ollamaResp.Error != "" {
+		return nil, fmt.Errorf("ollama API error: %s", ollamaResp.Error)
+	}
+	return &LLMResponse{
+		Text:  ollamaResp.Message.Content,
+		Model: ollamaResp.Model,
+		Usage: Usage{InputTokens: ollamaResp.PromptEvalCount, OutputTokens: ollamaResp.EvalCount},
+	}, nil
+}
+
+// OllamaEmbedRequest is a request to Ollama's /api/embed endpoint
+type OllamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// OllamaEmbedResponse is a response from /api/embed
+type OllamaEmbedResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+	Error           string      `json:"error,omitempty"`
+}
+
+func newLocalEmbeddingProvider(opts llmProviderOptions) (EmbeddingProvider, error) {
+	// Embeddings need their own model, the chat models can't produce them
+	if opts.Model == "" {
+		opts.Model = Config["LOCAL_LLM_EMBEDDING_MODEL"]
+	}
+	if opts.Model == "" {
+		return nil, fmt.Errorf("LOCAL_LLM_EMBEDDING_MODEL is not set")
+	}
+	provider, err := newLocalProvider(opts)
+	if err != nil {
+		return nil, err
+	}
+	return provider.(*localProvider), nil
+}
+
+func (p *localProvider) Model() string {
+	return p.model
+}
+
+func (p *localProvider) Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	ctx, span := tracer.Start(ctx, "localEmbed")
+	defer span.End()
+
+	if p.api != "ollama" {
+		return embedOpenaiCompatible(ctx, p.openai, p.model, texts)
+	}
+
+	reqBody, err := json.Marshal(OllamaEmbedRequest{Model: p.model, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/embed", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request to Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama API error: %s", string(respBody))
+	}
+
+	var embedResp OllamaEmbedResponse
+	if err := json.Unmarshal(respBody, &embedResp); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response: %w", err)
+	}
+	if embedResp.Error != "" {
+		return nil, fmt.Errorf("ollama API error: %s", embedResp.Error)
+	}
+	if len(embedResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings from %s, got %d", len(texts), p.model, len(embedResp.Embeddings))
+	}
+	return &EmbeddingResponse{
+		Vectors: embedResp.Embeddings,
+		Usage:   Usage{InputTokens: embedResp.PromptEvalCount},
+	}, nil
+}
+
+func init() {
+	registerLLMProvider("local", newLocalProvider)
+	registerEmbeddingProvider("local", newLocalEmbeddingProvider)
+}
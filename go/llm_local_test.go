@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalProviderOllama(t *testing.T) {
+	var got OllamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		w.Write([]byte(`{"message":{"role":"assistant","content":"A local summary"},"done":true}`))
+	}))
+	defer server.Close()
+
+	Config = map[string]string{
+		"LOCAL_LLM_URL":          server.URL + "/",
+		"LOCAL_LLM_API":          "ollama",
+		"LOCAL_LLM_MODEL":        "llama3.1:8b-instruct-q4_K_M",
+		"LOCAL_LLM_VISION_MODEL": "llava:13b",
+	}
+	provider, err := newLLMProvider("local", llmProviderOptions{Purpose: PurposeImageAnalysis})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := provider.Generate(context.Background(), LLMRequest{
+		SystemPrompt: "Be nice",
+		Messages:     []LLMMessage{{Role: "user", Content: "Describe this", Images: []string{"aW1hZ2U="}}},
+		MaxTokens:    300,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "A local summary" {
+		t.Errorf("expected text %q, got %q", "A local summary", resp.Text)
+	}
+	// Image analysis uses the vision model, and arbitrary model names are accepted
+	if got.Model != "llava:13b" || got.Stream {
+		t.Errorf("unexpected request: %+v", got)
+	}
+	if len(got.Messages) != 2 || got.Messages[0].Role != "system" || len(got.Messages[1].Images) != 1 {
+		t.Errorf("unexpected messages: %+v", got.Messages)
+	}
+	if got.Options == nil || got.Options.NumPredict != 300 {
+		t.Errorf("unexpected options: %+v", got.Options)
+	}
+}
+
+func TestLocalProviderOpenaiCompatible(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var got map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["model"] != "mistral-7b-instruct" {
+			t.Errorf("unexpected model %v", got["model"])
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"An answer"}}]}`))
+	}))
+	defer server.Close()
+
+	Config = map[string]string{
+		"LOCAL_LLM_URL":   server.URL + "/v1",
+		"LOCAL_LLM_MODEL": "mistral-7b-instruct",
+	}
+	provider, err := newLLMProvider("local", llmProviderOptions{Purpose: PurposeSummary})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := provider.Generate(context.Background(), textRequest("Summarize this"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "An answer" {
+		t.Errorf("expected text %q, got %q", "An answer", resp.Text)
+	}
+}
+
+func TestLocalProviderConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+	}{
+		{"Missing URL", map[string]string{"LOCAL_LLM_MODEL": "llama3"}},
+		{"Missing model", map[string]string{"LOCAL_LLM_URL": "http://localhost:11434"}},
+		{"Unknown API", map[string]string{"LOCAL_LLM_URL": "http://localhost:11434", "LOCAL_LLM_MODEL": "llama3", "LOCAL_LLM_API": "grpc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config = tt.config
+			if _, err := newLLMProvider("local", llmProviderOptions{Purpose: PurposeSummary}); err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestLocalEmbeddingProviderOllama(t *testing.T) {
+	var got OllamaEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		w.Write([]byte(`{"embeddings":[[0.1,0.2],[0.3,0.4]],"prompt_eval_count":7}`))
+	}))
+	defer server.Close()
+
+	Config = map[string]string{
+		"LOCAL_LLM_URL":             server.URL,
+		"LOCAL_LLM_API":             "ollama",
+		"LOCAL_LLM_MODEL":           "llama3.1:8b",
+		"LOCAL_LLM_EMBEDDING_MODEL": "nomic-embed-text",
+	}
+	embedder, err := newEmbeddingProvider("local", llmProviderOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if embedder.Model() != "nomic-embed-text" {
+		t.Errorf("expected the embedding model, got %s", embedder.Model())
+	}
+	resp, err := embedder.Embed(context.Background(), []string{"one", "two"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Vectors) != 2 || resp.Vectors[1][1] != 0.4 {
+		t.Errorf("unexpected vectors %v", resp.Vectors)
+	}
+	if resp.Usage.InputTokens != 7 {
+		t.Errorf("expected the prompt tokens to be reported, got %+v", resp.Usage)
+	}
+	if got.Model != "nomic-embed-text" || len(got.Input) != 2 {
+		t.Errorf("unexpected request: %+v", got)
+	}
+
+	// Without an embedding model there's nothing to use
+	Config["LOCAL_LLM_EMBEDDING_MODEL"] = ""
+	if _, err := newEmbeddingProvider("local", llmProviderOptions{}); err == nil {
+		t.Errorf("expected an error without LOCAL_LLM_EMBEDDING_MODEL")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel"
+)
+
+// Rather than trying to use reflection magic, we'll map the models we accept.
+// This mean we'll have to keep this list up to date as OpenAI add more
+// models but it's not a big lift.
+var openaiSummaryModels = map[string]string{
+	"GPT3Dot5Turbo": openai.GPT3Dot5Turbo,
+	"GPT4o":         openai.GPT4o,
+	"O1Mini":        openai.O1Mini,
+}
+
+// The context window of each model, in tokens
+var openaiContextWindows = map[string]int{
+	openai.GPT3Dot5Turbo: 16385,
+	openai.GPT4o:         128000,
+	openai.GPT4oMini:     128000,
+	openai.O1Mini:        128000,
+}
+
+// Chat and image analysis need a model which accepts images
+var openaiChatModels = map[string]string{
+	"GPT4o":     openai.GPT4o,
+	"GPT4oMini": openai.GPT4oMini,
+}
+
+// openaiProvider generates text using OpenAI's Chat Completions API
+type openaiProvider struct {
+	client *openai.Client
+	model  string
+}
+
+func newOpenaiProvider(opts llmProviderOptions) (LLMProvider, error) {
+	// Validate the correct configuration is set
+	if Config["OPENAI_API_KEY"] == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+	}
+
+	// Summaries use OPENAI_MODEL, everything else needs a model that can see images
+	configKey, allowedModels := "OPENAI_MODEL", openaiSummaryModels
+	if opts.Purpose != PurposeSummary {
+		configKey, allowedModels = "OPENAI_CHAT_MODEL", openaiChatModels
+	}
+	modelName := Config[configKey]
+	if opts.Model != "" {
+		modelName = opts.Model
+	}
+	if modelName == "" {
+		return nil, fmt.Errorf("%s is not set", configKey)
+	}
+	// If modelName is not in the allowedModels map, return an error.
+	if _, ok := allowedModels[modelName]; !ok {
+		return nil, fmt.Errorf("model %s is not supported", modelName)
+	}
+
+	return &openaiProvider{
+		client: openai.NewClient(Config["OPENAI_API_KEY"]),
+		model:  allowedModels[modelName],
+	}, nil
+}
+
+func (p *openaiProvider) Name() string {
+	return "openai"
+}
+
+func (p *openaiProvider) ContextWindow() int {
+	return openaiContextWindows[p.model]
+}
+
+func (p *openaiProvider) EstimateTokens(text string) int {
+	return estimateTokens(text, 4)
+}
+
+func (p *openaiProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	ctx, span := tracer.Start(ctx, "openaiGenerate")
+	defer span.End()
+
+	return generateOpenaiCompatible(ctx, p.client, p.model, req)
+}
+
+// generateOpenaiCompatible runs a request against any API which speaks the
+// OpenAI Chat Completions protocol.
+func generateOpenaiCompatible(ctx context.Context, client *openai.Client, model string, req LLMRequest) (*LLMResponse, error) {
+	var messages []openai.ChatCompletionMessage
+	// The O1Mini model doesn't accept system messages
+	if req.SystemPrompt != "" && model != openai.O1Mini {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: req.SystemPrompt,
+		})
+	}
+	for _, message := range req.Messages {
+		role := openai.ChatMessageRoleUser
+		if message.Role == "assistant" {
+			role = openai.ChatMessageRoleAssistant
+		}
+		if len(message.Images) == 0 {
+			messages = append(messages, openai.ChatCompletionMessage{Role: role, Content: message.Content})
+			continue
+		}
+		parts := []openai.ChatMessagePart{
+			{
+				Type: openai.ChatMessagePartTypeText,
+				Text: message.Content,
+			},
+		}
+		for _, image := range message.Images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL: "data:image/jpeg;base64," + image,
+				},
+			})
+		}
+		messages = append(messages, openai.ChatCompletionMessage{Role: role, MultiContent: parts})
+	}
+
+	chatReq := openai.ChatCompletionRequest{
+		Model:       model,
+		Messages:    messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	resp, err := client.CreateChatCompletion(ctx, chatReq)
+	if err != nil {
+		fmt.Printf("ChatCompletion error: %v\n", err)
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from %s", model)
+	}
+	return &LLMResponse{
+		Text:  resp.Choices[0].Message.Content,
+		Model: resp.Model,
+		Usage: Usage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens},
+	}, nil
+}
+
+// defaultOpenaiEmbeddingModel is used when EMBEDDING_MODEL isn't set
+const defaultOpenaiEmbeddingModel = string(openai.SmallEmbedding3)
+
+// openaiEmbeddingProvider embeds text using OpenAI's Embeddings API
+type openaiEmbeddingProvider struct {
+	client *openai.Client
+	model  string
+}
+
+func newOpenaiEmbeddingProvider(opts llmProviderOptions) (EmbeddingProvider, error) {
+	// Validate the correct configuration is set
+	if Config["OPENAI_API_KEY"] == "" {
+		return nil, fmt.Errorf("OPENAI_API_KEY is not set")
+	}
+	model := opts.Model
+	if model == "" {
+		model = defaultOpenaiEmbeddingModel
+	}
+	return &openaiEmbeddingProvider{
+		client: openai.NewClient(Config["OPENAI_API_KEY"]),
+		model:  model,
+	}, nil
+}
+
+func (p *openaiEmbeddingProvider) Name() string {
+	return "openai"
+}
+
+func (p *openaiEmbeddingProvider) Model() string {
+	return p.model
+}
+
+func (p *openaiEmbeddingProvider) Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	ctx, span := tracer.Start(ctx, "openaiEmbed")
+	defer span.End()
+
+	return embedOpenaiCompatible(ctx, p.client, p.model, texts)
+}
+
+// embedOpenaiCompatible embeds text using any API which speaks the OpenAI
+// Embeddings protocol.
+func embedOpenaiCompatible(ctx context.Context, client *openai.Client, model string, texts []string) (*EmbeddingResponse, error) {
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating embeddings: %w", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings from %s, got %d", len(texts), model, len(resp.Data))
+	}
+	// The results carry their index, don't rely on them being in order
+	vectors := make([][]float32, len(texts))
+	for _, embedding := range resp.Data {
+		if embedding.Index < 0 || embedding.Index >= len(texts) {
+			return nil, fmt.Errorf("unexpected embedding index %d from %s", embedding.Index, model)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+	return &EmbeddingResponse{Vectors: vectors, Usage: Usage{InputTokens: resp.Usage.PromptTokens}}, nil
+}
+
+func init() {
+	registerLLMProvider("openai", newOpenaiProvider)
+	registerEmbeddingProvider("openai", newOpenaiEmbeddingProvider)
+}
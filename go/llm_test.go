@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestNewLLMProvider(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  string
+		opts      llmProviderOptions
+		config    map[string]string
+		expectErr bool
+	}{
+		{
+			name:      "Unknown provider",
+			provider:  "nosuchprovider",
+			expectErr: true,
+		},
+		{
+			name:     "Debug provider",
+			provider: "debug",
+		},
+		{
+			name:     "OpenAI summary model",
+			provider: "openai",
+			opts:     llmProviderOptions{Purpose: PurposeSummary},
+			config:   map[string]string{"OPENAI_API_KEY": "key", "OPENAI_MODEL": "O1Mini"},
+		},
+		{
+			name:      "OpenAI chat model isn't valid for summaries",
+			provider:  "openai",
+			opts:      llmProviderOptions{Purpose: PurposeSummary},
+			config:    map[string]string{"OPENAI_API_KEY": "key", "OPENAI_MODEL": "GPT4oMini"},
+			expectErr: true,
+		},
+		{
+			name:     "OpenAI image analysis uses the chat model",
+			provider: "openai",
+			opts:     llmProviderOptions{Purpose: PurposeImageAnalysis},
+			config:   map[string]string{"OPENAI_API_KEY": "key", "OPENAI_CHAT_MODEL": "GPT4oMini"},
+		},
+		{
+			name:      "OpenAI without an API key",
+			provider:  "openai",
+			opts:      llmProviderOptions{Purpose: PurposeSummary},
+			config:    map[string]string{"OPENAI_MODEL": "GPT4o"},
+			expectErr: true,
+		},
+		{
+			name:     "Claude with a model override",
+			provider: "claude",
+			opts:     llmProviderOptions{Purpose: PurposeSummary, Model: "Claude35Haiku"},
+			config:   map[string]string{"CLAUDE_API_KEY": "key"},
+		},
+		{
+			name:      "Claude with an unsupported model",
+			provider:  "claude",
+			opts:      llmProviderOptions{Purpose: PurposeSummary},
+			config:    map[string]string{"CLAUDE_API_KEY": "key", "CLAUDE_MODEL": "Claude1"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config = tt.config
+			if Config == nil {
+				Config = map[string]string{}
+			}
+			provider, err := newLLMProvider(tt.provider, tt.opts)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("newLLMProvider() error = %v, expectErr %v", err, tt.expectErr)
+			}
+			if err == nil && provider.Name() != tt.provider {
+				t.Errorf("Name() = %s, want %s", provider.Name(), tt.provider)
+			}
+		})
+	}
+}
+
+func TestClaudeProviderGenerate(t *testing.T) {
+	var got ClaudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" {
+			t.Errorf("unexpected api key %q", r.Header.Get("x-api-key"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		w.Write([]byte(`{"content":[{"type":"text","text":"A summary"}]}`))
+	}))
+	defer server.Close()
+
+	provider := &claudeProvider{apiURL: server.URL, apiKey: "key", model: "claude-test", client: server.Client()}
+	resp, err := provider.Generate(context.Background(), LLMRequest{
+		SystemPrompt: "Be nice",
+		Messages: []LLMMessage{
+			{Role: "user", Content: "What is this?", Images: []string{"aW1hZ2U="}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "A summary" {
+		t.Errorf("expected text %q, got %q", "A summary", resp.Text)
+	}
+	if got.System != "Be nice" || got.MaxTokens != 4096 || got.Model != "claude-test" {
+		t.Errorf("unexpected request: %+v", got)
+	}
+	if len(got.Messages) != 1 || len(got.Messages[0].Content) != 2 ||
+		got.Messages[0].Content[0].Type != "image" || got.Messages[0].Content[1].Text != "What is this?" {
+		t.Errorf("unexpected messages: %+v", got.Messages)
+	}
+}
+
+func TestGenerateOpenaiCompatible(t *testing.T) {
+	var got openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"An answer"}}]}`))
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL + "/v1"
+	client := openai.NewClientWithConfig(config)
+
+	resp, err := generateOpenaiCompatible(context.Background(), client, openai.O1Mini, LLMRequest{
+		SystemPrompt: "Be nice",
+		Messages: []LLMMessage{
+			{Role: "user", Content: "Hello"},
+			{Role: "assistant", Content: "Hi"},
+			{Role: "user", Content: "How are you?"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Text != "An answer" {
+		t.Errorf("expected text %q, got %q", "An answer", resp.Text)
+	}
+	// O1Mini doesn't accept a system prompt, so it's dropped
+	if len(got.Messages) != 3 || got.Messages[1].Role != openai.ChatMessageRoleAssistant {
+		t.Errorf("unexpected messages: %+v", got.Messages)
+	}
+}
@@ -2,22 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
 
-	"math/rand/v2"
-
 	"github.com/gorilla/websocket"
 	_ "github.com/mattn/go-sqlite3"
 
@@ -36,6 +35,7 @@ import (
 //   PHONE: the account phone number
 //   URL: the URL of the WebSocket server
 //   REST_URL: the URL of the REST API server
+//   POLL_INTERVAL: how often to poll REST_URL for messages in rest mode (optional)
 //   MAX_AGE: the maximum age of messages to keep
 
 var Config = map[string]string{
@@ -54,15 +54,30 @@ var Config = map[string]string{
 
 // These parameters are situational and depends on the provider requested.
 var optionalConfig = map[string]string{
-	"CLAUDE_API_KEY":    os.Getenv("CLAUDE_API_KEY"),
-	"CLAUDE_MODEL":      os.Getenv("CLAUDE_MODEL"),
-	"GOOGLE_PROJECT_ID": os.Getenv("GOOGLE_PROJECT_ID"),
-	"GOOGLE_LOCATION":   os.Getenv("GOOGLE_LOCATION"),
-	"GOOGLE_TEXT_MODEL": os.Getenv("GOOGLE_TEXT_MODEL"),
-	"OPENAI_API_KEY":    os.Getenv("OPENAI_API_KEY"),
-	"OPENAI_CHAT_MODEL": os.Getenv("OPENAI_CHAT_MODEL"),
-	"OPENAI_MODEL":      os.Getenv("OPENAI_MODEL"),
-	"PPROF_PORT":        os.Getenv("PPROF_PORT"),
+	"BOT_OWNERS":                os.Getenv("BOT_OWNERS"),
+	"CLAUDE_API_KEY":            os.Getenv("CLAUDE_API_KEY"),
+	"CLAUDE_MODEL":              os.Getenv("CLAUDE_MODEL"),
+	"EMBEDDING_MODEL":           os.Getenv("EMBEDDING_MODEL"),
+	"EMBEDDING_PROVIDER":        os.Getenv("EMBEDDING_PROVIDER"),
+	"GOOGLE_PROJECT_ID":         os.Getenv("GOOGLE_PROJECT_ID"),
+	"GROUP_MONTHLY_BUDGET":      os.Getenv("GROUP_MONTHLY_BUDGET"),
+	"GOOGLE_LOCATION":           os.Getenv("GOOGLE_LOCATION"),
+	"GOOGLE_TEXT_MODEL":         os.Getenv("GOOGLE_TEXT_MODEL"),
+	"LOCAL_LLM_API":             os.Getenv("LOCAL_LLM_API"),
+	"LOCAL_LLM_API_KEY":         os.Getenv("LOCAL_LLM_API_KEY"),
+	"LOCAL_LLM_CONTEXT_WINDOW":  os.Getenv("LOCAL_LLM_CONTEXT_WINDOW"),
+	"LOCAL_LLM_EMBEDDING_MODEL": os.Getenv("LOCAL_LLM_EMBEDDING_MODEL"),
+	"LOCAL_LLM_MODEL":           os.Getenv("LOCAL_LLM_MODEL"),
+	"LOCAL_LLM_URL":             os.Getenv("LOCAL_LLM_URL"),
+	"LOCAL_LLM_VISION_MODEL":    os.Getenv("LOCAL_LLM_VISION_MODEL"),
+	"OPENAI_API_KEY":            os.Getenv("OPENAI_API_KEY"),
+	"OPENAI_CHAT_MODEL":         os.Getenv("OPENAI_CHAT_MODEL"),
+	"OPENAI_MODEL":              os.Getenv("OPENAI_MODEL"),
+	"POLL_INTERVAL":             os.Getenv("POLL_INTERVAL"),
+	"PPROF_PORT":                os.Getenv("PPROF_PORT"),
+	"RATE_LIMITS":               os.Getenv("RATE_LIMITS"),
+	"TIMEZONE":                  os.Getenv("TIMEZONE"),
+	"USER_MONTHLY_BUDGET":       os.Getenv("USER_MONTHLY_BUDGET"),
 }
 
 func initTracer() func() {
@@ -93,204 +108,223 @@ func initTracer() func() {
 	}
 }
 
-func initImageAnalyzer() ImageAnalysisFunc {
+func initImageAnalyzer(store Store) ImageAnalysisFunc {
 	// Set the image analyzer based on the configured provider
-	switch Config["IMAGE_ANALYSIS_PROVIDER"] {
-	case "claude":
-		return imageAnalysisClaude
-	case "openai":
-		return imageAnalysisOpenai
-	default:
+	name := Config["IMAGE_ANALYSIS_PROVIDER"]
+	if _, ok := llmProviders[name]; !ok || name == "debug" {
 		// Default to a debug function that just returns the attachment ID
-		return func(attachmentId string) (string, error) {
+		return func(_ context.Context, attachmentId string) (string, error) {
 			return fmt.Sprintf("DEBUG: Image analysis requested for attachment %s", attachmentId), nil
 		}
 	}
+	provider, err := newLLMProvider(name, llmProviderOptions{Purpose: PurposeImageAnalysis})
+	if err != nil {
+		log.Fatalf("Failed to set up image analysis provider %s: %v", name, err)
+	}
+	return newImageAnalyzer(meter(provider, store))
+}
+
+func initChatProvider() LLMProvider {
+	// Set the chat provider based on the configured provider.
+	// Chat is optional, so if it isn't configured properly it's disabled rather than fatal.
+	provider, err := newLLMProvider(Config["CHAT_PROVIDER"], llmProviderOptions{Purpose: PurposeChat})
+	if err != nil {
+		log.Printf("Chat is disabled, failed to set up chat provider %s: %v", Config["CHAT_PROVIDER"], err)
+		return nil
+	}
+	return provider
 }
 
-func (ctx *AppContext) helpCommand() {
-	// Send a help message to the send channel
-	message := "Available commands:\n" +
-		"!help - Display this help message\n" +
-		"!imagine <text> - Generate an image (other options: !opine, !dream, !nightmare, !hallucinate, !trip)\n" +
-		"!summary <num_msgs|12h> - Generate a summary of last N messages, or last H hours\n" +
-		"!ask <question> - Ask a question\n"
-	ctx.MessagePoster(message, "")
+func initEmbedder(store Store) EmbeddingProvider {
+	// Embeddings are optional. Without them !ask sends the whole chat log instead.
+	name := Config["EMBEDDING_PROVIDER"]
+	if name == "" {
+		return nil
+	}
+	embedder, err := newEmbeddingProvider(name, llmProviderOptions{Model: Config["EMBEDDING_MODEL"]})
+	if err != nil {
+		log.Printf("Embeddings are disabled, failed to set up embedding provider %s: %v", name, err)
+		return nil
+	}
+	return meterEmbedder(embedder, store)
+}
+
+func initSummaryProvider() LLMProvider {
+	// Set the summary provider based on the configured provider
+	provider, err := newLLMProvider(Config["SUMMARY_PROVIDER"], llmProviderOptions{Purpose: PurposeSummary})
+	if err != nil {
+		log.Fatalf("Failed to set up summary provider %s: %v", Config["SUMMARY_PROVIDER"], err)
+	}
+	return provider
 }
 
 func (ctx *AppContext) processMessage(message string) {
-	// Start a new span
-	tracer := otel.Tracer("signal-bot")
-	tracerCtx, span := tracer.Start(ctx.TraceContext, "processMessage", trace.WithNewRoot())
-	defer span.End()
-	ctx.TraceContext = tracerCtx
 	// Process incoming messages from the WebSocket server
 	log.Println("Received message:", message)
-	// Get the message root
-	container, msgStruct, err := getMessageRoot(message)
+	msg, err := decodeSignalMessage([]byte(message))
 	if err != nil {
-		log.Println("Failed to get message root:", err)
+		log.Println("Failed to decode message:", err)
 		return
 	}
+	ctx.processSignalMessage(msg)
+}
+
+func (ctx *AppContext) processSignalMessage(msg *SignalMessage) {
+	// Start a new span. Each message gets its own root span and RequestContext,
+	// the shared AppContext is never modified here.
+	tracer := otel.Tracer("signal-bot")
+	tracerCtx, span := tracer.Start(ctx.TraceContext, "processMessage", trace.WithNewRoot())
+	defer span.End()
 
-	// If there is no message (for example, this is an emoji reaction), and there are no attachments return
-	_, attachmentsOk := msgStruct["attachments"]
-	if msgStruct["message"] == nil && !attachmentsOk {
+	// Read receipts tell us who has seen the bot's messages
+	envelope := msg.Envelope
+	if envelope.ReceiptMessage != nil {
+		ctx.processReceipt(envelope)
 		return
-	} else if msgStruct["message"] == nil && attachmentsOk {
-		// If there are attachments, but no message, set the message to "Attachment"
-		msgStruct["message"] = "Uploaded attachment"
 	}
 
-	// If the msgStruct does not contains the field groupInfo isn't a real message, return
-	if _, ok := msgStruct["groupInfo"]; !ok {
+	// Edits replace the text of a message we already have
+	if edit := envelope.Edit(); edit != nil {
+		ctx.processEdit(envelope, edit)
 		return
 	}
 
-	// Ensure groupInfo contains a groupId. if it does, call encodeGroupIdToBase64()
-	// Empty out the existing recipients and set it to the new value.
-	// Otherwise, return
-	ctx.Recipients = []string{}
-	if groupId, ok := msgStruct["groupInfo"].(map[string]interface{})["groupId"]; ok {
-		ctx.Recipients = append(ctx.Recipients, encodeGroupIdToBase64(groupId.(string)))
-	} else {
+	// Typing indicators and the like have no content
+	content := envelope.Content()
+	if content == nil {
 		return
 	}
 
-	// This is handy to pull out now, we use it later
-	sourceName := container["envelope"].(map[string]interface{})["sourceName"].(string)
-	msgBody := msgStruct["message"].(string)
-	mentions := getMentions(msgStruct)
+	// Reactions and deletes change a message we already have, rather than being new messages
+	if content.Reaction != nil {
+		ctx.processReaction(envelope, content)
+		return
+	}
+	if content.RemoteDelete != nil {
+		ctx.processRemoteDelete(envelope, content)
+		return
+	}
+
+	// If there is no message (for example, this is a sticker), and there are no attachments return
+	msgBody := content.Message
+	if msgBody == "" && len(content.Attachments) == 0 {
+		return
+	} else if msgBody == "" {
+		// If there are attachments, but no message, set the message to "Attachment"
+		msgBody = "Uploaded attachment"
+	}
+
+	// The raw groupId, or for direct messages the sender's conversation, is
+	// what we store in the database, and is used to scope every history lookup
+	// to the conversation the message came from.
+	groupId := conversationId(envelope, content)
+	if groupId == "" {
+		return
+	}
+
+	// Build the context for this request. Replies go back to the conversation the message came from.
+	req := &RequestContext{
+		GroupId:      groupId,
+		Conversation: groupId,
+		Recipient:    recipientFor(groupId),
+		SourceName:   envelope.Sender(),
+		SourceNumber: envelope.SourceNumber,
+		SourceUuid:   envelope.SourceUuid,
+		Timestamp:    envelope.Timestamp,
+		Mentions:     content.Mentions,
+		Message:      msgBody,
+		TraceContext: tracerCtx,
+	}
+
+	// Anyone who knows the bot's number can message it, but only members of
+	// its groups are answered
+	if isDirectConversation(groupId) && !isBotOwner(req) {
+		member, err := sharesGroup(tracerCtx, req)
+		if err != nil {
+			log.Println("Failed to check the sender of a direct message:", err)
+			return
+		}
+		if !member {
+			log.Println("Ignoring a direct message from someone who isn't in any of my groups")
+			return
+		}
+	}
 
 	// If the message contains attachments, fetch and process them.
-	imageData, err := ctx.getImageData(msgStruct)
+	storedBody := msgBody
+	imageData, err := ctx.getImageData(req, content)
 	if err != nil {
 		log.Println("Failed to get image data:", err)
 		return
 	} else if len(imageData) > 0 {
 		// If there is image data, append it to the message body
-		msgStruct["message"] = msgBody + "\n(Image data: " + strings.Join(imageData, "\n") + ")"
+		storedBody = msgBody + imageDataPrefix + strings.Join(imageData, "\n") + ")"
 	}
 
 	// Persist the message to the database
-	ctx.saveMessage(container, msgStruct, mentions)
-
-	// If the first word in the message starts with a !, it's a command.
-	// Take the first word and call the appropriate function with the rest of the message
-	// Otherwise, return
-	if strings.HasPrefix(msgBody, "!") {
-		words := strings.Fields(msgBody)
-		switch words[0] {
-		case "!help":
-			ctx.helpCommand()
-		case "!ping":
-			msgTime := container["envelope"].(map[string]interface{})["timestamp"].(float64)
-			nowTime := time.Now().UnixMilli()
-			elapsedMs := nowTime - int64(msgTime)
-			ctx.MessagePoster(fmt.Sprintf("Pong! Elapsed time: %d ms", elapsedMs), "")
-		case "!marco":
-			msgTime := container["envelope"].(map[string]interface{})["timestamp"].(float64)
-			nowTime := time.Now().UnixMilli()
-			elapsedMs := nowTime - int64(msgTime)
-			// Pick a random response from the list
-			responses := []string{"Polo!", "Polo! 🏊", "Tasty pollo! 🤽", "Polo? 🤽‍♂️", "....(polo) 🤽‍♀️", "Polloooooo! 🏊‍♂️", "POLO! 🏊‍♀️"}
-			ctx.MessagePoster(fmt.Sprintf("%s (%d ms)", responses[rand.IntN(len(responses))], elapsedMs), "")
-		case "!imagine", "!opine", "!dream", "!nightmare", "!hallucinate", "!trip":
-			// If words[1:] is empty, call help
-			if len(words) < 2 {
-				ctx.helpCommand()
-				return
-			} else {
-				ctx.imagineCommand(sourceName, strings.Join(words[1:], " "), words[0])
-			}
-		case "!summary":
-			// If no additional arguments were given, just call for the summary.
-			c := TimeCountCalculator{-1, -1}
-			starttime, count, err := c.calculateStarttimeAndCount(words)
-			if err != nil {
-				log.Println("Error parsing hours and count:", err)
-				return
-			}
-			ctx.summaryCommand(starttime, count, sourceName, "")
-		case "!ask":
-			// If words[1:] is empty, call help
-			if len(words) < 2 {
-				ctx.helpCommand()
-				return
-			} else {
-				prompt := strings.Join(words[1:], " ")
-				prompt = prompt + "\nTry to use the chat log to answer this question. If the answer is not provided in the chat log above,"
-				prompt = prompt + "ignore the chat log and provide the best answer you can. "
-				prompt = prompt + "Do not be overly verbose in your answers unless asked. Responses under 1000 chars are preferred."
-				ctx.summaryCommand(-1, -1, sourceName, prompt)
-			}
-		}
+	if err := ctx.saveMessage(req, storedBody, content.Mentions, content.Quote); err != nil {
+		log.Println("Failed to save message:", err)
+	}
+	defer ctx.markSeen(req)
+
+	// If the first word in the message is a registered command, run it.
+	// Commands are registered in the files which implement them, see commands.go.
+	if ctx.dispatchCommand(req, msgBody) {
+		return
 	}
 	// If the message is not a command, call chatCommand to handle the message
-	// ctx.chatCommand(sourceName, msgBody, mentions)
+	if err := ctx.chatCommand(req, msgBody, content.Mentions); err != nil {
+		log.Println("Chat failed:", err)
+	}
 }
 
 func (ctx *AppContext) debugger() {
 	// Start a debugger session
 	log.Println("Starting debugger session")
-	// A message template to help us test with. This isn't great, one day we should
-	// do this with proper types and structures, and then marshal it to JSON.
-	tpl := `{
-		"envelope":{
-			"source":"+123456789",
-			"sourceNumber":"+123456789",
-			"sourceUuid":"019063e8-9042-72ca-9b66-30a3c83d4489",
-			"sourceName":"Test User",
-			"sourceDevice":1,
-			"timestamp":%v,
-			"syncMessage":{
-				"sentMessage":{
-					"destination":null,
-					"destinationNumber":null,
-					"destinationUuid":null,
-					"timestamp":%v,
-					"message":%s,
-					"expiresInSeconds":604800,
-					"viewOnce":false,
-					"attachments":[
-						{
-							"contentType":"image/jpeg",
-							"filename":"Andromeda_realigned_tiltshift.jpg",
-							"id":"r4aFDRWmi_z2dfVh5iqC.jpg",
-							"size":273635,
-							"width":2048,
-							"height":2048,
-							"caption":null,
-							"uploadTimestamp":null
-						}
-					],
-					"groupInfo":{
-						"groupId":"VGVzdA==",
-						"type":"DELIVER"
-					}
-				}
-			}
-		},
-		"account":"+123456789"
-	}`
 	for {
-		// Start a new span for each message
-		tracer := otel.Tracer("signal-bot")
-		tracerCtx, span := tracer.Start(ctx.TraceContext, "debugger", trace.WithNewRoot())
-		defer span.End()
-		ctx.TraceContext = tracerCtx
-
 		// Prompt the user for a message
 		request := StringPrompt("Enter a message:")
 		if request == "" {
 			break
 		}
-		// Put the message in the template
-		timeNow := time.Now().Unix() * 1000
-		escapedMessage, _ := json.Marshal(request)
-		message := fmt.Sprintf(tpl, timeNow, timeNow, string(escapedMessage))
+		// Build a message as if it was synced from one of our own devices
+		timeNow := time.Now().UnixMilli()
+		msg := &SignalMessage{
+			Account: "+123456789",
+			Envelope: &Envelope{
+				Source:       "+123456789",
+				SourceNumber: "+123456789",
+				SourceUuid:   "019063e8-9042-72ca-9b66-30a3c83d4489",
+				SourceName:   "Test User",
+				SourceDevice: 1,
+				Timestamp:    timeNow,
+				SyncMessage: &SyncMessage{
+					SentMessage: &SentMessage{
+						DataMessage: DataMessage{
+							Timestamp:        timeNow,
+							Message:          request,
+							ExpiresInSeconds: 604800,
+							Attachments: []Attachment{
+								{
+									ContentType: "image/jpeg",
+									Filename:    "Andromeda_realigned_tiltshift.jpg",
+									Id:          "r4aFDRWmi_z2dfVh5iqC.jpg",
+									Size:        273635,
+									Width:       2048,
+									Height:      2048,
+								},
+							},
+							GroupInfo: &GroupInfo{
+								GroupId: "VGVzdA==",
+								Type:    "DELIVER",
+							},
+						},
+					},
+				},
+			},
+		}
 		// Process the message
-		ctx.processMessage(message)
+		ctx.processSignalMessage(msg)
 	}
 }
 
@@ -309,16 +343,13 @@ func (ctx *AppContext) websocketClient() (bool, error) {
 	// Start a goroutine to handle incoming messages
 	go func() {
 		for {
-			// Start a new span for each message
-			tracer := otel.Tracer("signal-bot")
-			_, span := tracer.Start(ctx.TraceContext, "websocketClient")
-			defer span.End()
 			_, message, err := conn.ReadMessage()
 			if err != nil {
 				log.Println("Failed to read message from WebSocket:", err)
 				return
 			}
-			// For each message start a goroutine to process it
+			// For each message start a goroutine to process it. processMessage
+			// starts a new span for each message.
 			go ctx.processMessage(string(message))
 		}
 	}()
@@ -327,31 +358,6 @@ func (ctx *AppContext) websocketClient() (bool, error) {
 	select {}
 }
 
-func restClient() {
-	// Fetch the latest messages from the REST API at
-	// http://{config.rest_url}/v1/receive/{config.phone}
-	// Print them to the console and then persist them to the database
-
-	url := fmt.Sprintf("%s/v1/receive/%s", Config["REST_URL"], Config["PHONE"])
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Println("Failed to make HTTP GET request:", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Failed to read response body:", err)
-		return
-	}
-
-	log.Println("Response:", string(body))
-
-	// Persist the messages to the database
-	// ...
-}
-
 func startupValidator() {
 	// In MAX_AGE is not an int, panic
 	if _, err := strconv.Atoi(Config["MAX_AGE"]); err != nil {
@@ -372,13 +378,50 @@ func startupValidator() {
 	if !filepath.IsAbs(Config["IMAGEDIR"]) {
 		log.Fatalf("IMAGEDIR must be an absolute path: %s", Config["IMAGEDIR"])
 	}
-	// If the database file doesn't exist, panic
-	if _, err := os.Stat(Config["STATEDB"]); os.IsNotExist(err) {
-		log.Fatalf("Database file does not exist: %s", Config["STATEDB"])
+	limits, err := parseRateLimits(Config["RATE_LIMITS"])
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMITS: %v", err)
+	}
+	configuredRateLimits = limits
+	for _, key := range []string{"GROUP_MONTHLY_BUDGET", "USER_MONTHLY_BUDGET"} {
+		if err := validateBudget(Config[key]); err != nil {
+			log.Fatalf("Invalid %s: %v", key, err)
+		}
+	}
+}
+
+func migrateStateDB(path string) error {
+	// Create or upgrade the database at path, for -migrate
+	if path == "" {
+		return errors.New("missing environment variable: STATEDB")
+	}
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		return fmt.Errorf("failed to open database %s: %w", path, err)
 	}
+	return store.Close()
 }
 
 func main() {
+	// Accept command line arguments with flag:
+	//   -mode: websocket or rest
+	//   -debug: enable debug logging
+	//   -migrate: apply database migrations and exit
+	mode := flag.String("mode", "websocket", "start mode: websocket or rest")
+	debugflag := flag.Bool("debug", false, "enable debug logging")
+	pprofFlag := flag.Bool("pprof", false, "enable pprof")
+	migrateFlag := flag.Bool("migrate", false, "apply database migrations and exit")
+	flag.Parse()
+
+	// Migrating only needs the database, so none of the other settings are checked
+	if *migrateFlag {
+		if err := migrateStateDB(Config["STATEDB"]); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Database is up to date:", Config["STATEDB"])
+		return
+	}
+
 	// Do some start-up validation
 	startupValidator()
 
@@ -387,13 +430,12 @@ func main() {
 	defer shutdown()
 	tracer := otel.Tracer("signal-bot")
 
-	// Accept command line arguments with flag:
-	//   -mode: websocket or rest
-	//   -debug: enable debug logging
-	mode := flag.String("mode", "websocket", "start mode: websocket or rest")
-	debugflag := flag.Bool("debug", false, "enable debug logging")
-	pprofFlag := flag.Bool("pprof", false, "enable pprof")
-	flag.Parse()
+	// Open the database, creating or upgrading it before anything tries to use it
+	store, err := openSQLiteStore(Config["STATEDB"])
+	if err != nil {
+		log.Fatalf("Failed to open database %s: %v", Config["STATEDB"], err)
+	}
+	defer store.Close()
 
 	// Enable pprof if -pprof was used, OR if PPROF_PORT is set
 	if *pprofFlag || Config["PPROF_PORT"] != "" {
@@ -411,29 +453,41 @@ func main() {
 	defer span.End()
 
 	ctx := AppContext{
-		DbQueryChan:        make(chan dbQuery),
-		DbReplySummaryChan: make(chan interface{}),
-		DbReplyAskChan:     make(chan interface{}),
-		Recipients:         []string{},
-		TraceContext:       traceCtx,
-		ImageAnalyzer:      initImageAnalyzer(),
+		Store:           store,
+		TraceContext:    traceCtx,
+		ImageAnalyzer:   initImageAnalyzer(store),
+		SummaryProvider: initSummaryProvider(),
+		ChatProvider:    initChatProvider(),
+		Embedder:        initEmbedder(store),
 	}
 
-	go ctx.dbWorker()
-
-	// Start the appropriate mode
-	switch *mode {
-	case "websocket":
+	// Both of the live modes clean up old messages and reply through the REST API
+	if *mode == "websocket" || *mode == "rest" {
 		// Start a goroutine that runs cleanup_state every hour
 		go func() {
 			for {
-				ctx.removeOldMessages()
+				ctx.removeOldMessages("")
 				time.Sleep(time.Hour)
 			}
 		}()
 
+		// Post scheduled digests
+		go ctx.runScheduler()
+
+		// Embed any messages which arrived before embeddings were enabled
+		if ctx.Embedder != nil {
+			go ctx.backfillEmbeddings()
+		}
+
 		// Set the message poster to the sendMessage function
 		ctx.MessagePoster = ctx.sendMessage
+		ctx.ReactionSender = ctx.sendReaction
+		ctx.TypingIndicator = ctx.sendTyping
+	}
+
+	// Start the appropriate mode
+	switch *mode {
+	case "websocket":
 		// Start the WebSocket client. Retry every 3 seconds on failure.
 		for {
 			_, err := ctx.websocketClient()
@@ -443,7 +497,10 @@ func main() {
 			}
 		}
 	case "rest":
-		restClient()
+		// Poll until we're asked to stop, then let messages in flight finish
+		runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		ctx.restClient(runCtx)
 	case "debugger":
 		ctx.MessagePoster = Printer
 		ctx.debugger()
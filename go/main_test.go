@@ -2,8 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStartupValidator(t *testing.T) {
@@ -38,22 +43,79 @@ func TestStartupValidator(t *testing.T) {
 func TestHelpCommand(t *testing.T) {
 	ctx := &AppContext{}
 	expectedMessage := "Available commands:\n" +
-		"!help - Display this help message\n" +
+		"!admin list | add <member> | remove <member> | permit <command> <everyone|admins|owners|default|member...> - Manage this group's admins and who can use each command\n" +
+		"!ask [time range] <question> - Ask a question\n" +
+		"!catchup [dm] - Summarize what you missed since you were last here\n" +
+		"!config get [setting] | set <setting> <value> | unset <setting> - Show or change this group's settings\n" +
+		"!help [command] - Display this help message, or the help for a command\n" +
 		"!imagine <text> - Generate an image (other options: !opine, !dream, !nightmare, !hallucinate, !trip)\n" +
-		"!summary <num_msgs|12h> - Generate a summary of last N messages, or last H hours\n" +
-		"!ask <question> - Ask a question\n"
+		"!marco - Polo!\n" +
+		"!ping - Check the bot is alive and how long messages take to reach it\n" +
+		"!schedule add <cron> [num_msgs|time range] | list | remove <number> - Post summaries to the group automatically, eg. !schedule add 0 8 * * * at 08:00 every day\n" +
+		"!search <terms> [from:name] [since:3d] - Search this group's messages\n" +
+		"!summary <num_msgs|time range|since-me> [dm] - Generate a summary of the last N messages, or a time range\n" +
+		"!usage - Show how much this group and you have spent on AI this month\n" +
+		"Use !help <command> for more details\n"
 
 	// Redirect the output of the function to a buffer
 	var buf bytes.Buffer
-	ctx.MessagePoster = func(message, _ string) {
+	ctx.MessagePoster = func(_ *RequestContext, message, _ string) {
 		buf.WriteString(message)
 	}
 
 	// Call the helpCommand function
-	ctx.helpCommand()
+	ctx.helpCommand(&RequestContext{}, nil)
 
 	// Check if the output matches the expected message
 	if buf.String() != expectedMessage {
 		t.Errorf("Unexpected help message. Expected: %q, Got: %q", expectedMessage, buf.String())
 	}
 }
+
+func TestProcessMessageRepliesToOriginatingGroup(t *testing.T) {
+	tpl := `{"envelope":{"sourceName":"Test User","sourceNumber":"+123456789","timestamp":%d,` +
+		`"dataMessage":{"message":"!ping","groupInfo":{"groupId":"%s"}}}}`
+
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+
+	var mu sync.Mutex
+	replies := map[string]string{}
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		mu.Lock()
+		defer mu.Unlock()
+		replies[req.GroupId] = req.Recipient
+	}
+
+	// Process messages from many groups at once
+	var wg sync.WaitGroup
+	groups := []string{"groupOne", "groupTwo", "groupThree", "groupFour"}
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group string) {
+			defer wg.Done()
+			ctx.processMessage(fmt.Sprintf(tpl, time.Now().UnixMilli(), group))
+		}(group)
+	}
+	wg.Wait()
+
+	for _, group := range groups {
+		if replies[group] != encodeGroupIdToBase64(group) {
+			t.Errorf("reply for %s was sent to %q", group, replies[group])
+		}
+	}
+}
+
+func TestMigrateStateDB(t *testing.T) {
+	// Migrating needs nothing but the database, not the settings for running the bot
+	Config = map[string]string{}
+	path := filepath.Join(t.TempDir(), "state.db")
+	if err := migrateStateDB(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the database to be created: %v", err)
+	}
+	if err := migrateStateDB(""); err == nil {
+		t.Errorf("expected an error without STATEDB")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations live in migrations/ and are named NNNN_description.sql. They're
+// applied in order of their number, each in its own transaction, and the
+// versions which have been applied are tracked in the schema_migrations table.
+// Never edit a migration once it's been released, add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	seen := map[int]string{}
+	for _, entry := range entries {
+		// Split NNNN_description.sql into its version and name
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		number, _, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description.sql", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(data)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+func migrateDatabase(db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS `schema_migrations` (" +
+		"`version` integer not null primary key, " +
+		"`name` TEXT not null, " +
+		"`applied_at` datetime not null default CURRENT_TIMESTAMP)")
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	if err := baselineLegacySchema(db); err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		log.Println("Applying database migration", m.name)
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+func appliedMigrations(db *sql.DB) (map[int]bool, error) {
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func baselineLegacySchema(db *sql.DB) error {
+	// Databases created before migrations existed have a messages table but
+	// nothing in schema_migrations. Work out which migrations they already
+	// match so we don't try to create the same table or columns twice.
+	var tracked int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&tracked); err != nil {
+		return err
+	}
+	if tracked > 0 {
+		return nil
+	}
+	columns := map[string]bool{}
+	rows, err := db.Query("SELECT name FROM pragma_table_info('messages')")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	if len(columns) == 0 {
+		return nil
+	}
+
+	// messages.sql from the python bot
+	baseline := []migration{{version: 1, name: "0001_create_messages"}}
+	// The columns added for outgoing messages, before they were a migration
+	if columns["attachments"] {
+		baseline = append(baseline, migration{version: 2, name: "0002_outgoing_messages"})
+	}
+	for _, m := range baseline {
+		log.Println("Marking existing database as migrated to", m.name)
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
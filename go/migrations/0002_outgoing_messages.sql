@@ -0,0 +1,4 @@
+-- Store the bot's own messages along with the message which triggered them
+ALTER TABLE `messages` ADD COLUMN `attachments` TEXT;
+ALTER TABLE `messages` ADD COLUMN `replyToTimestamp` UNSIGNED BIG INT null;
+ALTER TABLE `messages` ADD COLUMN `replyToAuthor` TEXT;
@@ -0,0 +1,9 @@
+-- Vectors for retrieving messages by meaning, see embeddings.go.
+-- Each message has at most one, made by the model named alongside it.
+CREATE TABLE IF NOT EXISTS `message_embeddings` (
+  `message_id` integer not null primary key,
+  `model` TEXT not null,
+  `vector` BLOB not null);
+CREATE TRIGGER IF NOT EXISTS `messages_embeddings_delete` AFTER DELETE ON `messages` BEGIN
+    DELETE FROM `message_embeddings` WHERE `message_id` = old.id;
+END;
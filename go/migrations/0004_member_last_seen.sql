@@ -0,0 +1,7 @@
+-- The last time each member was seen in each group, from the messages they
+-- sent and the read receipts for the bot's messages. Used by !catchup.
+CREATE TABLE IF NOT EXISTS `member_last_seen` (
+  `groupId` TEXT not null,
+  `member` TEXT not null,
+  `timestamp` UNSIGNED BIG INT not null,
+  primary key (`groupId`, `member`));
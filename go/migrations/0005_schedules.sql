@@ -0,0 +1,12 @@
+-- Digests posted to a group automatically, managed with !schedule.
+-- spec is a cron expression, evaluated in the group's time zone, and args
+-- are the !summary arguments used for each digest.
+CREATE TABLE IF NOT EXISTS `schedules` (
+  `id` INTEGER primary key autoincrement,
+  `groupId` TEXT not null,
+  `spec` TEXT not null,
+  `args` TEXT not null default '',
+  `createdBy` TEXT,
+  `createdAt` UNSIGNED BIG INT not null,
+  `lastRun` UNSIGNED BIG INT not null default 0);
+CREATE INDEX IF NOT EXISTS `schedules_groupId` ON `schedules` (`groupId`);
@@ -0,0 +1,8 @@
+-- Settings chosen by each group with !config, overriding the global config
+CREATE TABLE IF NOT EXISTS `group_settings` (
+  `groupId` TEXT not null,
+  `key` TEXT not null,
+  `value` TEXT not null,
+  `updatedBy` TEXT,
+  `updatedAt` UNSIGNED BIG INT not null,
+  primary key (`groupId`, `key`));
@@ -0,0 +1,21 @@
+-- Each group's admins, who can run the commands limited to admins.
+-- member is the member's UUID, or their phone number if that's all we know.
+CREATE TABLE IF NOT EXISTS `group_admins` (
+  `groupId` TEXT not null,
+  `member` TEXT not null,
+  `name` TEXT,
+  `addedBy` TEXT,
+  `addedAt` UNSIGNED BIG INT not null,
+  primary key (`groupId`, `member`));
+
+-- Who can run each command in each group, replacing the command's default.
+-- level is everyone, admins, owners or members, and members is a JSON array of
+-- the members allowed when level is members.
+CREATE TABLE IF NOT EXISTS `command_permissions` (
+  `groupId` TEXT not null,
+  `command` TEXT not null,
+  `level` TEXT not null,
+  `members` TEXT not null default '[]',
+  `updatedBy` TEXT,
+  `updatedAt` UNSIGNED BIG INT not null,
+  primary key (`groupId`, `command`));
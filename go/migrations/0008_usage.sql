@@ -0,0 +1,16 @@
+-- Every request made to a paid provider, for rate limits, budgets and !usage.
+-- member is who asked, by UUID or phone number, and cost is in US dollars.
+CREATE TABLE IF NOT EXISTS `usage` (
+  `id` INTEGER primary key autoincrement,
+  `timestamp` UNSIGNED BIG INT not null,
+  `groupId` TEXT not null,
+  `member` TEXT not null,
+  `command` TEXT not null,
+  `provider` TEXT not null,
+  `model` TEXT,
+  `inputTokens` INTEGER not null default 0,
+  `outputTokens` INTEGER not null default 0,
+  `images` INTEGER not null default 0,
+  `cost` REAL not null default 0);
+CREATE INDEX IF NOT EXISTS `usage_group_timestamp` ON `usage` (`groupId`, `timestamp`);
+CREATE INDEX IF NOT EXISTS `usage_member_timestamp` ON `usage` (`member`, `timestamp`);
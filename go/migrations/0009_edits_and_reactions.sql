@@ -0,0 +1,27 @@
+-- Edits and reactions, see history.go. Remotely deleted messages are removed
+-- from messages altogether, taking their revisions and reactions with them.
+-- sourceUuid identifies the author of the message an edit or reaction is for.
+ALTER TABLE `messages` ADD COLUMN `sourceUuid` TEXT;
+ALTER TABLE `messages` ADD COLUMN `editedAt` UNSIGNED BIG INT null;
+
+-- The earlier text of edited messages. The current text is kept in messages.
+CREATE TABLE IF NOT EXISTS `message_revisions` (
+  `id` INTEGER primary key autoincrement,
+  `messageId` integer not null,
+  `message` TEXT not null,
+  `replacedAt` UNSIGNED BIG INT not null);
+CREATE INDEX IF NOT EXISTS `message_revisions_messageId` ON `message_revisions` (`messageId`);
+
+-- Emoji reactions to messages. Each member has at most one on a message.
+CREATE TABLE IF NOT EXISTS `message_reactions` (
+  `messageId` integer not null,
+  `member` TEXT not null,
+  `name` TEXT,
+  `emoji` TEXT not null,
+  `timestamp` UNSIGNED BIG INT not null,
+  primary key (`messageId`, `member`));
+
+CREATE TRIGGER IF NOT EXISTS `messages_history_delete` AFTER DELETE ON `messages` BEGIN
+    DELETE FROM `message_revisions` WHERE `messageId` = old.id;
+    DELETE FROM `message_reactions` WHERE `messageId` = old.id;
+END;
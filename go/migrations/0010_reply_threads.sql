@@ -0,0 +1,11 @@
+-- Quote replies, see threads.go. parentId is the message being replied to, if
+-- we have it. quoteAuthor and quoteText are what the reply quoted, so a reply
+-- still makes sense once the message it quoted has been pruned or deleted.
+ALTER TABLE `messages` ADD COLUMN `parentId` integer null;
+ALTER TABLE `messages` ADD COLUMN `quoteAuthor` TEXT;
+ALTER TABLE `messages` ADD COLUMN `quoteText` TEXT;
+CREATE INDEX IF NOT EXISTS `messages_parentId` ON `messages` (`parentId`);
+
+CREATE TRIGGER IF NOT EXISTS `messages_thread_delete` AFTER DELETE ON `messages` BEGIN
+    UPDATE `messages` SET `parentId` = NULL WHERE `parentId` = old.id;
+END;
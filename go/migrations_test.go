@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("expected at least one migration")
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("expected migration %d to be version %d, got %s", i, i+1, m.name)
+		}
+	}
+}
+
+func TestRunMigrationsCreatesDatabase(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_migrations")
+	if err != nil {
+		t.Fatal("Failed to create temporary dir")
+	}
+	defer os.RemoveAll(dir)
+	dbPath := dir + "/messages.db"
+
+	// Running twice must be a no-op the second time
+	for i := 0; i < 2; i++ {
+		store, err := openSQLiteStore(dbPath)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		store.Close()
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+	migrations, _ := loadMigrations()
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("expected %d applied migrations, got %d", len(migrations), applied)
+	}
+	_, err = db.Exec("INSERT INTO messages (timestamp, sourceName, message, groupId, replyToTimestamp) VALUES (1, 'Alice', 'hello', 'groupOne', 0)")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbFile, err := os.CreateTemp("", "test_*.db")
+	if err != nil {
+		t.Fatal("Failed to create temporary db file")
+	}
+	defer os.Remove(dbFile.Name())
+	db, err := sql.Open("sqlite3", dbFile.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.Close()
+
+	// A database created by python/messages.sql, with a message in it
+	_, err = db.Exec("CREATE TABLE `messages` (`id` integer not null primary key autoincrement, " +
+		"`timestamp` UNSIGNED BIG INT null, `sourceNumber` TEXT null, `sourceName` TEXT not null, " +
+		"`message` TEXT not null, `groupId` TEXT not null, `mentions` TEXT, " +
+		"`created_at` datetime not null default CURRENT_TIMESTAMP)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO messages (timestamp, sourceName, message, groupId) VALUES (1, 'Alice', 'hello', 'groupOne')")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := migrateDatabase(db); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var message string
+	var attachments sql.NullString
+	err = db.QueryRow("SELECT message, attachments FROM messages").Scan(&message, &attachments)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if message != "hello" || attachments.Valid {
+		t.Errorf("expected the existing message to be kept, got %q %v", message, attachments)
+	}
+}
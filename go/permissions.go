@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PermissionLevel is who can run a command
+type PermissionLevel string
+
+const (
+	PermissionEveryone PermissionLevel = "everyone"
+	PermissionAdmins   PermissionLevel = "admins"  // The group's admins and the bot's owners
+	PermissionOwners   PermissionLevel = "owners"  // Only the bot's owners, from BOT_OWNERS
+	PermissionMembers  PermissionLevel = "members" // The members on the allow-list, plus the admins
+)
+
+// GroupMember identifies someone in a group
+type GroupMember struct {
+	Id   string `json:"id"` // Their UUID, or their phone number if that's all we know
+	Name string `json:"name,omitempty"`
+}
+
+// CommandPermission is who a group lets run a command
+type CommandPermission struct {
+	Level   PermissionLevel
+	Members []GroupMember // Who is allowed, when Level is PermissionMembers
+}
+
+// fixedPermissionCommands can't have their permissions changed, so admins
+// can't be locked out and everyone else can't make themselves admins
+var fixedPermissionCommands = map[string]bool{"admin": true, "help": true}
+
+// botOwners returns the phone numbers and UUIDs in BOT_OWNERS
+func botOwners() []string {
+	return strings.FieldsFunc(Config["BOT_OWNERS"], func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+func isBotOwner(req *RequestContext) bool {
+	for _, owner := range botOwners() {
+		if isMember(req, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMember reports whether the sender of the request is the member with id
+func isMember(req *RequestContext, id string) bool {
+	return id != "" && (id == req.SourceUuid || id == req.SourceNumber)
+}
+
+func (ctx *AppContext) isGroupAdmin(traceCtx context.Context, req *RequestContext) (bool, error) {
+	// Nobody is in charge of a direct conversation but the bot's owners
+	if isBotOwner(req) {
+		return true, nil
+	}
+	if isDirectConversation(req.GroupId) {
+		return false, nil
+	}
+	admins, err := ctx.Store.FetchGroupAdmins(traceCtx, req.GroupId)
+	if err != nil {
+		return false, err
+	}
+	// Until someone is put in charge, everyone is. Otherwise setting up a new
+	// group, or upgrading the bot, would lock everyone out of it.
+	if len(admins) == 0 && len(botOwners()) == 0 {
+		return true, nil
+	}
+	for _, admin := range admins {
+		if isMember(req, admin.Id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ctx *AppContext) commandPermission(traceCtx context.Context, groupId string, cmd *Command) (CommandPermission, error) {
+	// The group's permission for the command, or the command's default
+	permission := CommandPermission{Level: cmd.Permission}
+	if permission.Level == "" {
+		permission.Level = PermissionEveryone
+	}
+	if groupId == "" || fixedPermissionCommands[cmd.Name] {
+		return permission, nil
+	}
+	permissions, err := ctx.Store.FetchCommandPermissions(traceCtx, groupId)
+	if err != nil {
+		return permission, err
+	}
+	if groupPermission, ok := permissions[cmd.Name]; ok {
+		return groupPermission, nil
+	}
+	return permission, nil
+}
+
+// checkPermission returns an empty string if the sender can run the command,
+// otherwise the reason they can't
+func (ctx *AppContext) checkPermission(req *RequestContext, cmd *Command) string {
+	permission, err := ctx.commandPermission(req.TraceContext, req.GroupId, cmd)
+	if err != nil {
+		// Don't let a database problem open up commands which are meant to be limited
+		log.Println("Failed to fetch command permissions:", err)
+		return "Sorry, I couldn't check who's allowed to use !" + cmd.Name + ". Please try again later."
+	}
+	if permission.Level == PermissionEveryone || isBotOwner(req) {
+		return ""
+	}
+	if permission.Level == PermissionOwners {
+		return "Sorry, only the bot's owners can use !" + cmd.Name + "."
+	}
+	// Direct conversations have no admins, and no group to restrict commands in
+	if isDirectConversation(req.GroupId) {
+		return "Sorry, only the bot's owners can use !" + cmd.Name + " in a direct message."
+	}
+
+	admin, err := ctx.isGroupAdmin(req.TraceContext, req)
+	if err != nil {
+		log.Println("Failed to fetch group admins:", err)
+		return "Sorry, I couldn't check who's allowed to use !" + cmd.Name + ". Please try again later."
+	}
+	if admin {
+		return ""
+	}
+	if permission.Level == PermissionMembers {
+		for _, member := range permission.Members {
+			if isMember(req, member.Id) {
+				return ""
+			}
+		}
+		return "Sorry, you're not on the list of people who can use !" + cmd.Name + " in this group. An admin can add you."
+	}
+	return "Sorry, only this group's admins can use !" + cmd.Name + "."
+}
+
+// mentionPlaceholder is what Signal puts in the message text where someone was @mentioned
+const mentionPlaceholder = "\uFFFC"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+var phoneNumberPattern = regexp.MustCompile(`^\+[0-9]{6,15}$`)
+
+func resolveMembers(req *RequestContext, words []string) ([]GroupMember, error) {
+	// Turn @mentions, phone numbers and UUIDs into members. Mentions are in
+	// the same order in the message as in req.Mentions.
+	var members []GroupMember
+	mentions := append([]Mention(nil), req.Mentions...)
+	sort.Slice(mentions, func(i, j int) bool { return mentions[i].Start < mentions[j].Start })
+	for _, word := range words {
+		switch {
+		case strings.Contains(word, mentionPlaceholder):
+			if len(mentions) == 0 {
+				return nil, fmt.Errorf("I couldn't work out who you mentioned")
+			}
+			mention := mentions[0]
+			mentions = mentions[1:]
+			members = append(members, GroupMember{Id: memberId(mention.Uuid, mention.Number), Name: mention.Name})
+		case phoneNumberPattern.MatchString(word), uuidPattern.MatchString(word):
+			members = append(members, GroupMember{Id: word})
+		default:
+			return nil, fmt.Errorf("I don't know who %s is, @mention them or use their phone number", word)
+		}
+	}
+	return members, nil
+}
+
+func describeMember(member GroupMember) string {
+	if member.Name == "" {
+		return member.Id
+	}
+	return member.Name
+}
+
+// adminArgs are the parsed arguments to !admin
+type adminArgs struct {
+	Action  string   // list, add, remove or permit
+	Command string   // The command whose permission is changed, for permit
+	Level   string   // The new level for permit, or default
+	Members []string // The words naming members, resolved by the handler
+}
+
+func parseAdminArgs(name string, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return adminArgs{Action: "list"}, nil
+	}
+	parsed := adminArgs{Action: strings.ToLower(args[0])}
+	switch parsed.Action {
+	case "list":
+		return parsed, nil
+	case "add", "remove":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("who should be %s? @mention them or use their phone number", map[string]string{"add": "added", "remove": "removed"}[parsed.Action])
+		}
+		parsed.Members = args[1:]
+		return parsed, nil
+	case "permit":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("which command, and who can use it? eg. !admin permit imagine admins")
+		}
+		cmd, ok := Commands.Lookup(args[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown command %s", args[1])
+		}
+		if fixedPermissionCommands[cmd.Name] {
+			return nil, fmt.Errorf("who can use !%s can't be changed", cmd.Name)
+		}
+		parsed.Command = cmd.Name
+		switch level := strings.ToLower(args[2]); PermissionLevel(level) {
+		case PermissionEveryone, PermissionAdmins, PermissionOwners, "default":
+			if len(args) > 3 {
+				return nil, fmt.Errorf("%s can't be combined with anyone else", level)
+			}
+			parsed.Level = level
+		default:
+			parsed.Level = string(PermissionMembers)
+			parsed.Members = args[2:]
+		}
+		return parsed, nil
+	}
+	return nil, fmt.Errorf("unknown action %s", args[0])
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "admin",
+		Usage:       "list | add <member> | remove <member> | permit <command> <everyone|admins|owners|default|member...>",
+		Description: "Manage this group's admins and who can use each command",
+		Permission:  PermissionAdmins,
+		ParseArgs:   parseAdminArgs,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.adminCommand(req, args.(adminArgs))
+		},
+	})
+}
+
+func (ctx *AppContext) adminCommand(req *RequestContext, args adminArgs) error {
+	members, err := resolveMembers(req, args.Members)
+	if err != nil {
+		ctx.MessagePoster(req, err.Error(), "")
+		return nil
+	}
+	addedBy := memberId(req.SourceUuid, req.SourceNumber)
+
+	switch args.Action {
+	case "list":
+		return ctx.listAdmins(req)
+	case "add":
+		var names []string
+		for _, member := range members {
+			if err := ctx.Store.AddGroupAdmin(req.TraceContext, req.GroupId, member, addedBy); err != nil {
+				ctx.MessagePoster(req, "Sorry, I couldn't add the admin: "+err.Error(), "")
+				return err
+			}
+			names = append(names, describeMember(member))
+		}
+		ctx.MessagePoster(req, strings.Join(names, ", ")+" can now manage the bot in this group.", "")
+	case "remove":
+		var names []string
+		for _, member := range members {
+			removed, err := ctx.Store.RemoveGroupAdmin(req.TraceContext, req.GroupId, member.Id)
+			if err != nil {
+				ctx.MessagePoster(req, "Sorry, I couldn't remove the admin: "+err.Error(), "")
+				return err
+			}
+			if removed {
+				names = append(names, describeMember(member))
+			}
+		}
+		if len(names) == 0 {
+			ctx.MessagePoster(req, "They weren't admins in this group.", "")
+			return nil
+		}
+		ctx.MessagePoster(req, strings.Join(names, ", ")+" can no longer manage the bot in this group.", "")
+	case "permit":
+		permission := CommandPermission{Level: PermissionLevel(args.Level), Members: members}
+		if args.Level == "default" {
+			permission = CommandPermission{}
+		}
+		if err := ctx.Store.SetCommandPermission(req.TraceContext, req.GroupId, args.Command, permission, addedBy); err != nil {
+			ctx.MessagePoster(req, "Sorry, I couldn't save the permission: "+err.Error(), "")
+			return err
+		}
+		cmd, _ := Commands.Lookup(args.Command)
+		permission, err = ctx.commandPermission(req.TraceContext, req.GroupId, cmd)
+		if err != nil {
+			return err
+		}
+		ctx.MessagePoster(req, fmt.Sprintf("!%s can now be used by %s.", args.Command, describePermission(permission)), "")
+	}
+	return nil
+}
+
+func (ctx *AppContext) listAdmins(req *RequestContext) error {
+	admins, err := ctx.Store.FetchGroupAdmins(req.TraceContext, req.GroupId)
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't fetch the admins: "+err.Error(), "")
+		return err
+	}
+	permissions, err := ctx.Store.FetchCommandPermissions(req.TraceContext, req.GroupId)
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't fetch the permissions: "+err.Error(), "")
+		return err
+	}
+
+	var message strings.Builder
+	if len(admins) == 0 {
+		message.WriteString("This group has no admins.")
+		if len(botOwners()) == 0 {
+			message.WriteString(" Until someone is made an admin, everyone can manage the bot.")
+		}
+		message.WriteString("\n")
+	} else {
+		var names []string
+		for _, admin := range admins {
+			names = append(names, describeMember(admin))
+		}
+		fmt.Fprintf(&message, "Admins: %s\n", strings.Join(names, ", "))
+	}
+	for _, cmd := range Commands.List() {
+		permission, ok := permissions[cmd.Name]
+		if !ok && cmd.Permission == "" {
+			continue
+		}
+		if !ok {
+			permission = CommandPermission{Level: cmd.Permission}
+		}
+		fmt.Fprintf(&message, "!%s: %s\n", cmd.Name, describePermission(permission))
+	}
+	ctx.MessagePoster(req, message.String(), "")
+	return nil
+}
+
+func describePermission(permission CommandPermission) string {
+	switch permission.Level {
+	case PermissionAdmins:
+		return "admins"
+	case PermissionOwners:
+		return "the bot's owners"
+	case PermissionMembers:
+		var names []string
+		for _, member := range permission.Members {
+			names = append(names, describeMember(member))
+		}
+		return strings.Join(names, ", ") + " and admins"
+	}
+	return "everyone"
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseAdminArgs(t *testing.T) {
+	args, err := parseAdminArgs("admin", nil)
+	if err != nil || args.(adminArgs).Action != "list" {
+		t.Errorf("expected list with no arguments, got %+v, %v", args, err)
+	}
+
+	args, err = parseAdminArgs("admin", strings.Fields("permit dream +15551234 \uFFFC"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(adminArgs); parsed.Command != "imagine" || parsed.Level != "members" || len(parsed.Members) != 2 {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	args, err = parseAdminArgs("admin", strings.Fields("permit summary Admins"))
+	if err != nil || args.(adminArgs).Level != "admins" {
+		t.Errorf("expected admins, got %+v, %v", args, err)
+	}
+
+	for _, text := range []string{"add", "remove", "permit", "permit summary", "permit nosuchcommand admins",
+		"permit admin everyone", "permit help admins", "permit summary admins +15551234", "promote +15551234"} {
+		if _, err := parseAdminArgs("admin", strings.Fields(text)); err == nil {
+			t.Errorf("parseAdminArgs(%q): expected an error", text)
+		}
+	}
+}
+
+func TestResolveMembers(t *testing.T) {
+	req := &RequestContext{Mentions: []Mention{
+		{Name: "Carol", Uuid: "c-uuid", Start: 12},
+		{Name: "Bob", Number: "+2222", Start: 10},
+	}}
+	members, err := resolveMembers(req, []string{"\uFFFC", "+15551234", "\uFFFC"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []GroupMember{{Id: "+2222", Name: "Bob"}, {Id: "+15551234"}, {Id: "c-uuid", Name: "Carol"}}
+	if len(members) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, members)
+	}
+	for i := range expected {
+		if members[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], members[i])
+		}
+	}
+
+	for _, words := range [][]string{{"Bob"}, {"\uFFFC", "\uFFFC", "\uFFFC"}} {
+		if _, err := resolveMembers(req, words); err == nil {
+			t.Errorf("resolveMembers(%q): expected an error", words)
+		}
+	}
+}
+
+func TestCommandPermissions(t *testing.T) {
+	Config = map[string]string{"SUMMARY_PROVIDER": "debug", "BOT_OWNERS": "+15550001000, owner-uuid"}
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	member := func(name, number string) *RequestContext {
+		return &RequestContext{GroupId: "groupOne", SourceName: name, SourceNumber: number, SourceUuid: name + "-uuid", TraceContext: context.Background()}
+	}
+	owner, alice, bob := member("owner", ""), member("Alice", "+15550001111"), member("Bob", "+15550002222")
+
+	// Only owners and admins can manage the bot
+	ctx.dispatchCommand(alice, "!config set language French")
+	ctx.dispatchCommand(owner, "!admin add +15550001111")
+	ctx.dispatchCommand(alice, "!config set language French")
+	ctx.dispatchCommand(bob, "!admin add +15550002222")
+	expected := []string{
+		"Sorry, only this group's admins can use !config.",
+		"+15550001111 can now manage the bot in this group.",
+		"language is now French",
+		"Sorry, only this group's admins can use !admin.",
+	}
+	if strings.Join(replies, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, got %q", expected, replies)
+	}
+
+	// Commands can be limited to an allow-list, or to the owners
+	replies = nil
+	ctx.dispatchCommand(alice, "!admin permit ping +15550002222")
+	ctx.dispatchCommand(bob, "!ping")
+	ctx.dispatchCommand(member("Carol", "+15550003333"), "!ping")
+	ctx.dispatchCommand(alice, "!admin permit marco owners")
+	ctx.dispatchCommand(alice, "!marco")
+	ctx.dispatchCommand(member("x", "+15550001000"), "!marco")
+	if len(replies) != 6 || replies[0] != "!ping can now be used by +15550002222 and admins." || !strings.HasPrefix(replies[1], "Pong!") ||
+		!strings.HasPrefix(replies[2], "Sorry, you're not on the list") || replies[4] != "Sorry, only the bot's owners can use !marco." ||
+		strings.HasPrefix(replies[5], "Sorry") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+
+	// Going back to the default, and listing what's been changed
+	replies = nil
+	ctx.dispatchCommand(alice, "!admin permit ping default")
+	ctx.dispatchCommand(alice, "!admin")
+	if len(replies) != 2 || replies[0] != "!ping can now be used by everyone." {
+		t.Fatalf("unexpected replies %q", replies)
+	}
+	for _, line := range []string{"Admins: +15550001111\n", "!config: admins\n", "!marco: the bot's owners\n"} {
+		if !strings.Contains(replies[1], line) {
+			t.Errorf("expected the list to contain %q, got %q", line, replies[1])
+		}
+	}
+	if strings.Contains(replies[1], "!ping") {
+		t.Errorf("expected !ping not to be listed, got %q", replies[1])
+	}
+}
+
+func TestEveryoneIsAdminUntilSomeoneIs(t *testing.T) {
+	Config = map[string]string{}
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	alice := &RequestContext{GroupId: "groupOne", SourceUuid: "alice-uuid", TraceContext: context.Background()}
+	bob := &RequestContext{GroupId: "groupOne", SourceUuid: "bob-uuid", TraceContext: context.Background()}
+
+	ctx.dispatchCommand(bob, "!admin add alice-uuid")
+	ctx.dispatchCommand(bob, "!admin add 01234567-89ab-cdef-0123-456789abcdef")
+	ctx.dispatchCommand(bob, "!admin remove 01234567-89ab-cdef-0123-456789abcdef")
+	if len(replies) != 3 || !strings.HasPrefix(replies[0], "I don't know who alice-uuid is") ||
+		!strings.HasSuffix(replies[1], "can now manage the bot in this group.") ||
+		replies[2] != "Sorry, only this group's admins can use !admin." {
+		t.Errorf("unexpected replies %q", replies)
+	}
+	if admin, _ := ctx.isGroupAdmin(context.Background(), alice); admin {
+		t.Errorf("expected Alice not to be an admin once the group has one")
+	}
+}
+
+func TestRestrictedCommandsInDirectMessages(t *testing.T) {
+	Config = map[string]string{"BOT_OWNERS": "owner-uuid"}
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	direct := func(uuid string) *RequestContext {
+		return &RequestContext{GroupId: directPrefix + uuid, SourceUuid: uuid, TraceContext: context.Background()}
+	}
+
+	// Nobody is an admin of their own direct messages, so only the owners
+	// can use commands which aren't open to everyone
+	if admin, _ := ctx.isGroupAdmin(context.Background(), direct("alice-uuid")); admin {
+		t.Errorf("expected Alice not to be an admin of a direct conversation")
+	}
+	ctx.dispatchCommand(direct("alice-uuid"), "!config set language French")
+	ctx.dispatchCommand(direct("alice-uuid"), "!ping")
+	ctx.dispatchCommand(direct("owner-uuid"), "!config set language French")
+	if len(replies) != 3 || replies[0] != "Sorry, only the bot's owners can use !config in a direct message." ||
+		!strings.HasPrefix(replies[1], "Pong!") || replies[2] != "language is now French" {
+		t.Errorf("unexpected replies %q", replies)
+	}
+}
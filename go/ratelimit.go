@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit allows Count requests every Per. A Count of zero means no limit.
+type rateLimit struct {
+	Count int
+	Per   time.Duration
+}
+
+// rateLimits are the limits on one command, for each member and for each group
+type rateLimits struct {
+	User  rateLimit
+	Group rateLimit
+}
+
+// defaultRateLimits are the limits on everything which calls a paid provider.
+// chat and image_analysis aren't commands, but are limited the same way.
+// RATE_LIMITS changes these, eg. "imagine.user=3/1h,imagine.group=10/1h,chat.user=off".
+var defaultRateLimits = map[string]rateLimits{
+	"ask":            {User: rateLimit{10, time.Hour}, Group: rateLimit{30, time.Hour}},
+	"catchup":        {User: rateLimit{5, time.Hour}, Group: rateLimit{30, time.Hour}},
+	"chat":           {User: rateLimit{30, time.Hour}, Group: rateLimit{120, time.Hour}},
+	"image_analysis": {User: rateLimit{20, time.Hour}, Group: rateLimit{60, time.Hour}},
+	"imagine":        {User: rateLimit{5, time.Hour}, Group: rateLimit{20, time.Hour}},
+	"summary":        {User: rateLimit{10, time.Hour}, Group: rateLimit{30, time.Hour}},
+}
+
+// rateLimitNames are the limited things which aren't commands
+var rateLimitNames = map[string]bool{"chat": true, "image_analysis": true}
+
+func parseRateLimits(value string) (map[string]rateLimits, error) {
+	// The default limits, with any in value replacing them
+	limits := map[string]rateLimits{}
+	for name, limit := range defaultRateLimits {
+		limits[name] = limit
+	}
+	for _, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		key, spec, ok := strings.Cut(entry, "=")
+		name, scope, ok2 := strings.Cut(key, ".")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid rate limit %q, expected eg. imagine.user=3/1h", entry)
+		}
+		if cmd, found := Commands.Lookup(name); found {
+			name = cmd.Name
+		} else if !rateLimitNames[name] {
+			return nil, fmt.Errorf("invalid rate limit %q: unknown command %s", entry, name)
+		}
+		limit, err := parseRateLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q: %w", entry, err)
+		}
+		current := limits[name]
+		switch scope {
+		case "user":
+			current.User = limit
+		case "group":
+			current.Group = limit
+		default:
+			return nil, fmt.Errorf("invalid rate limit %q: expected user or group, got %s", entry, scope)
+		}
+		limits[name] = current
+	}
+	return limits, nil
+}
+
+func parseRateLimit(spec string) (rateLimit, error) {
+	// eg. 3/1h, 10/30m, or off
+	if spec == "off" {
+		return rateLimit{}, nil
+	}
+	count, per, ok := strings.Cut(spec, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n < 0 {
+		return rateLimit{}, fmt.Errorf("expected a number of requests and a duration, eg. 3/1h")
+	}
+	duration, err := time.ParseDuration(per)
+	if err != nil || duration <= 0 {
+		return rateLimit{}, fmt.Errorf("invalid duration %s", per)
+	}
+	return rateLimit{Count: n, Per: duration}, nil
+}
+
+// configuredRateLimits are the limits in force, set from RATE_LIMITS at startup
+var configuredRateLimits = defaultRateLimits
+
+func rateLimitsFor(name string) rateLimits {
+	return configuredRateLimits[name]
+}
+
+// tokenBucket holds the requests still allowed under one limit
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   rateLimit // What it refills at, so it can be swept once full
+}
+
+// limitedBucket is a bucket to take a token from, and the limit it refills at
+type limitedBucket struct {
+	Key   string
+	Limit rateLimit
+}
+
+// rateLimiterSweep is how often the limiter forgets buckets which have refilled
+const rateLimiterSweep = 10 * time.Minute
+
+// rateLimiter keeps a token bucket for each member and group and the thing
+// they're limited on. Buckets start full and refill steadily, so Count
+// requests can be made at once but no more than Count every Per on average.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+var limiter = newRateLimiter()
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// take removes a token from every bucket, or from none of them if any is
+// empty. It returns the index of the first empty bucket and how long until it
+// has a token, or -1 if the request is allowed.
+func (l *rateLimiter) take(now time.Time, buckets ...limitedBucket) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	for i, limited := range buckets {
+		if limited.Limit.Count <= 0 {
+			continue
+		}
+		bucket := l.refill(now, limited)
+		if bucket.tokens < 1 {
+			perToken := float64(limited.Limit.Per) / float64(limited.Limit.Count)
+			return i, time.Duration(math.Ceil((1 - bucket.tokens) * perToken))
+		}
+	}
+	for _, limited := range buckets {
+		if limited.Limit.Count > 0 {
+			l.buckets[limited.Key].tokens--
+		}
+	}
+	return -1, 0
+}
+
+func (l *rateLimiter) refill(now time.Time, limited limitedBucket) *tokenBucket {
+	capacity := float64(limited.Limit.Count)
+	bucket, ok := l.buckets[limited.Key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now, limit: limited.Limit}
+		l.buckets[limited.Key] = bucket
+	}
+	elapsed := now.Sub(bucket.updated)
+	if elapsed > 0 {
+		bucket.tokens = math.Min(capacity, bucket.tokens+capacity*float64(elapsed)/float64(limited.Limit.Per))
+		bucket.updated = now
+	}
+	return bucket
+}
+
+// sweep forgets the buckets which have refilled since they were last used.
+// A missing bucket starts full, so nothing changes except that the buckets of
+// members and groups who stopped using the bot aren't kept forever.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimiterSweep {
+		return
+	}
+	l.swept = now
+	for key, bucket := range l.buckets {
+		if l.refill(now, limitedBucket{Key: key, Limit: bucket.limit}).tokens >= float64(bucket.limit.Count) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// checkRateLimit takes a token for the sender and their group, returning an
+// empty string if they're allowed to use name, otherwise the reason they can't
+func (ctx *AppContext) checkRateLimit(req *RequestContext, name string, now time.Time) string {
+	if isBotOwner(req) {
+		return ""
+	}
+	limits := rateLimitsFor(name)
+	member := memberId(req.SourceUuid, req.SourceNumber)
+	if member == "" {
+		// Scheduled digests aren't asked for by anyone
+		limits.User = rateLimit{}
+	}
+	scope, wait := limiter.take(now,
+		limitedBucket{Key: "user/" + member + "/" + name, Limit: limits.User},
+		limitedBucket{Key: "group/" + req.GroupId + "/" + name, Limit: limits.Group},
+	)
+	switch scope {
+	case 0:
+		return fmt.Sprintf("Sorry, you're using %s too often. Try again %s.", describeLimited(name), describeWait(wait))
+	case 1:
+		return fmt.Sprintf("Sorry, this group is using %s too often. Try again %s.", describeLimited(name), describeWait(wait))
+	}
+	return ""
+}
+
+func describeLimited(name string) string {
+	switch name {
+	case "chat":
+		return "the bot"
+	case "image_analysis":
+		return "image descriptions"
+	case "embedding":
+		return "indexing messages for !ask"
+	}
+	return "!" + name
+}
+
+func describeWait(wait time.Duration) string {
+	minutes := int(math.Ceil(wait.Minutes()))
+	switch {
+	case minutes <= 1:
+		return "in a minute"
+	case minutes < 90:
+		return fmt.Sprintf("in %d minutes", minutes)
+	}
+	return fmt.Sprintf("in %d hours", int(math.Round(wait.Hours())))
+}
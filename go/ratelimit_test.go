@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Date(2024, 12, 4, 15, 30, 0, 0, time.UTC)
+	user := limitedBucket{Key: "user/alice/imagine", Limit: rateLimit{2, time.Hour}}
+	group := limitedBucket{Key: "group/one/imagine", Limit: rateLimit{3, time.Hour}}
+
+	for i := 0; i < 2; i++ {
+		if scope, _ := l.take(now, user, group); scope != -1 {
+			t.Fatalf("request %d: expected to be allowed, got scope %d", i, scope)
+		}
+	}
+	// Alice has used her requests up, and the group's last one isn't taken
+	if scope, wait := l.take(now, user, group); scope != 0 || wait != 30*time.Minute {
+		t.Errorf("expected the user limit to refuse for 30m, got scope %d for %v", scope, wait)
+	}
+	bob := limitedBucket{Key: "user/bob/imagine", Limit: rateLimit{2, time.Hour}}
+	if scope, _ := l.take(now, bob, group); scope != -1 {
+		t.Errorf("expected Bob to get the group's last request, got scope %d", scope)
+	}
+	if scope, wait := l.take(now, bob, group); scope != 1 || wait != 20*time.Minute {
+		t.Errorf("expected the group limit to refuse for 20m, got scope %d for %v", scope, wait)
+	}
+
+	// Buckets refill steadily
+	if scope, _ := l.take(now.Add(30*time.Minute), user, group); scope != -1 {
+		t.Errorf("expected a request to be allowed after refilling, got scope %d", scope)
+	}
+	// No limit is never refused
+	for i := 0; i < 10; i++ {
+		if scope, _ := l.take(now, limitedBucket{Key: "unlimited"}); scope != -1 {
+			t.Fatalf("expected no limit, got scope %d", scope)
+		}
+	}
+}
+
+func TestRateLimiterSweepsFullBuckets(t *testing.T) {
+	l := newRateLimiter()
+	now := time.Date(2024, 12, 4, 15, 30, 0, 0, time.UTC)
+	alice := limitedBucket{Key: "user/alice/imagine", Limit: rateLimit{2, time.Hour}}
+	bob := limitedBucket{Key: "user/bob/imagine", Limit: rateLimit{2, time.Hour}}
+	l.take(now, alice)
+	l.take(now.Add(40*time.Minute), bob)
+
+	// Alice's bucket has refilled, so is forgotten, but Bob's is still in use
+	l.take(now.Add(time.Hour), limitedBucket{Key: "unlimited"})
+	if _, ok := l.buckets[alice.Key]; ok {
+		t.Errorf("expected Alice's full bucket to be swept")
+	}
+	if bucket, ok := l.buckets[bob.Key]; !ok || bucket.tokens >= 2 {
+		t.Errorf("expected Bob's bucket to be kept, got %+v", bucket)
+	}
+	// A forgotten bucket starts full again
+	for i := 0; i < 2; i++ {
+		if scope, _ := l.take(now.Add(time.Hour), alice); scope != -1 {
+			t.Errorf("request %d: expected to be allowed, got scope %d", i, scope)
+		}
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("dream.user=3/30m, chat.group=off")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limits["imagine"].User != (rateLimit{3, 30 * time.Minute}) || limits["imagine"].Group != defaultRateLimits["imagine"].Group {
+		t.Errorf("unexpected limits for imagine: %+v", limits["imagine"])
+	}
+	if limits["chat"].Group.Count != 0 || limits["chat"].User != defaultRateLimits["chat"].User {
+		t.Errorf("unexpected limits for chat: %+v", limits["chat"])
+	}
+
+	for _, value := range []string{"imagine=3/1h", "imagine.user", "imagine.everyone=3/1h", "nosuchcommand.user=3/1h",
+		"imagine.user=3", "imagine.user=x/1h", "imagine.user=3/soon", "imagine.user=3/-1h"} {
+		if _, err := parseRateLimits(value); err == nil {
+			t.Errorf("parseRateLimits(%q): expected an error", value)
+		}
+	}
+}
+
+func TestRateLimitedCommand(t *testing.T) {
+	Config = map[string]string{"BOT_OWNERS": "+15550001000"}
+	limits, err := parseRateLimits("ping.user=1/1h")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configuredRateLimits = limits
+	t.Cleanup(func() { configuredRateLimits = defaultRateLimits })
+	limiter = newRateLimiter()
+	ctx := &AppContext{Store: newTestStore(t)}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	alice := &RequestContext{SourceNumber: "+15550001111", GroupId: "groupOne", TraceContext: context.Background()}
+	owner := &RequestContext{SourceNumber: "+15550001000", GroupId: "groupOne", TraceContext: context.Background()}
+
+	for i := 0; i < 2; i++ {
+		ctx.dispatchCommand(alice, "!ping")
+		ctx.dispatchCommand(owner, "!ping")
+	}
+	if len(replies) != 4 || !strings.HasPrefix(replies[0], "Pong!") || !strings.HasPrefix(replies[1], "Pong!") ||
+		replies[2] != "Sorry, you're using !ping too often. Try again in 60 minutes." || !strings.HasPrefix(replies[3], "Pong!") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPollInterval is how often we poll /v1/receive when POLL_INTERVAL isn't set
+	defaultPollInterval = 5 * time.Second
+	// maxPollBackoff caps how long we wait between retries after repeated failures
+	maxPollBackoff = 5 * time.Minute
+)
+
+func getPollInterval() time.Duration {
+	// POLL_INTERVAL may be a duration (eg. 500ms, 10s) or a plain number of seconds
+	value := Config["POLL_INTERVAL"]
+	if value == "" {
+		return defaultPollInterval
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+		return interval
+	}
+	log.Println("Invalid POLL_INTERVAL:", value, ", defaulting to", defaultPollInterval)
+	return defaultPollInterval
+}
+
+func pollBackoff(interval time.Duration, failures int) time.Duration {
+	// Double the wait after each consecutive failure, up to maxPollBackoff
+	wait := interval
+	for i := 0; i < failures && wait < maxPollBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxPollBackoff {
+		wait = maxPollBackoff
+	}
+	return wait
+}
+
+func fetchRestMessages(runCtx context.Context, client *http.Client) ([]*SignalMessage, error) {
+	// Fetch the pending messages from the REST API at
+	// {config.rest_url}/v1/receive/{config.phone}
+	url := fmt.Sprintf("%s/v1/receive/%s", Config["REST_URL"], url.PathEscape(Config["PHONE"]))
+	request, err := http.NewRequestWithContext(runCtx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP GET request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d: %s", resp.StatusCode, string(body))
+	}
+	return decodeSignalMessages(body)
+}
+
+// restClient polls signal-cli-rest-api for new messages, for when it runs in
+// normal or native mode rather than json-rpc mode. Each message is fed through
+// the same pipeline as the websocket client. It returns once runCtx is cancelled
+// and every message already received has been processed.
+func (ctx *AppContext) restClient(runCtx context.Context) {
+	interval := getPollInterval()
+	client := &http.Client{Timeout: 2 * time.Minute}
+	log.Println("Polling for messages every", interval)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	failures := 0
+	for {
+		wait := interval
+		messages, err := fetchRestMessages(runCtx, client)
+		if err != nil && runCtx.Err() == nil {
+			failures++
+			wait = pollBackoff(interval, failures)
+			log.Printf("Failed to receive messages (%d consecutive failures), retrying in %s: %v", failures, wait, err)
+		} else if err == nil {
+			failures = 0
+			if len(messages) > 0 {
+				log.Println("Received", len(messages), "messages")
+				// There may be more waiting, so poll again straight away
+				wait = 0
+			}
+			for _, msg := range messages {
+				wg.Add(1)
+				go func(msg *SignalMessage) {
+					defer wg.Done()
+					ctx.processSignalMessage(msg)
+				}(msg)
+			}
+		}
+
+		select {
+		case <-runCtx.Done():
+			log.Println("Stopping REST client, waiting for messages in flight")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 10 * time.Second},
+		{3, 40 * time.Second},
+		{20, maxPollBackoff},
+	}
+	for _, tt := range tests {
+		if got := pollBackoff(5*time.Second, tt.failures); got != tt.want {
+			t.Errorf("pollBackoff(5s, %d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestGetPollInterval(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":        defaultPollInterval,
+		"10":      10 * time.Second,
+		"500ms":   500 * time.Millisecond,
+		"invalid": defaultPollInterval,
+		"-1":      defaultPollInterval,
+	}
+	for value, want := range tests {
+		Config["POLL_INTERVAL"] = value
+		if got := getPollInterval(); got != want {
+			t.Errorf("getPollInterval(%q) = %s, want %s", value, got, want)
+		}
+	}
+	Config["POLL_INTERVAL"] = ""
+}
+
+func TestRestClient(t *testing.T) {
+	// The first poll fails, the second returns a batch, and every poll after that is empty
+	var mu sync.Mutex
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		if r.URL.Path != "/v1/receive/+123456789" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		switch polls {
+		case 1:
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+		case 2:
+			w.Write([]byte(`[
+				{"envelope":{"sourceName":"Test User","timestamp":1,"dataMessage":{"message":"!ping","groupInfo":{"groupId":"groupOne"}}}},
+				{"envelope":{"sourceName":"Test User","timestamp":2,"receiptMessage":{"isRead":true,"timestamps":[1]}}},
+				{"not an envelope": true},
+				{"envelope":{"sourceName":"Test User","timestamp":3,"dataMessage":{"message":"!ping","groupInfo":{"groupId":"groupTwo"}}}}
+			]`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+	Config["REST_URL"] = server.URL
+	Config["PHONE"] = "+123456789"
+	Config["POLL_INTERVAL"] = "10ms"
+	defer func() { Config["POLL_INTERVAL"] = "" }()
+
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	replies := make(chan string, 10)
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies <- req.GroupId
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ctx.restClient(runCtx)
+		close(done)
+	}()
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case groupId := <-replies:
+			got[groupId] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for replies, got %v", got)
+		}
+	}
+	if !got["groupOne"] || !got["groupTwo"] {
+		t.Errorf("expected replies to groupOne and groupTwo, got %v", got)
+	}
+
+	// Shutting down returns promptly
+	stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("restClient did not stop")
+	}
+}
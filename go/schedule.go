@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// scheduleGracePeriod is how late a digest can be and still be posted, eg.
+// after the bot was restarted. Anything later is skipped until the next one.
+const scheduleGracePeriod = time.Hour
+
+// scheduleDefaultWindow is what the first digest covers when a schedule has
+// no time range of its own. Later digests cover everything since the last one.
+const scheduleDefaultWindow = 24 * time.Hour
+
+// scheduleArgs are the parsed arguments to !schedule
+type scheduleArgs struct {
+	Action string // add, list or remove
+	Spec   string // The cron expression, for add
+	Args   string // The !summary arguments, for add
+	Id     int64  // The schedule, for remove
+}
+
+func parseScheduleArgs(name string, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, errMissingArgs
+	}
+	parsed := scheduleArgs{Action: strings.ToLower(args[0])}
+	switch parsed.Action {
+	case "list":
+		return parsed, nil
+	case "remove", "rm", "delete":
+		parsed.Action = "remove"
+		if len(args) != 2 {
+			return nil, fmt.Errorf("which schedule should be removed? Use !schedule list to find its number")
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule number %s", args[1])
+		}
+		parsed.Id = id
+		return parsed, nil
+	case "add":
+		// The cron expression is either one @shorthand or five fields
+		rest := args[1:]
+		fields := cronFieldCount
+		if len(rest) > 0 && strings.HasPrefix(rest[0], "@") {
+			fields = 1
+		}
+		if len(rest) < fields {
+			return nil, fmt.Errorf("when should the digest be posted? eg. \"0 8 * * *\" for 08:00 every day")
+		}
+		parsed.Spec = strings.Join(rest[:fields], " ")
+		if _, err := parseCron(parsed.Spec); err != nil {
+			return nil, err
+		}
+		parsed.Args = strings.Join(rest[fields:], " ")
+		if parsed.Args != "" {
+			summary, err := parseSummaryArgs("summary", rest[fields:])
+			if err != nil {
+				return nil, err
+			}
+			if a := summary.(summaryArgs); a.SinceMe || a.DirectMessage {
+				return nil, fmt.Errorf("digests are posted to the whole group, so since-me and dm can't be used")
+			}
+		}
+		return parsed, nil
+	}
+	return nil, fmt.Errorf("unknown action %s", args[0])
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "schedule",
+		Usage:       "add <cron> [num_msgs|time range] | list | remove <number>",
+		Description: "Post summaries to the group automatically, eg. !schedule add 0 8 * * * at 08:00 every day",
+		Permission:  PermissionAdmins,
+		ParseArgs:   parseScheduleArgs,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.scheduleCommand(req, args.(scheduleArgs))
+		},
+	})
+}
+
+func (ctx *AppContext) scheduleCommand(req *RequestContext, args scheduleArgs) error {
+	switch args.Action {
+	case "add":
+		schedule := Schedule{
+			GroupId:   req.GroupId,
+			Spec:      args.Spec,
+			Args:      args.Args,
+			CreatedBy: req.SourceName,
+			CreatedAt: req.Timestamp,
+		}
+		if err := ctx.Store.SaveSchedule(req.TraceContext, &schedule); err != nil {
+			ctx.MessagePoster(req, "Sorry, I couldn't save the schedule: "+err.Error(), "")
+			return err
+		}
+		ctx.MessagePoster(req, fmt.Sprintf("Scheduled #%d. %s", schedule.Id, ctx.describeSchedule(req.TraceContext, schedule)), "")
+	case "list":
+		schedules, err := ctx.Store.FetchSchedules(req.TraceContext, req.GroupId)
+		if err != nil {
+			ctx.MessagePoster(req, "Sorry, I couldn't fetch the schedules: "+err.Error(), "")
+			return err
+		}
+		if len(schedules) == 0 {
+			ctx.MessagePoster(req, "Nothing is scheduled for this group. Add a digest with !schedule add <cron>", "")
+			return nil
+		}
+		var message strings.Builder
+		for _, schedule := range schedules {
+			fmt.Fprintf(&message, "#%d: %s", schedule.Id, ctx.describeSchedule(req.TraceContext, schedule))
+			if schedule.CreatedBy != "" {
+				fmt.Fprintf(&message, " Added by %s.", schedule.CreatedBy)
+			}
+			message.WriteString("\n")
+		}
+		ctx.MessagePoster(req, message.String(), "")
+	case "remove":
+		deleted, err := ctx.Store.DeleteSchedule(req.TraceContext, req.GroupId, args.Id)
+		if err != nil {
+			ctx.MessagePoster(req, "Sorry, I couldn't remove the schedule: "+err.Error(), "")
+			return err
+		}
+		if !deleted {
+			ctx.MessagePoster(req, fmt.Sprintf("There's no schedule #%d in this group.", args.Id), "")
+			return nil
+		}
+		ctx.MessagePoster(req, fmt.Sprintf("Removed schedule #%d.", args.Id), "")
+	}
+	return nil
+}
+
+func (ctx *AppContext) describeSchedule(traceCtx context.Context, schedule Schedule) string {
+	// eg. "0 8 * * * summarizing 24h, next at Mon Dec 2 08:00."
+	covers := "everything since the last digest"
+	if schedule.Args != "" {
+		covers = schedule.Args
+	}
+	description := fmt.Sprintf("%s summarizing %s", schedule.Spec, covers)
+	if spec, err := parseCron(schedule.Spec); err == nil {
+		now := time.Now().In(ctx.groupLocation(traceCtx, schedule.GroupId))
+		if next := spec.Next(now); !next.IsZero() {
+			description += ", next at " + next.Format("Mon Jan 2 15:04 MST")
+		}
+	}
+	return description + "."
+}
+
+func (ctx *AppContext) runScheduler() {
+	// Check for digests that are due at the start of every minute
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		now = time.Now()
+		for _, schedule := range ctx.dueSchedules(now) {
+			go ctx.postDigest(schedule, now)
+		}
+	}
+}
+
+func (ctx *AppContext) dueSchedules(now time.Time) []Schedule {
+	// Find the schedules which should run now, and mark them as run
+	tracer := otel.Tracer("signal-bot")
+	scheduleCtx, span := tracer.Start(ctx.TraceContext, "dueSchedules")
+	defer span.End()
+
+	schedules, err := ctx.Store.FetchSchedules(scheduleCtx, "")
+	if err != nil {
+		log.Println("Failed to fetch schedules:", err)
+		return nil
+	}
+	var due []Schedule
+	for _, schedule := range schedules {
+		spec, err := parseCron(schedule.Spec)
+		if err != nil {
+			log.Printf("Invalid schedule #%d: %v", schedule.Id, err)
+			continue
+		}
+		previous := schedule.LastRun
+		if previous == 0 {
+			previous = schedule.CreatedAt
+		}
+		next := spec.Next(time.UnixMilli(previous).In(ctx.groupLocation(scheduleCtx, schedule.GroupId)))
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+
+		// Record the run before posting, so a digest that fails or crashes
+		// the bot isn't posted over and over
+		if err := ctx.Store.MarkScheduleRun(scheduleCtx, schedule.Id, now.UnixMilli()); err != nil {
+			log.Printf("Failed to mark schedule #%d as run: %v", schedule.Id, err)
+			continue
+		}
+		if now.Sub(next) > scheduleGracePeriod {
+			log.Printf("Skipping schedule #%d in %s, it was due at %s", schedule.Id, schedule.GroupId, next)
+			continue
+		}
+		due = append(due, schedule)
+	}
+	return due
+}
+
+func (ctx *AppContext) postDigest(schedule Schedule, now time.Time) {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	digestCtx, span := tracer.Start(ctx.TraceContext, "postDigest")
+	defer span.End()
+
+	// The digest is posted to the group as if someone had asked for it
+	req := &RequestContext{
+		GroupId:      schedule.GroupId,
+		Conversation: schedule.GroupId,
+		Recipient:    recipientFor(schedule.GroupId),
+		SourceName:   "schedule #" + strconv.FormatInt(schedule.Id, 10),
+		Timestamp:    now.UnixMilli(),
+	}
+	req.TraceContext = withUsageOwner(digestCtx, req, "schedule")
+	if refusal := ctx.checkBudget(digestCtx, schedule.GroupId, "", now); refusal != "" {
+		log.Printf("Skipping schedule #%d: %s", schedule.Id, refusal)
+		return
+	}
+
+	// Without a time range of its own, each digest picks up where the last one left off
+	window := TimeRange{Start: now.Add(-scheduleDefaultWindow), End: now}
+	if schedule.LastRun != 0 {
+		window.Start = time.UnixMilli(schedule.LastRun)
+	}
+	count := 0
+	if schedule.Args != "" {
+		args, err := parseSummaryArgs("summary", strings.Fields(schedule.Args))
+		if err != nil {
+			log.Printf("Invalid arguments to schedule #%d: %v", schedule.Id, err)
+			return
+		}
+		a := args.(summaryArgs)
+		count = a.Count
+		if a.Range != nil {
+			window = a.Range(now.In(ctx.groupLocation(digestCtx, schedule.GroupId)))
+		}
+	}
+
+	// Quiet groups don't need to be told every day that nothing happened
+	messages, err := ctx.fetchLogsFromDB(digestCtx, schedule.GroupId, window, count)
+	if err != nil {
+		log.Printf("Failed to fetch messages for schedule #%d: %v", schedule.Id, err)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+	if err := ctx.postSummary(req.TraceContext, req, messages, ""); err != nil {
+		log.Printf("Failed to post digest for schedule #%d: %v", schedule.Id, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleArgs(t *testing.T) {
+	args, err := parseScheduleArgs("schedule", strings.Fields("add 0 8 * * * 24h"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(scheduleArgs); parsed.Action != "add" || parsed.Spec != "0 8 * * *" || parsed.Args != "24h" {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	args, err = parseScheduleArgs("schedule", strings.Fields("add @weekly"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(scheduleArgs); parsed.Spec != "@weekly" || parsed.Args != "" {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	args, err = parseScheduleArgs("schedule", strings.Fields("remove #3"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(scheduleArgs); parsed.Action != "remove" || parsed.Id != 3 {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	for _, text := range []string{"add", "add 0 8 * *", "add 0 8 * * * since-me", "add 0 8 * * * whenever", "remove", "remove three", "pause 1"} {
+		if _, err := parseScheduleArgs("schedule", strings.Fields(text)); err == nil {
+			t.Errorf("parseScheduleArgs(%q): expected an error", text)
+		}
+	}
+}
+
+func TestScheduleCommand(t *testing.T) {
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	req := &RequestContext{GroupId: "groupOne", SourceName: "Alice", Timestamp: time.Now().UnixMilli(), TraceContext: context.Background()}
+	other := &RequestContext{GroupId: "groupTwo", SourceName: "Mallory", Timestamp: time.Now().UnixMilli(), TraceContext: context.Background()}
+
+	if err := ctx.scheduleCommand(req, scheduleArgs{Action: "add", Spec: "0 8 * * *", Args: "24h"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "Scheduled #1. 0 8 * * * summarizing 24h, next at") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+
+	// Other groups can't see or remove the schedule
+	replies = nil
+	ctx.scheduleCommand(other, scheduleArgs{Action: "list"})
+	ctx.scheduleCommand(other, scheduleArgs{Action: "remove", Id: 1})
+	if len(replies) != 2 || !strings.HasPrefix(replies[0], "Nothing is scheduled") || !strings.HasPrefix(replies[1], "There's no schedule #1") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+
+	replies = nil
+	ctx.scheduleCommand(req, scheduleArgs{Action: "list"})
+	ctx.scheduleCommand(req, scheduleArgs{Action: "remove", Id: 1})
+	ctx.scheduleCommand(req, scheduleArgs{Action: "list"})
+	if len(replies) != 3 || !strings.Contains(replies[0], "#1: 0 8 * * *") || !strings.Contains(replies[0], "Added by Alice") ||
+		replies[1] != "Removed schedule #1." || !strings.HasPrefix(replies[2], "Nothing is scheduled") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+}
+
+func TestScheduledDigests(t *testing.T) {
+	Config = map[string]string{"BOTNAME": "Robo", "TIMEZONE": "UTC"}
+	ctx := &AppContext{Store: newTestStore(t), SummaryProvider: &debugProvider{}, TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, req.Recipient+": "+message)
+	}
+
+	created := time.Date(2024, 12, 4, 7, 0, 0, 0, time.UTC)
+	schedule := Schedule{GroupId: "groupOne", Spec: "0 8 * * *", CreatedAt: created.UnixMilli()}
+	if err := ctx.Store.SaveSchedule(context.Background(), &schedule); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, text := range []string{"yesterday's news", "today's news"} {
+		msg := StoredMessage{Timestamp: created.Add(time.Duration(i-1) * 12 * time.Hour).UnixMilli(), SourceName: "Alice", Message: text, GroupId: "groupOne"}
+		if err := ctx.Store.SaveMessage(context.Background(), &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Nothing is due before 08:00
+	if due := ctx.dueSchedules(created.Add(59 * time.Minute)); len(due) != 0 {
+		t.Errorf("expected nothing to be due, got %+v", due)
+	}
+
+	// At 08:00 the first digest covers the last 24 hours
+	eight := created.Add(time.Hour)
+	due := ctx.dueSchedules(eight)
+	if len(due) != 1 {
+		t.Fatalf("expected the schedule to be due, got %+v", due)
+	}
+	ctx.postDigest(due[0], eight)
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], encodeGroupIdToBase64("groupOne")+": ") ||
+		!strings.Contains(replies[0], "yesterday's news") || !strings.Contains(replies[0], "today's news") {
+		t.Errorf("expected a digest posted to the group, got %q", replies)
+	}
+
+	// It's not due again until tomorrow
+	if due := ctx.dueSchedules(eight.Add(time.Minute)); len(due) != 0 {
+		t.Errorf("expected nothing to be due, got %+v", due)
+	}
+
+	// The next digest picks up from the last, and quiet days aren't posted
+	replies = nil
+	tomorrow := eight.Add(24 * time.Hour)
+	due = ctx.dueSchedules(tomorrow)
+	if len(due) != 1 {
+		t.Fatalf("expected the schedule to be due, got %+v", due)
+	}
+	ctx.postDigest(due[0], tomorrow)
+	if len(replies) != 0 {
+		t.Errorf("expected nothing to be posted, got %q", replies)
+	}
+
+	// Digests missed while the bot was down are skipped
+	if due := ctx.dueSchedules(tomorrow.Add(26 * time.Hour)); len(due) != 0 {
+		t.Errorf("expected the missed digest to be skipped, got %+v", due)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// maxSearchResults is how many matches !search replies with
+const maxSearchResults = 10
+
+// maxSearchResultLength is where long messages are cut off in the results
+const maxSearchResultLength = 300
+
+// searchArgs are the parsed arguments to !search
+type searchArgs struct {
+	Terms  []string
+	Author string
+	Since  rangeSpec // Only search from the start of this range, nil searches everything we have
+}
+
+func parseSearchArgs(name string, args []string) (interface{}, error) {
+	// Pull the from: and since: filters out, everything else is a search term
+	var parsed searchArgs
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, ":")
+		switch {
+		case found && strings.EqualFold(key, "from") && value != "":
+			parsed.Author = value
+		case found && strings.EqualFold(key, "since") && value != "":
+			since, err := parseTimeRange([]string{value})
+			if err != nil {
+				return nil, err
+			}
+			parsed.Since = since
+		default:
+			parsed.Terms = append(parsed.Terms, arg)
+		}
+	}
+	if len(parsed.Terms) == 0 {
+		return nil, errMissingArgs
+	}
+	return parsed, nil
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "search",
+		Usage:       "<terms> [from:name] [since:3d]",
+		Description: "Search this group's messages",
+		GroupTarget: true,
+		ParseArgs:   parseSearchArgs,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.searchCommand(req, args.(searchArgs))
+		},
+	})
+}
+
+func (ctx *AppContext) searchCommand(req *RequestContext, args searchArgs) error {
+	// Start a new span
+	tracer := otel.Tracer("signal-bot")
+	searchCtx, span := tracer.Start(req.TraceContext, "searchCommand")
+	defer span.End()
+
+	// Search everything before the !search message itself, so it doesn't match
+	opts := SearchOptions{
+		Terms:  args.Terms,
+		Author: args.Author,
+		Before: req.Timestamp,
+		Limit:  maxSearchResults,
+	}
+	if args.Since != nil {
+		opts.Since = args.Since(time.Now().In(ctx.groupLocation(req.TraceContext, req.GroupId))).Start.UnixMilli()
+	}
+	messages, err := ctx.Store.Search(searchCtx, req.GroupId, opts)
+	if err != nil {
+		ctx.MessagePoster(req, "Search failed: "+err.Error(), "")
+		return err
+	}
+	if len(messages) == 0 {
+		ctx.MessagePoster(req, "No messages found matching: "+strings.Join(args.Terms, " "), "")
+		return nil
+	}
+
+	ctx.postLongMessage(req, formatSearchResults(messages, ctx.groupLocation(req.TraceContext, req.GroupId)))
+	return nil
+}
+
+func formatSearchResults(messages []StoredMessage, loc *time.Location) string {
+	// One line per match, newest first, eg. "[2024-12-01 14:05] Alice: the message"
+	var results strings.Builder
+	fmt.Fprintf(&results, "Found %d messages:\n", len(messages))
+	for _, msg := range messages {
+		text := msg.Message
+		if runes := []rune(text); len(runes) > maxSearchResultLength {
+			text = string(runes[:maxSearchResultLength]) + "..."
+		}
+		sent := time.UnixMilli(msg.Timestamp).In(loc).Format("2006-01-02 15:04")
+		fmt.Fprintf(&results, "[%s] %s: %s\n", sent, msg.SourceName, text)
+	}
+	return results.String()
+}
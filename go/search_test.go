@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSearchArgs(t *testing.T) {
+	args, err := parseSearchArgs("search", []string{"cat", "pictures", "from:Alice", "since:3d"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed := args.(searchArgs)
+	if strings.Join(parsed.Terms, " ") != "cat pictures" || parsed.Author != "Alice" || parsed.Since == nil {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	now := time.Now()
+	if start := parsed.Since(now).Start; !start.Equal(now.Add(-72 * time.Hour)) {
+		t.Errorf("expected since 3 days ago, got %v", start)
+	}
+
+	if _, err := parseSearchArgs("search", []string{"from:Alice"}); err != errMissingArgs {
+		t.Errorf("expected errMissingArgs without any terms, got %v", err)
+	}
+	if _, err := parseSearchArgs("search", []string{"cats", "since:soon"}); err == nil {
+		t.Errorf("expected an error for an invalid since")
+	}
+}
+
+func TestSearchCommand(t *testing.T) {
+	ctx := &AppContext{Store: newTestStore(t)}
+	now := time.Now().UnixMilli()
+	for _, msg := range []StoredMessage{
+		{Timestamp: now - 1000, SourceName: "Alice", Message: "the cat link is https://example.com", GroupId: "groupOne"},
+		{Timestamp: now - 500, SourceName: "Bob", Message: "cat in the wrong group", GroupId: "groupTwo"},
+		{Timestamp: now, SourceName: "Bob", Message: "!search cat", GroupId: "groupOne"},
+	} {
+		if err := ctx.Store.SaveMessage(context.Background(), &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+
+	req := &RequestContext{GroupId: "groupOne", Timestamp: now, TraceContext: context.Background()}
+	if err := ctx.searchCommand(req, searchArgs{Terms: []string{"cat"}, Since: lastDuration(time.Hour)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0], "Alice: the cat link is https://example.com") {
+		t.Fatalf("unexpected replies %q", replies)
+	}
+	if strings.Contains(replies[0], "wrong group") || strings.Contains(replies[0], "!search") {
+		t.Errorf("expected only the matching message from this group, got %q", replies[0])
+	}
+
+	replies = nil
+	if err := ctx.searchCommand(req, searchArgs{Terms: []string{"dog"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "No messages found") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+}
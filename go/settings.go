@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// groupSetting is a setting each group can choose for itself with !config
+type groupSetting struct {
+	Key         string
+	Description string
+	// Global is the Config key used when a group hasn't chosen, if there is one
+	Global string
+	// Validate checks a new value, returning it as it should be stored
+	Validate func(value string) (string, error)
+}
+
+// groupSettings are the settings a group can change, in the order !config lists them
+var groupSettings = []groupSetting{
+	{"summary_provider", "The provider used for !summary and !ask", "SUMMARY_PROVIDER", validateProvider},
+	{"summary_model", "The model used for !summary and !ask, instead of the provider's default", "", validateText},
+	{"chat_provider", "The provider used when chatting with the bot", "CHAT_PROVIDER", validateProvider},
+	{"chat_model", "The model used when chatting with the bot, instead of the provider's default", "", validateText},
+	{"max_age", "How many hours of messages to keep", "MAX_AGE", validateHours},
+	{"summary_prompt", "The instructions sent along with the chat log for !summary", "", validateText},
+	{"language", "The language the bot replies in, eg. French", "", validateText},
+	{"commands", "The commands the group can use, separated by commas. Unset allows them all.", "", validateCommands},
+	{"timezone", "The time zone used to understand and show times, eg. Europe/London", "TIMEZONE", validateTimezone},
+}
+
+// alwaysEnabledCommands can't be turned off, so a group can't lock itself out
+var alwaysEnabledCommands = map[string]bool{"admin": true, "config": true, "help": true}
+
+func findGroupSetting(key string) (*groupSetting, bool) {
+	key = strings.ToLower(key)
+	for i := range groupSettings {
+		if groupSettings[i].Key == key {
+			return &groupSettings[i], true
+		}
+	}
+	return nil, false
+}
+
+func validateText(value string) (string, error) {
+	return value, nil
+}
+
+func validateProvider(value string) (string, error) {
+	value = strings.ToLower(value)
+	if _, ok := llmProviders[value]; !ok {
+		var names []string
+		for name := range llmProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown provider %q, expected one of: %s", value, strings.Join(names, ", "))
+	}
+	return value, nil
+}
+
+func validateHours(value string) (string, error) {
+	hours, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "h"))
+	if err != nil || hours <= 0 {
+		return "", fmt.Errorf("%q isn't a number of hours", value)
+	}
+	return strconv.Itoa(hours), nil
+}
+
+func validateCommands(value string) (string, error) {
+	// Store the canonical names, so aliases work in the list too
+	var names []string
+	for _, name := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		cmd, ok := Commands.Lookup(name)
+		if !ok {
+			return "", fmt.Errorf("unknown command %s", name)
+		}
+		names = append(names, cmd.Name)
+	}
+	return strings.Join(names, ","), nil
+}
+
+func validateTimezone(value string) (string, error) {
+	if _, err := time.LoadLocation(value); err != nil {
+		return "", fmt.Errorf("unknown time zone %q, use a name like Europe/London", value)
+	}
+	return value, nil
+}
+
+func (ctx *AppContext) groupSettings(traceCtx context.Context, groupId string) map[string]string {
+	// Every setting the group has chosen. Failing to read them isn't worth
+	// failing the request for, the global config is used instead.
+	if groupId == "" {
+		return map[string]string{}
+	}
+	settings, err := ctx.Store.FetchGroupSettings(traceCtx, groupId)
+	if err != nil {
+		log.Println("Failed to fetch group settings:", err)
+		return map[string]string{}
+	}
+	return settings
+}
+
+// groupConfig returns a group's setting for key, or the global config if the
+// group hasn't chosen one
+func (ctx *AppContext) groupConfig(traceCtx context.Context, groupId string, key string) string {
+	if value := ctx.groupSettings(traceCtx, groupId)[key]; value != "" {
+		return value
+	}
+	if setting, ok := findGroupSetting(key); ok && setting.Global != "" {
+		return Config[setting.Global]
+	}
+	return ""
+}
+
+// groupLocation returns the time zone used to understand and show times in a group
+func (ctx *AppContext) groupLocation(traceCtx context.Context, groupId string) *time.Location {
+	name := ctx.groupConfig(traceCtx, groupId, "timezone")
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Println("Invalid time zone:", name, err)
+		return time.Local
+	}
+	return loc
+}
+
+// groupTime formats a message timestamp in the group's time zone
+func (ctx *AppContext) groupTime(traceCtx context.Context, groupId string, timestamp int64, layout string) string {
+	return time.UnixMilli(timestamp).In(ctx.groupLocation(traceCtx, groupId)).Format(layout)
+}
+
+// commandEnabled reports whether a group is allowed to use a command
+func (ctx *AppContext) commandEnabled(traceCtx context.Context, groupId string, cmd *Command) bool {
+	enabled := ctx.groupConfig(traceCtx, groupId, "commands")
+	if enabled == "" || alwaysEnabledCommands[cmd.Name] {
+		return true
+	}
+	for _, name := range strings.Split(enabled, ",") {
+		if name == cmd.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// languageInstruction is added to prompts for groups which chose a language
+func (ctx *AppContext) languageInstruction(traceCtx context.Context, groupId string) string {
+	language := ctx.groupConfig(traceCtx, groupId, "language")
+	if language == "" {
+		return ""
+	}
+	return "\nWrite your reply in " + language + "."
+}
+
+// providerCache holds the providers groups have chosen, so each is only created once
+type providerCache struct {
+	mu        sync.Mutex
+	providers map[string]LLMProvider
+}
+
+var groupProviders = providerCache{providers: map[string]LLMProvider{}}
+
+func (c *providerCache) get(purpose string, name string, model string) (LLMProvider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := purpose + "/" + name + "/" + model
+	if provider, ok := c.providers[key]; ok {
+		return provider, nil
+	}
+	provider, err := newLLMProvider(name, llmProviderOptions{Purpose: purpose, Model: model})
+	if err != nil {
+		return nil, err
+	}
+	c.providers[key] = provider
+	return provider, nil
+}
+
+func groupProvider(settings map[string]string, purpose string, fallback LLMProvider) (LLMProvider, error) {
+	// The provider and model a group chose for purpose, or fallback if it didn't choose
+	name, model := settings[purpose+"_provider"], settings[purpose+"_model"]
+	if name == "" && model == "" {
+		return fallback, nil
+	}
+	if name == "" {
+		name = Config[strings.ToUpper(purpose)+"_PROVIDER"]
+	}
+	return groupProviders.get(purpose, name, model)
+}
+
+// summaryProvider returns the provider a group uses for summaries and questions
+func (ctx *AppContext) summaryProvider(traceCtx context.Context, groupId string) LLMProvider {
+	provider, err := groupProvider(ctx.groupSettings(traceCtx, groupId), PurposeSummary, ctx.SummaryProvider)
+	if err != nil {
+		log.Println("Failed to set up the group's summary provider, using the default:", err)
+		provider = ctx.SummaryProvider
+	}
+	return meter(provider, ctx.Store)
+}
+
+// chatProvider returns the provider a group chats with, or nil if chat is disabled
+func (ctx *AppContext) chatProvider(traceCtx context.Context, groupId string) LLMProvider {
+	provider, err := groupProvider(ctx.groupSettings(traceCtx, groupId), PurposeChat, ctx.ChatProvider)
+	if err != nil {
+		log.Println("Failed to set up the group's chat provider, using the default:", err)
+		provider = ctx.ChatProvider
+	}
+	return meter(provider, ctx.Store)
+}
+
+// configArgs are the parsed arguments to !config
+type configArgs struct {
+	Action string // get, set or unset
+	Key    string // Empty for every setting, with get
+	Value  string
+}
+
+func parseConfigArgs(name string, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return configArgs{Action: "get"}, nil
+	}
+	parsed := configArgs{Action: strings.ToLower(args[0])}
+	if len(args) > 1 {
+		setting, ok := findGroupSetting(args[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %s, use !config get to see them all", args[1])
+		}
+		parsed.Key = setting.Key
+	}
+	switch parsed.Action {
+	case "get":
+		if len(args) > 2 {
+			return nil, fmt.Errorf("!config get takes one setting at most")
+		}
+	case "set":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("what should it be set to?")
+		}
+		setting, _ := findGroupSetting(parsed.Key)
+		value, err := setting.Validate(strings.Join(args[2:], " "))
+		if err != nil {
+			return nil, err
+		}
+		parsed.Value = value
+	case "unset":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("which setting should be unset?")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %s", args[0])
+	}
+	return parsed, nil
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "config",
+		Usage:       "get [setting] | set <setting> <value> | unset <setting>",
+		Description: "Show or change this group's settings",
+		Permission:  PermissionAdmins,
+		ParseArgs:   parseConfigArgs,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.configCommand(req, args.(configArgs))
+		},
+	})
+}
+
+func (ctx *AppContext) configCommand(req *RequestContext, args configArgs) error {
+	switch args.Action {
+	case "get":
+		settings, err := ctx.Store.FetchGroupSettings(req.TraceContext, req.GroupId)
+		if err != nil {
+			ctx.MessagePoster(req, "Sorry, I couldn't fetch the settings: "+err.Error(), "")
+			return err
+		}
+		var message strings.Builder
+		for _, setting := range groupSettings {
+			if args.Key != "" && setting.Key != args.Key {
+				continue
+			}
+			fmt.Fprintf(&message, "%s: %s\n", setting.Key, describeSettingValue(setting, settings[setting.Key]))
+			if args.Key != "" {
+				message.WriteString(setting.Description + "\n")
+			}
+		}
+		ctx.MessagePoster(req, message.String(), "")
+	case "set", "unset":
+		// Make sure a new provider or model actually works before switching to it
+		if strings.HasSuffix(args.Key, "_provider") || strings.HasSuffix(args.Key, "_model") {
+			if err := ctx.checkGroupProvider(req, args.Key, args.Value); err != nil {
+				ctx.MessagePoster(req, "Sorry, that didn't work: "+err.Error(), "")
+				return nil
+			}
+		}
+		if err := ctx.Store.SetGroupSetting(req.TraceContext, req.GroupId, args.Key, args.Value, req.SourceName); err != nil {
+			ctx.MessagePoster(req, "Sorry, I couldn't save the setting: "+err.Error(), "")
+			return err
+		}
+		setting, _ := findGroupSetting(args.Key)
+		ctx.MessagePoster(req, fmt.Sprintf("%s is now %s", args.Key, describeSettingValue(*setting, args.Value)), "")
+	}
+	return nil
+}
+
+func describeSettingValue(setting groupSetting, value string) string {
+	// The group's value, or what's used instead when it hasn't chosen one
+	if value != "" {
+		return value
+	}
+	if setting.Global != "" && Config[setting.Global] != "" {
+		return Config[setting.Global] + " (default)"
+	}
+	return "not set"
+}
+
+func (ctx *AppContext) checkGroupProvider(req *RequestContext, key string, value string) error {
+	// Try creating the provider the group would use once the setting changes
+	purpose := strings.TrimSuffix(strings.TrimSuffix(key, "_provider"), "_model")
+	settings := ctx.groupSettings(req.TraceContext, req.GroupId)
+	settings[key] = value
+	_, err := groupProvider(settings, purpose, nil)
+	return err
+}
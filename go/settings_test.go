@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigArgs(t *testing.T) {
+	args, err := parseConfigArgs("config", nil)
+	if err != nil || args.(configArgs).Action != "get" {
+		t.Errorf("expected get with no arguments, got %+v, %v", args, err)
+	}
+
+	args, err = parseConfigArgs("config", strings.Fields("set Summary_Prompt Be brief. Use bullet points."))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(configArgs); parsed.Key != "summary_prompt" || parsed.Value != "Be brief. Use bullet points." {
+		t.Errorf("unexpected args %+v", parsed)
+	}
+
+	args, err = parseConfigArgs("config", strings.Fields("set commands summary, ask,dream"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed := args.(configArgs); parsed.Value != "summary,ask,imagine" {
+		t.Errorf("expected the canonical command names, got %q", parsed.Value)
+	}
+
+	for _, text := range []string{"set", "set colour blue", "set max_age", "set max_age forever", "set summary_provider nobody",
+		"set timezone Nowhere/Special", "set commands summary,nosuchcommand", "unset", "get language extra", "reset language"} {
+		if _, err := parseConfigArgs("config", strings.Fields(text)); err == nil {
+			t.Errorf("parseConfigArgs(%q): expected an error", text)
+		}
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	Config = map[string]string{"MAX_AGE": "168", "SUMMARY_PROVIDER": "debug"}
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	req := &RequestContext{GroupId: "groupOne", SourceName: "Alice", TraceContext: context.Background()}
+
+	ctx.configCommand(req, configArgs{Action: "set", Key: "max_age", Value: "24"})
+	ctx.configCommand(req, configArgs{Action: "get"})
+	if len(replies) != 2 || replies[0] != "max_age is now 24" {
+		t.Fatalf("unexpected replies %q", replies)
+	}
+	for _, expected := range []string{"max_age: 24\n", "summary_provider: debug (default)\n", "language: not set\n"} {
+		if !strings.Contains(replies[1], expected) {
+			t.Errorf("expected the settings to contain %q, got %q", expected, replies[1])
+		}
+	}
+
+	// Other groups keep the global config
+	if maxAge := ctx.groupConfig(context.Background(), "groupTwo", "max_age"); maxAge != "168" {
+		t.Errorf("expected the global MAX_AGE for another group, got %s", maxAge)
+	}
+
+	replies = nil
+	ctx.configCommand(req, configArgs{Action: "unset", Key: "max_age"})
+	ctx.configCommand(req, configArgs{Action: "get", Key: "max_age"})
+	if len(replies) != 2 || replies[0] != "max_age is now 168 (default)" || !strings.HasPrefix(replies[1], "max_age: 168 (default)\nHow many hours") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+
+	// A provider which can't be set up isn't saved
+	replies = nil
+	ctx.configCommand(req, configArgs{Action: "set", Key: "summary_provider", Value: "claude"})
+	if len(replies) != 1 || !strings.HasPrefix(replies[0], "Sorry, that didn't work") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+	if provider := ctx.groupConfig(context.Background(), "groupOne", "summary_provider"); provider != "debug" {
+		t.Errorf("expected the provider not to change, got %s", provider)
+	}
+}
+
+func TestGroupSettingsAreUsed(t *testing.T) {
+	Config = map[string]string{"SUMMARY_PROVIDER": "small", "TIMEZONE": "Asia/Kolkata", "MAX_AGE": "168"}
+	store := newTestStore(t)
+	provider := &smallProvider{window: 100000}
+	ctx := &AppContext{Store: store, SummaryProvider: provider, TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	settings := map[string]string{
+		"summary_provider": "debug",
+		"summary_prompt":   "Sum it up",
+		"language":         "French",
+		"commands":         "summary",
+		"timezone":         "America/New_York",
+		"max_age":          "1",
+	}
+	for key, value := range settings {
+		if err := store.SetGroupSetting(context.Background(), "groupOne", key, value, "Alice"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	now := time.Now()
+	for _, group := range []string{"groupOne", "groupTwo"} {
+		for _, age := range []time.Duration{2 * time.Hour, time.Minute} {
+			msg := StoredMessage{Timestamp: now.Add(-age).UnixMilli(), SourceName: "Alice", Message: "hello", GroupId: group}
+			if err := store.SaveMessage(context.Background(), &msg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+
+	// The group's provider, prompt and language are used for summaries
+	req := &RequestContext{GroupId: "groupOne", TraceContext: context.Background()}
+	if err := ctx.summaryCommand(req, TimeRange{}, 5, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0], "DEBUG: user: Sum it up\nWrite your reply in French.") {
+		t.Errorf("expected the group's provider, prompt and language, got %q", replies)
+	}
+	if len(provider.prompts) != 0 {
+		t.Errorf("expected the default provider not to be used, got %q", provider.prompts)
+	}
+
+	// Only the enabled commands can be used, but !help always can
+	replies = nil
+	ctx.dispatchCommand(req, "!ping")
+	ctx.dispatchCommand(req, "!help ping")
+	if len(replies) != 2 || replies[0] != "Sorry, !ping is turned off in this group." || !strings.HasPrefix(replies[1], "!ping") {
+		t.Errorf("unexpected replies %q", replies)
+	}
+
+	if loc := ctx.groupLocation(context.Background(), "groupOne"); loc.String() != "America/New_York" {
+		t.Errorf("expected the group's time zone, got %s", loc)
+	}
+	if loc := ctx.groupLocation(context.Background(), "groupTwo"); loc.String() != "Asia/Kolkata" {
+		t.Errorf("expected the global time zone, got %s", loc)
+	}
+	if sent := ctx.groupTime(context.Background(), "groupTwo", 0, "15:04"); sent != "05:30" {
+		t.Errorf("expected the time in the global zone, got %s", sent)
+	}
+
+	// Each group keeps messages for as long as it chose
+	ctx.removeOldMessages("")
+	for group, expected := range map[string]int{"groupOne": 1, "groupTwo": 2} {
+		messages, err := store.FetchRange(context.Background(), group, 0, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(messages) != expected {
+			t.Errorf("expected %d messages left in %s, got %d", expected, group, len(messages))
+		}
+	}
+}
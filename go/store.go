@@ -0,0 +1,976 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// dbTimeout bounds every database call, so a locked or slow database can't
+// hold up a request forever.
+const dbTimeout = 10 * time.Second
+
+// StoredMessage is a message as kept in the database
+type StoredMessage struct {
+	Id               int64
+	Timestamp        int64  // When the message was sent, in ms
+	SourceNumber     string // May be empty if the sender hides their number
+	SourceName       string
+	Message          string
+	GroupId          string // The raw groupId
+	Mentions         []Mention
+	Attachments      []string // Paths of files the bot sent
+	ReplyToTimestamp int64    // For the bot's messages, the message which triggered them
+	ReplyToAuthor    string
+	SourceUuid       string   // May be empty for messages stored by older versions
+	EditedAt         int64    // When the message was last edited, in ms, zero if it never was
+	Reactions        []string // The emoji each member reacted with
+	ParentId         int64    // The message this one replied to, zero if it isn't a reply or we don't have it
+	QuoteAuthor      string   // For replies, the name of the author of the message replied to
+	QuoteText        string   // For replies, the text of the message replied to
+}
+
+// Store keeps the message history. Every method is scoped to a single group
+// where it makes sense, so one group's messages never leak into another's.
+type Store interface {
+	// SaveMessage stores a message and sets its Id
+	SaveMessage(ctx context.Context, msg *StoredMessage) error
+	// FetchRange returns a group's messages sent at or after start and before
+	// end, oldest first. An end of zero means there is no upper bound.
+	FetchRange(ctx context.Context, groupId string, start int64, end int64) ([]StoredMessage, error)
+	// FetchLastN returns a group's last n messages, oldest first
+	FetchLastN(ctx context.Context, groupId string, n int) ([]StoredMessage, error)
+	// FetchChatHistory returns the messages from, mentioning or naming the bot
+	// plus the most recent messages for context, up to limit of each, oldest first
+	FetchChatHistory(ctx context.Context, groupId string, botName string, botNumber string, limit int) ([]StoredMessage, error)
+	// Search returns messages in a group containing every search term, newest first
+	Search(ctx context.Context, groupId string, opts SearchOptions) ([]StoredMessage, error)
+	// SaveEmbedding stores the vector for a message, replacing any it already had
+	SaveEmbedding(ctx context.Context, messageId int64, model string, vector []float32) error
+	// FetchEmbeddings returns every vector made by model for a group's messages
+	FetchEmbeddings(ctx context.Context, groupId string, model string) ([]MessageEmbedding, error)
+	// FetchUnembedded returns up to limit messages, newest first, which have no
+	// vector from model. Commands and the bot's own replies are skipped.
+	FetchUnembedded(ctx context.Context, model string, limit int) ([]StoredMessage, error)
+	// FetchAround returns the message sent at timestamp in a group along with
+	// up to n messages either side of it, oldest first
+	FetchAround(ctx context.Context, groupId string, timestamp int64, n int) ([]StoredMessage, error)
+	// MarkSeen records that a member was active in a group at timestamp.
+	// Times earlier than the one already recorded are ignored.
+	MarkSeen(ctx context.Context, groupId string, member string, timestamp int64) error
+	// MarkRead records that a member read the messages sent at timestamps, in
+	// whichever groups those messages were sent to
+	MarkRead(ctx context.Context, member string, timestamps []int64) error
+	// LastSeen returns when a member was last active in a group, or zero if never
+	LastSeen(ctx context.Context, groupId string, member string) (int64, error)
+	// Prune deletes messages sent before the given time. An empty groupId prunes every group.
+	Prune(ctx context.Context, groupId string, before int64) (int64, error)
+	// SaveSchedule stores a new schedule and sets its Id
+	SaveSchedule(ctx context.Context, schedule *Schedule) error
+	// FetchSchedules returns a group's schedules, oldest first. An empty groupId returns every group's.
+	FetchSchedules(ctx context.Context, groupId string) ([]Schedule, error)
+	// DeleteSchedule removes one of a group's schedules, returning false if it didn't exist
+	DeleteSchedule(ctx context.Context, groupId string, id int64) (bool, error)
+	// MarkScheduleRun records when a schedule last ran
+	MarkScheduleRun(ctx context.Context, id int64, timestamp int64) error
+	// FetchGroups returns the groupId of every group with messages stored
+	FetchGroups(ctx context.Context) ([]string, error)
+	// SetGroupSetting stores one of a group's settings. An empty value removes it.
+	SetGroupSetting(ctx context.Context, groupId string, key string, value string, updatedBy string) error
+	// FetchGroupSettings returns every setting a group has chosen
+	FetchGroupSettings(ctx context.Context, groupId string) (map[string]string, error)
+	// AddGroupAdmin makes a member one of a group's admins
+	AddGroupAdmin(ctx context.Context, groupId string, member GroupMember, addedBy string) error
+	// RemoveGroupAdmin removes one of a group's admins, returning false if they weren't one
+	RemoveGroupAdmin(ctx context.Context, groupId string, memberId string) (bool, error)
+	// FetchGroupAdmins returns a group's admins, in the order they were added
+	FetchGroupAdmins(ctx context.Context, groupId string) ([]GroupMember, error)
+	// SetCommandPermission sets who can run a command in a group. An empty level
+	// goes back to the command's default.
+	SetCommandPermission(ctx context.Context, groupId string, command string, permission CommandPermission, updatedBy string) error
+	// FetchCommandPermissions returns the permissions a group has set, keyed by command
+	FetchCommandPermissions(ctx context.Context, groupId string) (map[string]CommandPermission, error)
+	// RecordUsage stores a request made to a provider
+	RecordUsage(ctx context.Context, usage UsageRecord) error
+	// FetchUsage totals the usage since a time for each command and member.
+	// An empty groupId or member matches every group or member.
+	FetchUsage(ctx context.Context, groupId string, member string, since int64) ([]UsageRecord, error)
+	// FetchMessage returns the message a member sent to a group at timestamp,
+	// the way Signal refers to messages, or nil if it isn't stored. Either of
+	// the author's UUID or number may be empty.
+	FetchMessage(ctx context.Context, groupId string, timestamp int64, authorUuid string, authorNumber string) (*StoredMessage, error)
+	// FetchThread returns the message a message replied to and the replies to
+	// it, oldest first
+	FetchThread(ctx context.Context, groupId string, id int64) ([]StoredMessage, error)
+	// EditMessage replaces the text of a message, keeping the old text as a revision
+	EditMessage(ctx context.Context, id int64, message string, editedAt int64) error
+	// DeleteMessage removes a message along with its revisions, reactions and vector
+	DeleteMessage(ctx context.Context, id int64) error
+	// SaveReaction sets a member's reaction to a message, replacing any they had
+	SaveReaction(ctx context.Context, messageId int64, member string, name string, emoji string, timestamp int64) error
+	// RemoveReaction removes a member's reaction to a message
+	RemoveReaction(ctx context.Context, messageId int64, member string) error
+	// Close releases the database
+	Close() error
+}
+
+const messageColumnList = "id, timestamp, sourceNumber, sourceName, message, groupId, mentions, attachments, replyToTimestamp, replyToAuthor," +
+	" sourceUuid, editedAt, (SELECT json_group_array(emoji) FROM message_reactions WHERE messageId = messages.id), parentId, quoteAuthor, quoteText"
+
+// SearchOptions narrow down a Search
+type SearchOptions struct {
+	Terms  []string // Words which must all appear in the message
+	Author string   // Only messages from senders whose name contains this, if set
+	Since  int64    // Only messages sent at or after this time, in ms
+	Before int64    // Only messages sent before this time, in ms, if set
+	Limit  int
+}
+
+// MessageEmbedding is the vector for a single message
+type MessageEmbedding struct {
+	MessageId int64
+	Timestamp int64
+	Vector    []float32
+}
+
+// Schedule is a digest posted to a group automatically
+type Schedule struct {
+	Id        int64
+	GroupId   string
+	Spec      string // A cron expression, in the group's time zone
+	Args      string // The !summary arguments for each digest. Empty summarizes since the last one.
+	CreatedBy string
+	CreatedAt int64 // In ms
+	LastRun   int64 // In ms, zero if it's never run
+}
+
+// UsageRecord is a request made to a provider, or the total of several
+type UsageRecord struct {
+	Timestamp    int64 // In ms, zero for totals
+	GroupId      string
+	Member       string
+	Command      string
+	Provider     string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	Images       int
+	Cost         float64 // In US dollars
+}
+
+// sqliteStore is a Store backed by a SQLite database
+type sqliteStore struct {
+	db      *sql.DB
+	timeout time.Duration
+	fts     bool // Whether the messages_fts full-text index is available
+
+	insertStmt  *sql.Stmt
+	rangeStmt   *sql.Stmt
+	lastNStmt   *sql.Stmt
+	historyStmt *sql.Stmt
+	searchStmt  *sql.Stmt
+	pruneStmt   *sql.Stmt
+
+	saveEmbeddingStmt   *sql.Stmt
+	fetchEmbeddingsStmt *sql.Stmt
+	unembeddedStmt      *sql.Stmt
+	aroundStmt          *sql.Stmt
+
+	markSeenStmt *sql.Stmt
+	markReadStmt *sql.Stmt
+	lastSeenStmt *sql.Stmt
+
+	saveScheduleStmt    *sql.Stmt
+	fetchSchedulesStmt  *sql.Stmt
+	deleteScheduleStmt  *sql.Stmt
+	markScheduleRunStmt *sql.Stmt
+
+	groupsStmt        *sql.Stmt
+	setSettingStmt    *sql.Stmt
+	deleteSettingStmt *sql.Stmt
+	fetchSettingsStmt *sql.Stmt
+
+	addAdminStmt         *sql.Stmt
+	removeAdminStmt      *sql.Stmt
+	fetchAdminsStmt      *sql.Stmt
+	setPermissionStmt    *sql.Stmt
+	deletePermissionStmt *sql.Stmt
+	fetchPermissionsStmt *sql.Stmt
+
+	recordUsageStmt *sql.Stmt
+	fetchUsageStmt  *sql.Stmt
+
+	fetchMessageStmt   *sql.Stmt
+	saveRevisionStmt   *sql.Stmt
+	editMessageStmt    *sql.Stmt
+	deleteMessageStmt  *sql.Stmt
+	saveReactionStmt   *sql.Stmt
+	removeReactionStmt *sql.Stmt
+
+	threadStmt *sql.Stmt
+}
+
+// openSQLiteStore opens the database at path, creating it if needed, and
+// brings its schema up to date.
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	// WAL lets readers carry on while a message is being written, and the busy
+	// timeout makes writers wait for each other rather than fail
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	s := &sqliteStore{db: db, timeout: dbTimeout}
+	if err := migrateDatabase(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	if s.fts, err = ensureSearchIndex(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create search index: %w", err)
+	}
+	if err := s.prepare(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteStore) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.insertStmt, "INSERT INTO messages (timestamp, sourceNumber, sourceName, message, groupId, mentions, attachments, replyToTimestamp, replyToAuthor, sourceUuid," +
+			" parentId, quoteAuthor, quoteText) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+		{&s.rangeStmt, "SELECT " + messageColumnList + " FROM messages WHERE groupId = ? AND timestamp >= ? AND (? = 0 OR timestamp < ?) ORDER BY timestamp ASC"},
+		{&s.lastNStmt, "SELECT * FROM (SELECT " + messageColumnList + " FROM messages WHERE groupId = ? ORDER BY timestamp DESC LIMIT ?) ORDER BY timestamp ASC"},
+		{&s.historyStmt, `WITH relevant AS (
+    SELECT ` + messageColumnList + ` FROM messages
+    WHERE groupId = ?
+      AND (
+          sourceName = ?
+          OR EXISTS (
+              SELECT 1 FROM json_each(messages.mentions)
+              WHERE json_extract(json_each.value, '$.name') = ?
+                 OR json_extract(json_each.value, '$.number') = ?
+          )
+          OR message LIKE '%' || ? || '%'
+      )
+    ORDER BY timestamp DESC
+    LIMIT ?
+), recent AS (
+    SELECT ` + messageColumnList + ` FROM messages
+    WHERE groupId = ?
+    ORDER BY timestamp DESC
+    LIMIT ?
+)
+SELECT * FROM (
+    SELECT * FROM relevant
+    UNION
+    SELECT * FROM recent
+)
+ORDER BY timestamp ASC`},
+		{&s.searchStmt, searchQuery(s.fts)},
+		{&s.pruneStmt, "DELETE FROM messages WHERE timestamp < ? AND (? = '' OR groupId = ?)"},
+		{&s.saveEmbeddingStmt, "INSERT OR REPLACE INTO message_embeddings (message_id, model, vector) VALUES (?, ?, ?)"},
+		{&s.fetchEmbeddingsStmt, "SELECT m.id, m.timestamp, e.vector FROM message_embeddings e JOIN messages m ON m.id = e.message_id WHERE m.groupId = ? AND e.model = ?"},
+		{&s.unembeddedStmt, "SELECT " + messageColumnList + " FROM messages WHERE message != '' AND message NOT LIKE '!%' AND replyToTimestamp IS NULL" +
+			" AND id NOT IN (SELECT message_id FROM message_embeddings WHERE model = ?) ORDER BY timestamp DESC LIMIT ?"},
+		{&s.aroundStmt, "SELECT * FROM (SELECT " + messageColumnList + " FROM messages WHERE groupId = ? AND timestamp < ? ORDER BY timestamp DESC LIMIT ?)" +
+			" UNION SELECT * FROM (SELECT " + messageColumnList + " FROM messages WHERE groupId = ? AND timestamp >= ? ORDER BY timestamp ASC LIMIT ?)" +
+			" ORDER BY timestamp ASC"},
+		{&s.markSeenStmt, "INSERT INTO member_last_seen (groupId, member, timestamp) VALUES (?, ?, ?)" +
+			" ON CONFLICT (groupId, member) DO UPDATE SET timestamp = max(timestamp, excluded.timestamp)"},
+		{&s.markReadStmt, "INSERT INTO member_last_seen (groupId, member, timestamp) SELECT DISTINCT groupId, ?, timestamp FROM messages WHERE timestamp = ?" +
+			" ON CONFLICT (groupId, member) DO UPDATE SET timestamp = max(timestamp, excluded.timestamp)"},
+		{&s.lastSeenStmt, "SELECT timestamp FROM member_last_seen WHERE groupId = ? AND member = ?"},
+		{&s.saveScheduleStmt, "INSERT INTO schedules (groupId, spec, args, createdBy, createdAt) VALUES (?, ?, ?, ?, ?)"},
+		{&s.fetchSchedulesStmt, "SELECT id, groupId, spec, args, createdBy, createdAt, lastRun FROM schedules WHERE (? = '' OR groupId = ?) ORDER BY id ASC"},
+		{&s.deleteScheduleStmt, "DELETE FROM schedules WHERE groupId = ? AND id = ?"},
+		{&s.markScheduleRunStmt, "UPDATE schedules SET lastRun = ? WHERE id = ?"},
+		{&s.groupsStmt, "SELECT DISTINCT groupId FROM messages"},
+		{&s.setSettingStmt, "INSERT INTO group_settings (groupId, key, value, updatedBy, updatedAt) VALUES (?, ?, ?, ?, ?)" +
+			" ON CONFLICT (groupId, key) DO UPDATE SET value = excluded.value, updatedBy = excluded.updatedBy, updatedAt = excluded.updatedAt"},
+		{&s.deleteSettingStmt, "DELETE FROM group_settings WHERE groupId = ? AND key = ?"},
+		{&s.fetchSettingsStmt, "SELECT key, value FROM group_settings WHERE groupId = ?"},
+		{&s.addAdminStmt, "INSERT INTO group_admins (groupId, member, name, addedBy, addedAt) VALUES (?, ?, ?, ?, ?)" +
+			" ON CONFLICT (groupId, member) DO UPDATE SET name = coalesce(excluded.name, name)"},
+		{&s.removeAdminStmt, "DELETE FROM group_admins WHERE groupId = ? AND member = ?"},
+		{&s.fetchAdminsStmt, "SELECT member, name FROM group_admins WHERE groupId = ? ORDER BY addedAt ASC, member ASC"},
+		{&s.setPermissionStmt, "INSERT INTO command_permissions (groupId, command, level, members, updatedBy, updatedAt) VALUES (?, ?, ?, ?, ?, ?)" +
+			" ON CONFLICT (groupId, command) DO UPDATE SET level = excluded.level, members = excluded.members," +
+			" updatedBy = excluded.updatedBy, updatedAt = excluded.updatedAt"},
+		{&s.deletePermissionStmt, "DELETE FROM command_permissions WHERE groupId = ? AND command = ?"},
+		{&s.fetchPermissionsStmt, "SELECT command, level, members FROM command_permissions WHERE groupId = ?"},
+		{&s.recordUsageStmt, "INSERT INTO usage (timestamp, groupId, member, command, provider, model, inputTokens, outputTokens, images, cost)" +
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"},
+		{&s.fetchUsageStmt, "SELECT groupId, member, command, sum(inputTokens), sum(outputTokens), sum(images), sum(cost) FROM usage" +
+			" WHERE (? = '' OR groupId = ?) AND (? = '' OR member = ?) AND timestamp >= ? GROUP BY groupId, member, command ORDER BY groupId, member, command"},
+		// Older rows have no sourceUuid, and members can hide their number, so
+		// only an identifier both sides have is compared
+		{&s.fetchMessageStmt, "SELECT " + messageColumnList + " FROM messages WHERE groupId = ? AND timestamp = ?" +
+			" AND (? = '' OR coalesce(sourceUuid, '') = '' OR sourceUuid = ?)" +
+			" AND (? = '' OR coalesce(sourceNumber, '') = '' OR sourceNumber = ?) ORDER BY id ASC LIMIT 1"},
+		{&s.saveRevisionStmt, "INSERT INTO message_revisions (messageId, message, replacedAt) SELECT id, message, ? FROM messages WHERE id = ?"},
+		{&s.editMessageStmt, "UPDATE messages SET message = ?, editedAt = ? WHERE id = ?"},
+		{&s.deleteMessageStmt, "DELETE FROM messages WHERE id = ?"},
+		{&s.saveReactionStmt, "INSERT INTO message_reactions (messageId, member, name, emoji, timestamp) VALUES (?, ?, ?, ?, ?)" +
+			" ON CONFLICT (messageId, member) DO UPDATE SET name = excluded.name, emoji = excluded.emoji, timestamp = excluded.timestamp"},
+		{&s.removeReactionStmt, "DELETE FROM message_reactions WHERE messageId = ? AND member = ?"},
+		{&s.threadStmt, "SELECT " + messageColumnList + " FROM messages WHERE groupId = ?" +
+			" AND (id = (SELECT parentId FROM messages WHERE id = ?) OR parentId = ?) ORDER BY timestamp ASC"},
+	}
+	for _, statement := range statements {
+		stmt, err := s.db.Prepare(statement.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement %q: %w", statement.query, err)
+		}
+		*statement.stmt = stmt
+	}
+	return nil
+}
+
+func (s *sqliteStore) Close() error {
+	for _, stmt := range []*sql.Stmt{s.insertStmt, s.rangeStmt, s.lastNStmt, s.historyStmt, s.searchStmt, s.pruneStmt,
+		s.saveEmbeddingStmt, s.fetchEmbeddingsStmt, s.unembeddedStmt, s.aroundStmt,
+		s.markSeenStmt, s.markReadStmt, s.lastSeenStmt,
+		s.saveScheduleStmt, s.fetchSchedulesStmt, s.deleteScheduleStmt, s.markScheduleRunStmt,
+		s.groupsStmt, s.setSettingStmt, s.deleteSettingStmt, s.fetchSettingsStmt,
+		s.addAdminStmt, s.removeAdminStmt, s.fetchAdminsStmt, s.setPermissionStmt, s.deletePermissionStmt, s.fetchPermissionsStmt,
+		s.recordUsageStmt, s.fetchUsageStmt,
+		s.fetchMessageStmt, s.saveRevisionStmt, s.editMessageStmt, s.deleteMessageStmt, s.saveReactionStmt, s.removeReactionStmt,
+		s.threadStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return s.db.Close()
+}
+
+func (s *sqliteStore) SaveMessage(ctx context.Context, msg *StoredMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if msg.GroupId == "" {
+		return errors.New("a groupId must be provided")
+	}
+	// Always store a JSON array, even when there's nothing in it
+	mentions := msg.Mentions
+	if mentions == nil {
+		mentions = []Mention{}
+	}
+	mentionsJson, err := json.Marshal(mentions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mentions: %w", err)
+	}
+	attachments := msg.Attachments
+	if attachments == nil {
+		attachments = []string{}
+	}
+	attachmentsJson, err := json.Marshal(attachments)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attachments: %w", err)
+	}
+
+	res, err := s.insertStmt.ExecContext(ctx,
+		msg.Timestamp, nullString(msg.SourceNumber), msg.SourceName, msg.Message, msg.GroupId,
+		string(mentionsJson), string(attachmentsJson),
+		nullInt64(msg.ReplyToTimestamp), nullString(msg.ReplyToAuthor), nullString(msg.SourceUuid),
+		nullInt64(msg.ParentId), nullString(msg.QuoteAuthor), nullString(msg.QuoteText))
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	msg.Id, err = res.LastInsertId()
+	return err
+}
+
+func (s *sqliteStore) FetchRange(ctx context.Context, groupId string, start int64, end int64) ([]StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	return s.query(ctx, s.rangeStmt, groupId, start, end, end)
+}
+
+func (s *sqliteStore) FetchLastN(ctx context.Context, groupId string, n int) ([]StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	return s.query(ctx, s.lastNStmt, groupId, n)
+}
+
+func (s *sqliteStore) FetchChatHistory(ctx context.Context, groupId string, botName string, botNumber string, limit int) ([]StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	return s.query(ctx, s.historyStmt,
+		groupId, botName, botName, botNumber, botName, limit,
+		groupId, limit)
+}
+
+func (s *sqliteStore) Search(ctx context.Context, groupId string, opts SearchOptions) ([]StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	if len(opts.Terms) == 0 {
+		return nil, errors.New("at least one search term must be provided")
+	}
+	var match interface{}
+	if s.fts {
+		match = ftsMatchExpression(opts.Terms)
+	} else {
+		termsJson, err := json.Marshal(opts.Terms)
+		if err != nil {
+			return nil, err
+		}
+		match = string(termsJson)
+	}
+	return s.query(ctx, s.searchStmt,
+		match, groupId, opts.Since, opts.Before, opts.Before, opts.Author, opts.Author, opts.Limit)
+}
+
+func (s *sqliteStore) Prune(ctx context.Context, groupId string, before int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	res, err := s.pruneStmt.ExecContext(ctx, before, groupId, groupId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune messages: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) SaveEmbedding(ctx context.Context, messageId int64, model string, vector []float32) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if _, err := s.saveEmbeddingStmt.ExecContext(ctx, messageId, model, encodeVector(vector)); err != nil {
+		return fmt.Errorf("failed to save embedding: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) FetchEmbeddings(ctx context.Context, groupId string, model string) ([]MessageEmbedding, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	rows, err := s.fetchEmbeddingsStmt.QueryContext(ctx, groupId, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	var embeddings []MessageEmbedding
+	for rows.Next() {
+		var embedding MessageEmbedding
+		var timestamp sql.NullInt64
+		var vector []byte
+		if err := rows.Scan(&embedding.MessageId, &timestamp, &vector); err != nil {
+			return nil, fmt.Errorf("failed to read embedding: %w", err)
+		}
+		embedding.Timestamp = timestamp.Int64
+		if embedding.Vector, err = decodeVector(vector); err != nil {
+			return nil, fmt.Errorf("failed to read embedding for message %d: %w", embedding.MessageId, err)
+		}
+		embeddings = append(embeddings, embedding)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read embeddings: %w", err)
+	}
+	return embeddings, nil
+}
+
+func (s *sqliteStore) FetchUnembedded(ctx context.Context, model string, limit int) ([]StoredMessage, error) {
+	return s.query(ctx, s.unembeddedStmt, model, limit)
+}
+
+func (s *sqliteStore) FetchAround(ctx context.Context, groupId string, timestamp int64, n int) ([]StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	// The message itself is the first of the n+1 messages from timestamp onwards
+	return s.query(ctx, s.aroundStmt, groupId, timestamp, n, groupId, timestamp, n+1)
+}
+
+func (s *sqliteStore) MarkSeen(ctx context.Context, groupId string, member string, timestamp int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" || member == "" {
+		return errors.New("a groupId and member must be provided")
+	}
+	if _, err := s.markSeenStmt.ExecContext(ctx, groupId, member, timestamp); err != nil {
+		return fmt.Errorf("failed to mark member as seen: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) MarkRead(ctx context.Context, member string, timestamps []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if member == "" {
+		return errors.New("a member must be provided")
+	}
+	for _, timestamp := range timestamps {
+		if _, err := s.markReadStmt.ExecContext(ctx, member, timestamp); err != nil {
+			return fmt.Errorf("failed to mark messages as read: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) LastSeen(ctx context.Context, groupId string, member string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var timestamp int64
+	err := s.lastSeenStmt.QueryRowContext(ctx, groupId, member).Scan(&timestamp)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to fetch last seen time: %w", err)
+	}
+	return timestamp, nil
+}
+
+func (s *sqliteStore) SaveSchedule(ctx context.Context, schedule *Schedule) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if schedule.GroupId == "" {
+		return errors.New("a groupId must be provided")
+	}
+	res, err := s.saveScheduleStmt.ExecContext(ctx, schedule.GroupId, schedule.Spec, schedule.Args,
+		nullString(schedule.CreatedBy), schedule.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save schedule: %w", err)
+	}
+	schedule.Id, err = res.LastInsertId()
+	return err
+}
+
+func (s *sqliteStore) FetchSchedules(ctx context.Context, groupId string) ([]Schedule, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	rows, err := s.fetchSchedulesStmt.QueryContext(ctx, groupId, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var schedule Schedule
+		var createdBy sql.NullString
+		err := rows.Scan(&schedule.Id, &schedule.GroupId, &schedule.Spec, &schedule.Args,
+			&createdBy, &schedule.CreatedAt, &schedule.LastRun)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schedule: %w", err)
+		}
+		schedule.CreatedBy = createdBy.String
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+func (s *sqliteStore) DeleteSchedule(ctx context.Context, groupId string, id int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" {
+		return false, errors.New("a groupId must be provided")
+	}
+	res, err := s.deleteScheduleStmt.ExecContext(ctx, groupId, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	return deleted > 0, err
+}
+
+func (s *sqliteStore) MarkScheduleRun(ctx context.Context, id int64, timestamp int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if _, err := s.markScheduleRunStmt.ExecContext(ctx, timestamp, id); err != nil {
+		return fmt.Errorf("failed to mark schedule as run: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) FetchGroups(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	rows, err := s.groupsStmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var groupId string
+		if err := rows.Scan(&groupId); err != nil {
+			return nil, fmt.Errorf("failed to read group: %w", err)
+		}
+		groups = append(groups, groupId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read groups: %w", err)
+	}
+	return groups, nil
+}
+
+func (s *sqliteStore) SetGroupSetting(ctx context.Context, groupId string, key string, value string, updatedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" || key == "" {
+		return errors.New("a groupId and key must be provided")
+	}
+	var err error
+	if value == "" {
+		_, err = s.deleteSettingStmt.ExecContext(ctx, groupId, key)
+	} else {
+		_, err = s.setSettingStmt.ExecContext(ctx, groupId, key, value, nullString(updatedBy), time.Now().UnixMilli())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save setting: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) FetchGroupSettings(ctx context.Context, groupId string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	rows, err := s.fetchSettingsStmt.QueryContext(ctx, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query settings: %w", err)
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to read setting: %w", err)
+		}
+		settings[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read settings: %w", err)
+	}
+	return settings, nil
+}
+
+func (s *sqliteStore) AddGroupAdmin(ctx context.Context, groupId string, member GroupMember, addedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" || member.Id == "" {
+		return errors.New("a groupId and member must be provided")
+	}
+	_, err := s.addAdminStmt.ExecContext(ctx, groupId, member.Id, nullString(member.Name), nullString(addedBy), time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to add admin: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) RemoveGroupAdmin(ctx context.Context, groupId string, memberId string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" {
+		return false, errors.New("a groupId must be provided")
+	}
+	res, err := s.removeAdminStmt.ExecContext(ctx, groupId, memberId)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove admin: %w", err)
+	}
+	removed, err := res.RowsAffected()
+	return removed > 0, err
+}
+
+func (s *sqliteStore) FetchGroupAdmins(ctx context.Context, groupId string) ([]GroupMember, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	rows, err := s.fetchAdminsStmt.QueryContext(ctx, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admins: %w", err)
+	}
+	defer rows.Close()
+
+	var admins []GroupMember
+	for rows.Next() {
+		var admin GroupMember
+		var name sql.NullString
+		if err := rows.Scan(&admin.Id, &name); err != nil {
+			return nil, fmt.Errorf("failed to read admin: %w", err)
+		}
+		admin.Name = name.String
+		admins = append(admins, admin)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read admins: %w", err)
+	}
+	return admins, nil
+}
+
+func (s *sqliteStore) SetCommandPermission(ctx context.Context, groupId string, command string, permission CommandPermission, updatedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" || command == "" {
+		return errors.New("a groupId and command must be provided")
+	}
+	var err error
+	if permission.Level == "" {
+		_, err = s.deletePermissionStmt.ExecContext(ctx, groupId, command)
+	} else {
+		members := permission.Members
+		if members == nil {
+			members = []GroupMember{}
+		}
+		membersJson, jsonErr := json.Marshal(members)
+		if jsonErr != nil {
+			return fmt.Errorf("failed to marshal members: %w", jsonErr)
+		}
+		_, err = s.setPermissionStmt.ExecContext(ctx, groupId, command, string(permission.Level), string(membersJson),
+			nullString(updatedBy), time.Now().UnixMilli())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save permission: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) FetchCommandPermissions(ctx context.Context, groupId string) (map[string]CommandPermission, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	rows, err := s.fetchPermissionsStmt.QueryContext(ctx, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := map[string]CommandPermission{}
+	for rows.Next() {
+		var command, level, members string
+		if err := rows.Scan(&command, &level, &members); err != nil {
+			return nil, fmt.Errorf("failed to read permission: %w", err)
+		}
+		permission := CommandPermission{Level: PermissionLevel(level)}
+		if err := json.Unmarshal([]byte(members), &permission.Members); err != nil {
+			return nil, fmt.Errorf("failed to read members allowed to run %s: %w", command, err)
+		}
+		permissions[command] = permission
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+func (s *sqliteStore) RecordUsage(ctx context.Context, usage UsageRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if usage.Timestamp == 0 {
+		usage.Timestamp = time.Now().UnixMilli()
+	}
+	_, err := s.recordUsageStmt.ExecContext(ctx, usage.Timestamp, usage.GroupId, usage.Member, usage.Command, usage.Provider,
+		nullString(usage.Model), usage.InputTokens, usage.OutputTokens, usage.Images, usage.Cost)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) FetchUsage(ctx context.Context, groupId string, member string, since int64) ([]UsageRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	rows, err := s.fetchUsageStmt.QueryContext(ctx, groupId, groupId, member, member, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	var totals []UsageRecord
+	for rows.Next() {
+		var total UsageRecord
+		err := rows.Scan(&total.GroupId, &total.Member, &total.Command, &total.InputTokens, &total.OutputTokens, &total.Images, &total.Cost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read usage: %w", err)
+		}
+		totals = append(totals, total)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	return totals, nil
+}
+
+func (s *sqliteStore) FetchMessage(ctx context.Context, groupId string, timestamp int64, authorUuid string, authorNumber string) (*StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	messages, err := s.query(ctx, s.fetchMessageStmt, groupId, timestamp, authorUuid, authorUuid, authorNumber, authorNumber)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+func (s *sqliteStore) FetchThread(ctx context.Context, groupId string, id int64) ([]StoredMessage, error) {
+	if groupId == "" {
+		return nil, errors.New("a groupId must be provided")
+	}
+	return s.query(ctx, s.threadStmt, groupId, id, id)
+}
+
+func (s *sqliteStore) EditMessage(ctx context.Context, id int64, message string, editedAt int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Keep the old text and replace it together, so a revision is never lost or duplicated
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.StmtContext(ctx, s.saveRevisionStmt).ExecContext(ctx, editedAt, id); err != nil {
+		return fmt.Errorf("failed to save revision: %w", err)
+	}
+	if _, err := tx.StmtContext(ctx, s.editMessageStmt).ExecContext(ctx, message, editedAt, id); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) DeleteMessage(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	// Triggers remove everything else kept about the message
+	if _, err := s.deleteMessageStmt.ExecContext(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) SaveReaction(ctx context.Context, messageId int64, member string, name string, emoji string, timestamp int64) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if member == "" || emoji == "" {
+		return errors.New("a member and emoji must be provided")
+	}
+	if _, err := s.saveReactionStmt.ExecContext(ctx, messageId, member, nullString(name), emoji, timestamp); err != nil {
+		return fmt.Errorf("failed to save reaction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) RemoveReaction(ctx context.Context, messageId int64, member string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if _, err := s.removeReactionStmt.ExecContext(ctx, messageId, member); err != nil {
+		return fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) query(ctx context.Context, stmt *sql.Stmt, args ...interface{}) ([]StoredMessage, error) {
+	// Run a query selecting messageColumnList and read every row before returning,
+	// so no *sql.Rows outlive the call
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []StoredMessage
+	for rows.Next() {
+		var msg StoredMessage
+		var timestamp, replyToTimestamp, editedAt, parentId sql.NullInt64
+		var sourceNumber, sourceName, mentions, attachments, replyToAuthor, sourceUuid, quoteAuthor, quoteText sql.NullString
+		var reactions string
+		err := rows.Scan(&msg.Id, &timestamp, &sourceNumber, &sourceName, &msg.Message, &msg.GroupId,
+			&mentions, &attachments, &replyToTimestamp, &replyToAuthor, &sourceUuid, &editedAt, &reactions,
+			&parentId, &quoteAuthor, &quoteText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		msg.Timestamp = timestamp.Int64
+		msg.SourceNumber = sourceNumber.String
+		msg.SourceName = sourceName.String
+		msg.ReplyToTimestamp = replyToTimestamp.Int64
+		msg.ReplyToAuthor = replyToAuthor.String
+		msg.SourceUuid = sourceUuid.String
+		msg.EditedAt = editedAt.Int64
+		msg.ParentId = parentId.Int64
+		msg.QuoteAuthor = quoteAuthor.String
+		msg.QuoteText = quoteText.String
+		if err := json.Unmarshal([]byte(reactions), &msg.Reactions); err != nil {
+			return nil, fmt.Errorf("failed to read reactions: %w", err)
+		}
+		// Older rows may have "null" or nothing at all in these columns
+		if mentions.Valid {
+			json.Unmarshal([]byte(mentions.String), &msg.Mentions)
+		}
+		if attachments.Valid {
+			json.Unmarshal([]byte(attachments.String), &msg.Attachments)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	return messages, nil
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt64(i int64) interface{} {
+	if i == 0 {
+		return nil
+	}
+	return i
+}
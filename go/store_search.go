@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// Full-text search uses an FTS5 index over the messages table. FTS5 is only
+// compiled into go-sqlite3 when building with -tags sqlite_fts5, so the index
+// is created here rather than in a migration, and searches fall back to a
+// slower substring match when it isn't available.
+//
+// The index is external content, it stores only the index and reads the text
+// from messages. Triggers keep it in step with every insert, update and delete,
+// including the ones made by Prune.
+var searchIndexSchema = []string{
+	"CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(message, content='messages', content_rowid='id')",
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts(rowid, message) VALUES (new.id, new.message);
+END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+    INSERT INTO messages_fts(messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF message ON messages BEGIN
+    INSERT INTO messages_fts(messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+    INSERT INTO messages_fts(rowid, message) VALUES (new.id, new.message);
+END`,
+}
+
+func ensureSearchIndex(db *sql.DB) (bool, error) {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return false, err
+	}
+	if !enabled {
+		log.Println("SQLite was built without FTS5, searches will be slower")
+		return false, nil
+	}
+
+	// If the index is new, fill it with the messages we already have
+	var exists int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'messages_fts'").Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	for _, statement := range searchIndexSchema {
+		if _, err := db.Exec(statement); err != nil {
+			return false, err
+		}
+	}
+	if exists == 0 {
+		if _, err := db.Exec("INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')"); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func searchQuery(fts bool) string {
+	// The first parameter is the FTS match expression, or without FTS a JSON
+	// array of terms which must all appear in the message
+	match := "id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)"
+	if !fts {
+		match = "NOT EXISTS (SELECT 1 FROM json_each(?) WHERE instr(lower(messages.message), lower(json_each.value)) = 0)"
+	}
+	return "SELECT " + messageColumnList + " FROM messages WHERE " + match +
+		" AND groupId = ? AND timestamp >= ? AND (? = 0 OR timestamp < ?)" +
+		" AND (? = '' OR sourceName LIKE '%' || ? || '%')" +
+		" ORDER BY timestamp DESC LIMIT ?"
+}
+
+func ftsMatchExpression(terms []string) string {
+	// Quote every term so punctuation in it, like the dots in a URL, is
+	// searched for rather than parsed as FTS query syntax
+	var quoted []string
+	for _, term := range terms {
+		quoted = append(quoted, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
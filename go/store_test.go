@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestStore opens a fresh, fully migrated database which is removed when the test ends
+func newTestStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	store, err := openSQLiteStore(filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatalf("Failed to open test store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStoreSaveAndFetch(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	saved := []StoredMessage{
+		{Timestamp: 1000, SourceName: "Alice", SourceNumber: "+1111", Message: "first", GroupId: "groupOne",
+			Mentions: []Mention{{Name: "Bob", Number: "+2222"}}},
+		{Timestamp: 2000, SourceName: "Bob", Message: "second", GroupId: "groupOne"},
+		{Timestamp: 3000, SourceName: "Carol", Message: "other group", GroupId: "groupTwo"},
+		{Timestamp: 4000, SourceName: "Alice", Message: "third", GroupId: "groupOne",
+			Attachments: []string{"/tmp/image.png"}, ReplyToTimestamp: 2000, ReplyToAuthor: "bob-uuid"},
+	}
+	for i := range saved {
+		if err := store.SaveMessage(ctx, &saved[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if saved[i].Id == 0 {
+			t.Errorf("expected message %d to be given an id", i)
+		}
+	}
+	if err := store.SaveMessage(ctx, &StoredMessage{Message: "no group"}); err == nil {
+		t.Errorf("expected error saving a message without a group")
+	}
+
+	messages, err := store.FetchRange(ctx, "groupOne", 1500, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Message != "second" || messages[1].Message != "third" {
+		t.Errorf("unexpected range %+v", messages)
+	}
+	third := messages[1]
+	if len(third.Attachments) != 1 || third.ReplyToTimestamp != 2000 || third.ReplyToAuthor != "bob-uuid" {
+		t.Errorf("expected the attachment and reply to be kept, got %+v", third)
+	}
+
+	messages, err = store.FetchRange(ctx, "groupOne", 0, 2000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 || messages[0].SourceNumber != "+1111" || len(messages[0].Mentions) != 1 {
+		t.Errorf("unexpected range %+v", messages)
+	}
+
+	messages, err = store.FetchLastN(ctx, "groupOne", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Message != "second" || messages[1].Message != "third" {
+		t.Errorf("unexpected last messages %+v", messages)
+	}
+
+	messages, err = store.Search(ctx, "groupOne", SearchOptions{Terms: []string{"group"}, Limit: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("search must be scoped to the group, got %+v", messages)
+	}
+}
+
+func TestStoreReadsMessagesWithoutASender(t *testing.T) {
+	// Databases created before the schema was checked may have messages with no sourceName
+	path := filepath.Join(t.TempDir(), "messages.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = db.Exec("CREATE TABLE `messages` (`id` integer not null primary key autoincrement, " +
+		"`timestamp` UNSIGNED BIG INT null, `sourceNumber` TEXT null, `sourceName` TEXT null, " +
+		"`message` TEXT not null, `groupId` TEXT not null, `mentions` TEXT, " +
+		"`created_at` datetime not null default CURRENT_TIMESTAMP)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO messages (timestamp, message, groupId) VALUES (1, 'hello', 'groupOne')"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	db.Close()
+
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	messages, err := store.FetchRange(context.Background(), "groupOne", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Message != "hello" || messages[0].SourceName != "" {
+		t.Errorf("unexpected messages %+v", messages)
+	}
+}
+
+func TestStoreSearch(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	for _, msg := range []StoredMessage{
+		{Timestamp: 1000, SourceName: "Alice", Message: "Check out https://example.com/cats", GroupId: "groupOne"},
+		{Timestamp: 2000, SourceName: "Bob", Message: "I prefer dogs to cats", GroupId: "groupOne"},
+		{Timestamp: 3000, SourceName: "Alice", Message: "Cats are the best", GroupId: "groupOne"},
+		{Timestamp: 4000, SourceName: "Carol", Message: "cats in another group", GroupId: "groupTwo"},
+	} {
+		if err := store.SaveMessage(ctx, &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		opts     SearchOptions
+		expected []string
+	}{
+		{"newest first", SearchOptions{Terms: []string{"cats"}, Limit: 10},
+			[]string{"Cats are the best", "I prefer dogs to cats", "Check out https://example.com/cats"}},
+		{"every term", SearchOptions{Terms: []string{"cats", "dogs"}, Limit: 10},
+			[]string{"I prefer dogs to cats"}},
+		{"punctuation", SearchOptions{Terms: []string{"example.com"}, Limit: 10},
+			[]string{"Check out https://example.com/cats"}},
+		{"author", SearchOptions{Terms: []string{"cats"}, Author: "ali", Limit: 10},
+			[]string{"Cats are the best", "Check out https://example.com/cats"}},
+		{"since and before", SearchOptions{Terms: []string{"cats"}, Since: 1500, Before: 3000, Limit: 10},
+			[]string{"I prefer dogs to cats"}},
+		{"limit", SearchOptions{Terms: []string{"cats"}, Limit: 1},
+			[]string{"Cats are the best"}},
+	}
+	for _, test := range tests {
+		messages, err := store.Search(ctx, "groupOne", test.opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		var got []string
+		for _, msg := range messages {
+			got = append(got, msg.Message)
+		}
+		if strings.Join(got, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+
+	// Pruned messages must drop out of the index too
+	if _, err := store.Prune(ctx, "groupOne", 2500); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	messages, err := store.Search(ctx, "groupOne", SearchOptions{Terms: []string{"cats"}, Limit: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Errorf("expected 1 message after pruning, got %+v", messages)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	for _, msg := range []StoredMessage{
+		{Timestamp: 1000, SourceName: "Alice", Message: "old", GroupId: "groupOne"},
+		{Timestamp: 1000, SourceName: "Bob", Message: "old", GroupId: "groupTwo"},
+		{Timestamp: 5000, SourceName: "Alice", Message: "new", GroupId: "groupOne"},
+	} {
+		if err := store.SaveMessage(ctx, &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	removed, err := store.Prune(ctx, "groupOne", 2000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 message removed from groupOne, got %d", removed)
+	}
+	removed, err = store.Prune(ctx, "", 2000)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 message removed from every group, got %d", removed)
+	}
+}
+
+func TestStoreEmbeddings(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	var saved []StoredMessage
+	for i, text := range []string{"one", "two", "three", "four", "five"} {
+		msg := StoredMessage{Timestamp: int64(i+1) * 1000, SourceName: "Alice", Message: text, GroupId: "groupOne"}
+		if err := store.SaveMessage(ctx, &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		saved = append(saved, msg)
+	}
+
+	messages, err := store.FetchAround(ctx, "groupOne", 3000, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 3 || messages[0].Message != "two" || messages[2].Message != "four" {
+		t.Errorf("unexpected messages around three: %+v", messages)
+	}
+
+	for _, msg := range saved[:3] {
+		if err := store.SaveEmbedding(ctx, msg.Id, "model", []float32{float32(msg.Id), 1}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	unembedded, err := store.FetchUnembedded(ctx, "model", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(unembedded) != 2 || unembedded[0].Message != "five" {
+		t.Errorf("unexpected unembedded messages: %+v", unembedded)
+	}
+
+	// Pruning a message removes its embedding too
+	if _, err := store.Prune(ctx, "groupOne", 2000); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	embeddings, err := store.FetchEmbeddings(ctx, "groupOne", "model")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(embeddings) != 2 || embeddings[0].Vector[0] != float32(saved[1].Id) {
+		t.Errorf("unexpected embeddings after pruning: %+v", embeddings)
+	}
+	var orphans int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM message_embeddings WHERE message_id = ?", saved[0].Id).Scan(&orphans); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if orphans != 0 {
+		t.Errorf("expected the pruned message's embedding to be removed")
+	}
+}
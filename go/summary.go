@@ -1,11 +1,13 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -21,87 +23,266 @@ func getSummaryPromptFromFile() string {
 	return string(prompt)
 }
 
-func (c *TimeCountCalculator) calculateStarttimeAndCount(words []string) (int, int, error) {
-	// Calculates the starttime and message count requested from `words`
+// summaryArgs are the parsed arguments to !summary
+type summaryArgs struct {
+	Range         rangeSpec // The time range to summarize, nil when summarizing a count
+	Count         int       // How many of the latest messages to summarize
+	SinceMe       bool      // Summarize from when the requester was last here
+	DirectMessage bool      // Reply to the requester privately rather than in the group
+}
 
-	// c.StartTime is used for testing. If it's -1, set a new default start time.
-	if c.StartTime == -1 {
-		c.StartTime = int(time.Now().Add(-time.Duration(24)*time.Hour).Unix() * 1000)
-	}
+func (a summaryArgs) PrivateReply() bool {
+	return a.DirectMessage
+}
 
-	// If no arguments are given, return the default start time and 0 count
-	if len(words) < 2 {
-		return c.StartTime, c.Count, nil
+// summaryDefaultWindow is what !summary covers without any arguments
+const summaryDefaultWindow = 24 * time.Hour
+
+func parseSummaryArgs(name string, args []string) (interface{}, error) {
+	// "dm" can go anywhere, the rest is the time range or count
+	var parsed summaryArgs
+	var words []string
+	for _, arg := range args {
+		if strings.EqualFold(arg, "dm") {
+			parsed.DirectMessage = true
+			continue
+		}
+		words = append(words, arg)
+	}
+	if len(words) == 0 {
+		parsed.Range = lastDuration(summaryDefaultWindow)
+		return parsed, nil
+	}
+	if len(words) == 1 && strings.EqualFold(words[0], "since-me") {
+		parsed.SinceMe = true
+		return parsed, nil
 	}
 
-	// See if the first argument has a number
-	number, err := getNumberFromString(words[1])
+	// A number on its own is a count of messages
+	if len(words) == 1 {
+		if count, err := strconv.Atoi(words[0]); err == nil {
+			if count <= 0 {
+				return nil, fmt.Errorf("the number of messages must be more than zero")
+			}
+			parsed.Count = count
+			return parsed, nil
+		}
+	}
+	window, err := parseTimeRange(words)
 	if err != nil {
-		// If not, return the default start time and 0 count
-		return 0, 0, errors.New("Invalid argument to summary: " + words[1])
+		return nil, err
 	}
+	parsed.Range = window
+	return parsed, nil
+}
 
-	// If the first argument ends in "h", return that many hours as starttime and a zero count
-	if strings.HasSuffix(words[1], "h") {
-		return int(time.Now().Add(-time.Duration(number)*time.Hour).Unix() * 1000), 0, nil
-	}
-	// Otherwise, return zero for the start time and the requested count
-	return 0, number, nil
+func init() {
+	Commands.Register(&Command{
+		Name:        "summary",
+		Usage:       "<num_msgs|time range|since-me> [dm]",
+		Description: "Generate a summary of the last N messages, or a time range",
+		Slow:        true,
+		GroupTarget: true,
+		ParseArgs:   parseSummaryArgs,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			a := args.(summaryArgs)
+			if a.SinceMe {
+				return ctx.catchupCommand(req)
+			}
+			var window TimeRange
+			if a.Range != nil {
+				window = a.Range(time.Now().In(ctx.groupLocation(req.TraceContext, req.GroupId)))
+			}
+			return ctx.summaryCommand(req, window, a.Count, "")
+		},
+	})
 }
 
-func (ctx *AppContext) summaryCommand(starttime int, count int, sourceName string, prompt string) {
+const (
+	// summaryReplyTokens is kept free in every request for the model's reply
+	summaryReplyTokens = 4096
+	// maxSummaryChunkTokens caps the size of each chunk. Models with huge
+	// context windows still summarize better when given less at once.
+	maxSummaryChunkTokens = 100000
+	// summaryParallelism is how many chunks are summarized at the same time
+	summaryParallelism = 3
+)
+
+// chunkSummaryPrompt asks for notes on one part of a log which is too long to summarize at once
+const chunkSummaryPrompt = "The following is part %d of %d of a group chat log. " +
+	"Write detailed notes on what was discussed in this part: the topics, who said what, " +
+	"and any links, decisions or plans. The notes will be combined with notes on the other parts " +
+	"to write a summary, so don't leave anything important out."
+
+// combineSummaryPrompt introduces the notes on each part of the log in the final request
+const combineSummaryPrompt = "The chat log was too long to send at once, so here are notes on each part of it, in order:"
+
+func (ctx *AppContext) summaryCommand(req *RequestContext, window TimeRange, count int, prompt string) error {
 	// Start a new span
 	tracer := otel.Tracer("signal-bot")
-	_, span := tracer.Start(ctx.TraceContext, "summaryCommand")
+	summaryCtx, span := tracer.Start(req.TraceContext, "summaryCommand")
 	defer span.End()
 
-	var summary string
-	// Generate a summary of the last N messages or last H hours
+	// Generate a summary of the last N messages or a time range
 	// and send it to the send channel
-	fmt.Printf("Generating summary for %s: hours: %d, count: %d\n", sourceName, starttime, count)
+	start, end := window.Millis()
+	fmt.Printf("Generating summary for %s in %s: start: %d, end: %d, count: %d\n", req.SourceName, req.GroupId, start, end, count)
+
+	messages, err := ctx.fetchLogsFromDB(summaryCtx, req.GroupId, window, count)
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't fetch the messages to summarize: "+err.Error(), "")
+		return fmt.Errorf("failed to fetch logs: %w", err)
+	}
+	if len(messages) == 0 {
+		ctx.MessagePoster(req, "There are no messages to summarize in that time range.", "")
+		return nil
+	}
+	return ctx.postSummary(summaryCtx, req, messages, prompt)
+}
 
-	rows, err := ctx.fetchLogsFromDB(starttime, count)
+func (ctx *AppContext) postSummary(summaryCtx context.Context, req *RequestContext, messages []StoredMessage, prompt string) error {
+	// Summarize messages that have already been fetched, and reply with the summary
+	chatLog, err := compileLogs(messages)
 	if err != nil {
-		log.Println("Failed to fetch logs:", err)
-		return
+		return fmt.Errorf("failed to compile logs: %w", err)
 	}
 
-	chatLog, err := compileLogs(rows)
+	// Use the given prompt, or the group's own, or read from a file if neither is provided
+	if prompt == "" {
+		prompt = ctx.groupConfig(summaryCtx, req.GroupId, "summary_prompt")
+	}
+	if prompt == "" {
+		prompt = getSummaryPromptFromFile()
+	}
+	prompt += mostReactedPrompt(messages)
+	prompt += ctx.languageInstruction(summaryCtx, req.GroupId)
+	summary, err := ctx.summarizeMessages(summaryCtx, req, prompt, chatLog, messages)
 	if err != nil {
-		log.Println("Failed to compile logs:", err)
-		return
+		log.Println("Failed to generate summary:", err)
+		ctx.MessagePoster(req, "Failed to generate summary: "+err.Error(), "")
+		return err
 	}
 
-	switch Config["SUMMARY_PROVIDER"] {
-	case "google":
-		summary, err = ctx.summaryGoogle(chatLog, prompt)
+	ctx.postLongMessage(req, summary)
+	return nil
+}
+
+func (ctx *AppContext) summarizeMessages(traceCtx context.Context, req *RequestContext, prompt string, chatLog string, messages []StoredMessage) (string, error) {
+	// Summarize the chat log in one request if it fits in the model's context
+	// window. Otherwise split the messages into chunks, summarize each chunk,
+	// then summarize the partial summaries.
+	provider := ctx.summaryProvider(traceCtx, req.GroupId)
+	budget := summaryChunkBudget(provider, prompt)
+	if budget <= 0 {
+		return "", fmt.Errorf("the prompt is too long for %s", provider.Name())
+	}
+	if provider.EstimateTokens(chatLog) <= budget {
+		resp, err := provider.Generate(traceCtx, textRequest(prompt+"\n"+chatLog))
 		if err != nil {
-			log.Println("Failed to generate summary:", err)
-			ctx.MessagePoster("Failed to generate summary: "+err.Error(), "")
-			return
+			return "", err
 		}
-	case "openai":
-		summary, err = summaryOpenai(chatLog, prompt)
-		if err != nil {
-			log.Println("Failed to generate summary:", err)
-			ctx.MessagePoster("Failed to generate summary: "+err.Error(), "")
-			return
+		return resp.Text, nil
+	}
+
+	var lines []string
+	for _, msg := range messages {
+		lines = append(lines, formatLogLine(msg))
+	}
+	chunks := chunkLines(lines, budget, provider.EstimateTokens)
+
+	// This will take a while, so let the group know we're working on it
+	ctx.MessagePoster(req, fmt.Sprintf("That's a lot of messages! Summarizing %d messages in %d parts, this may take a minute...", len(messages), len(chunks)), "")
+
+	notes, err := summarizeChunks(traceCtx, provider, chunks)
+	if err != nil {
+		return "", err
+	}
+
+	// If there's still too much to send at once, keep combining the notes until there isn't
+	for {
+		combined := combineSummaryPrompt + "\n\n" + strings.Join(notes, "\n\n")
+		if provider.EstimateTokens(combined) <= budget {
+			resp, err := provider.Generate(traceCtx, textRequest(prompt+"\n"+combined))
+			if err != nil {
+				return "", err
+			}
+			return resp.Text, nil
+		}
+		var sections []string
+		for _, note := range notes {
+			sections = append(sections, note+"\n\n")
+		}
+		chunks := chunkLines(sections, budget, provider.EstimateTokens)
+		if len(chunks) >= len(notes) {
+			return "", fmt.Errorf("the notes on each part are too long for %s to combine", provider.Name())
 		}
-	case "claude":
-		summary, err = ctx.summaryClaude(chatLog, prompt)
+		if notes, err = summarizeChunks(traceCtx, provider, chunks); err != nil {
+			return "", err
+		}
+	}
+}
+
+func summarizeChunks(traceCtx context.Context, provider LLMProvider, chunks []string) ([]string, error) {
+	// Summarize each chunk, a few at a time, keeping the notes in order
+	notes := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+	limit := make(chan struct{}, summaryParallelism)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			prompt := fmt.Sprintf(chunkSummaryPrompt, i+1, len(chunks))
+			resp, err := provider.Generate(traceCtx, textRequest(prompt+"\n"+chunk))
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to summarize part %d of %d: %w", i+1, len(chunks), err)
+				return
+			}
+			notes[i] = fmt.Sprintf("Part %d:\n%s", i+1, resp.Text)
+		}(i, chunk)
+	}
+	wg.Wait()
+	for _, err := range errs {
 		if err != nil {
-			log.Println("Failed to generate summary:", err)
-			ctx.MessagePoster("Failed to generate summary: "+err.Error(), "")
-			return
+			return nil, err
 		}
-	case "debug":
-		summary = fmt.Sprintf("DEBUG: Requested %d starttime, %d message count\n"+
-			"Chat log: %s", starttime, count, chatLog)
 	}
+	return notes, nil
+}
 
-	// Split the summary into chunks and call ctx.MessagePoster for each chunk
-	summaryChunks := splitLongMessage(summary)
-	for _, chunk := range summaryChunks {
-		ctx.MessagePoster(chunk, "")
+func summaryChunkBudget(provider LLMProvider, prompt string) int {
+	// How many tokens of chat log fit in each request, after the prompt, the
+	// chunk instructions and the model's reply
+	overhead := provider.EstimateTokens(prompt) + provider.EstimateTokens(chunkSummaryPrompt) + summaryReplyTokens
+	return min(provider.ContextWindow()-overhead, maxSummaryChunkTokens)
+}
+
+func chunkLines(lines []string, budget int, estimate func(string) int) []string {
+	// Group lines into chunks of at most budget tokens, never splitting a line
+	// unless it's too long to fit in a chunk on its own
+	var chunks []string
+	var current strings.Builder
+	used := 0
+	for _, line := range lines {
+		tokens := estimate(line)
+		if tokens > budget {
+			// Cut the line down to roughly what fits
+			runes := []rune(line)
+			line = string(runes[:len(runes)*budget/tokens])
+			tokens = estimate(line)
+		}
+		if used+tokens > budget && current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			used = 0
+		}
+		current.WriteString(line)
+		used += tokens
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
 	}
+	return chunks
 }
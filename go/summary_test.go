@@ -1,46 +1,124 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-func TestCalculateStarttimeAndCount(t *testing.T) {
-	c := TimeCountCalculator{
-		StartTime: int(time.Now().Add(-time.Duration(24)*time.Hour).Unix() * 1000),
-		Count:     0,
-	}
-	// Test case 1: No arguments
-	starttime, count, err := c.calculateStarttimeAndCount([]string{})
+func TestParseSummaryTimeRanges(t *testing.T) {
+	now := time.Date(2024, 12, 4, 15, 0, 0, 0, time.UTC)
+
+	// No arguments is the last 24 hours
+	args, err := parseSummaryArgs("summary", nil)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if starttime != c.StartTime || count != c.Count {
-		t.Errorf("expected starttime and count to be 0, got %d and %d", starttime, count)
+	parsed := args.(summaryArgs)
+	if parsed.Range == nil || parsed.Range(now).Start != now.Add(-24*time.Hour) {
+		t.Errorf("expected the last 24 hours, got %+v", parsed)
 	}
 
-	// Test case 2: Invalid argument
-	_, _, err = c.calculateStarttimeAndCount([]string{"!summary", "abc"})
-	if err == nil {
-		t.Error("expected error, got nil")
+	// A number is a count of messages
+	args, err = parseSummaryArgs("summary", []string{"10"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed = args.(summaryArgs)
+	if parsed.Count != 10 || parsed.Range != nil {
+		t.Errorf("expected a count of 10, got %+v", parsed)
 	}
 
-	// Test case 3: Hours argument
-	starttime, count, err = c.calculateStarttimeAndCount([]string{"!summary", "24h"})
+	// Anything else is a time range
+	args, err = parseSummaryArgs("summary", []string{"since", "9am"})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	expectedStarttime := int(time.Now().Add(-time.Duration(24)*time.Hour).Unix() * 1000)
-	if starttime != expectedStarttime || count != 0 {
-		t.Errorf("expected starttime %d and count 0, got %d and %d", expectedStarttime, starttime, count)
+	parsed = args.(summaryArgs)
+	if parsed.Range == nil || parsed.Range(now).Start != time.Date(2024, 12, 4, 9, 0, 0, 0, time.UTC) {
+		t.Errorf("expected since 9am, got %+v", parsed)
 	}
 
-	// Test case 4: Count argument
-	starttime, count, err = c.calculateStarttimeAndCount([]string{"!summary", "10"})
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+	for _, words := range [][]string{{"abc"}, {"0"}, {"25:00-26:00"}} {
+		if _, err := parseSummaryArgs("summary", words); err == nil {
+			t.Errorf("parseSummaryArgs(%q): expected an error", words)
+		}
+	}
+}
+
+// smallProvider is a provider with a tiny context window, which records every request
+type smallProvider struct {
+	mu      sync.Mutex
+	window  int
+	prompts []string
+}
+
+func (p *smallProvider) Name() string                   { return "small" }
+func (p *smallProvider) ContextWindow() int             { return p.window }
+func (p *smallProvider) EstimateTokens(text string) int { return estimateTokens(text, 4) }
+func (p *smallProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prompt := req.Messages[0].Content
+	if p.EstimateTokens(prompt) > p.window {
+		return nil, fmt.Errorf("request of %d tokens is too long", p.EstimateTokens(prompt))
+	}
+	p.prompts = append(p.prompts, prompt)
+	if strings.HasPrefix(prompt, "The following is part") {
+		return &LLMResponse{Text: "notes"}, nil
+	}
+	return &LLMResponse{Text: "the summary"}, nil
+}
+
+func TestChunkLines(t *testing.T) {
+	estimate := func(text string) int { return len(text) }
+	chunks := chunkLines([]string{"aaaa\n", "bbbb\n", "cccc\n", strings.Repeat("d", 30) + "\n"}, 12, estimate)
+	expected := []string{"aaaa\nbbbb\n", "cccc\n", strings.Repeat("d", 12)}
+	if strings.Join(chunks, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected chunks %q, got %q", expected, chunks)
+	}
+}
+
+func TestSummaryCommandSplitsLongLogs(t *testing.T) {
+	store := newTestStore(t)
+	for i := 0; i < 200; i++ {
+		msg := StoredMessage{Timestamp: int64(i + 1), SourceName: "Alice", Message: strings.Repeat("word ", 40), GroupId: "groupOne"}
+		if err := store.SaveMessage(context.Background(), &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// 200 messages of ~50 tokens each don't fit in the ~1800 tokens left after the prompt and reply
+	provider := &smallProvider{window: 6000}
+	ctx := &AppContext{Store: store, SummaryProvider: provider}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+
+	req := &RequestContext{GroupId: "groupOne", TraceContext: context.Background()}
+	if err := ctx.summaryCommand(req, TimeRange{}, 200, "Summarize this"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replies) != 2 || !strings.Contains(replies[0], "parts") || replies[1] != "the summary" {
+		t.Fatalf("expected a progress message and the summary, got %q", replies)
+	}
+	last := provider.prompts[len(provider.prompts)-1]
+	if !strings.HasPrefix(last, "Summarize this\n"+combineSummaryPrompt) || !strings.Contains(last, "Part 1:\nnotes") {
+		t.Errorf("expected the final request to combine the notes, got %q", last)
+	}
+	if len(provider.prompts) < 3 {
+		t.Errorf("expected at least two chunks and a final request, got %d requests", len(provider.prompts))
+	}
+
+	// A short log is summarized in a single request, without any progress message
+	provider.prompts, replies = nil, nil
+	if err := ctx.summaryCommand(req, TimeRange{}, 2, "Summarize this"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if starttime != 0 || count != 10 {
-		t.Errorf("expected starttime 0 and count 10, got %d and %d", starttime, count)
+	if len(provider.prompts) != 1 || len(replies) != 1 {
+		t.Errorf("expected one request and one reply, got %d and %q", len(provider.prompts), replies)
 	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// quoteSnippetLength is how much of the message replied to is shown with a reply
+const quoteSnippetLength = 80
+
+// resolveQuote finds the message a reply quoted. If we have it, its id and
+// author's name are returned, otherwise just what the reply quoted.
+func (ctx *AppContext) resolveQuote(traceCtx context.Context, groupId string, quote *Quote) (int64, string, string) {
+	parent, err := ctx.Store.FetchMessage(traceCtx, groupId, quote.Id, quote.AuthorUuid, quote.AuthorNumber)
+	if err != nil {
+		log.Println("Failed to fetch the quoted message:", err)
+	}
+	if parent != nil {
+		return parent.Id, parent.SourceName, parent.Message
+	}
+	// signal-cli only knows the author's number or uuid, not their name
+	author := quote.Author
+	if author == "" {
+		author = memberId(quote.AuthorUuid, quote.AuthorNumber)
+	}
+	return 0, author, quote.Text
+}
+
+// formatQuote shows which message a reply was to, eg. ` (replying to Alice: "lunch at one?")`
+func formatQuote(msg StoredMessage) string {
+	if msg.QuoteAuthor == "" {
+		return ""
+	}
+	snippet := quoteSnippet(msg.QuoteText)
+	if snippet == "" {
+		return " (replying to " + msg.QuoteAuthor + ")"
+	}
+	return " (replying to " + msg.QuoteAuthor + ": \"" + snippet + "\")"
+}
+
+func quoteSnippet(text string) string {
+	// The start of the text, on one line, without any image descriptions
+	if i := strings.Index(text, imageDataPrefix); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > quoteSnippetLength {
+		text = strings.TrimSpace(string(runes[:quoteSnippetLength])) + "..."
+	}
+	return text
+}
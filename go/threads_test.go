@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReplyThreads(t *testing.T) {
+	Config = map[string]string{}
+	store := newTestStore(t)
+	ctx := &AppContext{Store: store, TraceContext: context.Background()}
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {}
+	receive := func(name string, timestamp int64, message string, quote string) {
+		t.Helper()
+		ctx.processMessage(fmt.Sprintf(`{"envelope":{"sourceName":"%s","sourceUuid":"%s-uuid","timestamp":%d,`+
+			`"dataMessage":{"message":"%s",%s"groupInfo":{"groupId":"groupOne"}}}}`, name, name, timestamp, message, quote))
+	}
+
+	receive("Alice", 1000, "where shall we have lunch?", "")
+	receive("Bob", 2000, "what time?", "")
+	receive("Carol", 3000, "the pizza place", `"quote":{"id":1000,"authorUuid":"Alice-uuid","text":"where shall we have lunch?"},`)
+	receive("Alice", 4000, "noon", `"quote":{"id":2000,"authorUuid":"Bob-uuid","text":"what time?"},`)
+	// Replies to messages from before the bot joined only have what they quoted
+	receive("Bob", 5000, "still on?", `"quote":{"id":500,"authorNumber":"+15550001111","text":"dinner\nat  eight"},`)
+
+	messages, err := store.FetchRange(context.Background(), "groupOne", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logs, err := compileLogs(messages)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "Alice: where shall we have lunch?\n" +
+		"Bob: what time?\n" +
+		"Carol (replying to Alice: \"where shall we have lunch?\"): the pizza place\n" +
+		"Alice (replying to Bob: \"what time?\"): noon\n" +
+		"Bob (replying to +15550001111: \"dinner at eight\"): still on?\n"
+	if logs != expected {
+		t.Errorf("expected %q, got %q", expected, logs)
+	}
+
+	// A message's thread is what it replied to and the replies to it
+	thread, err := store.FetchThread(context.Background(), "groupOne", messages[2].Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(thread) != 1 || thread[0].Id != messages[0].Id {
+		t.Errorf("expected Carol's reply to be threaded to Alice's question, got %+v", thread)
+	}
+	thread, err = store.FetchThread(context.Background(), "groupOne", messages[1].Id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(thread) != 1 || thread[0].Message != "noon" {
+		t.Errorf("expected Bob's question to be threaded to Alice's answer, got %+v", thread)
+	}
+
+	// Replies outlive the message they quoted
+	if err := store.DeleteMessage(context.Background(), messages[1].Id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	messages, err = store.FetchRange(context.Background(), "groupOne", 4000, 4001)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ParentId != 0 || formatQuote(messages[0]) != ` (replying to Bob: "what time?")` {
+		t.Errorf("expected the reply to keep its quote, got %+v", messages)
+	}
+}
+
+func TestQuoteSnippet(t *testing.T) {
+	if snippet := quoteSnippet("the cat" + imageDataPrefix + "a cat on a sofa)"); snippet != "the cat" {
+		t.Errorf("expected image descriptions to be left out, got %q", snippet)
+	}
+	long := strings.Repeat("word ", 40)
+	if snippet := quoteSnippet(long); len([]rune(snippet)) > quoteSnippetLength+3 || !strings.HasSuffix(snippet, "...") {
+		t.Errorf("expected a shortened snippet, got %q", snippet)
+	}
+	if quote := formatQuote(StoredMessage{QuoteAuthor: "Alice"}); quote != " (replying to Alice)" {
+		t.Errorf("expected a reply to an attachment to name its author, got %q", quote)
+	}
+}
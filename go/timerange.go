@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeRange is a window of messages to look at. A zero Start means from the
+// oldest message we have, a zero End means up to now.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Millis returns the range as the millisecond timestamps used in the database.
+// Zero stays zero, which the store treats as unbounded.
+func (r TimeRange) Millis() (int64, int64) {
+	var start, end int64
+	if !r.Start.IsZero() {
+		start = r.Start.UnixMilli()
+	}
+	if !r.End.IsZero() {
+		end = r.End.UnixMilli()
+	}
+	return start, end
+}
+
+// Contains reports whether a millisecond timestamp is in the range
+func (r TimeRange) Contains(timestamp int64) bool {
+	start, end := r.Millis()
+	return timestamp >= start && (end == 0 || timestamp < end)
+}
+
+// rangeSpec is a parsed time range. It's resolved against the current time in
+// the group's time zone when the command runs, so "yesterday" means the
+// group's yesterday.
+type rangeSpec func(now time.Time) TimeRange
+
+// lastDuration is the range covering the d before now
+func lastDuration(d time.Duration) rangeSpec {
+	return func(now time.Time) TimeRange {
+		return TimeRange{Start: now.Add(-d), End: now}
+	}
+}
+
+// timeRangeHelp is shown when a time range can't be understood
+const timeRangeHelp = "try something like 90m, 12h, 2d, 1w, today, yesterday, this week, since 9am, since monday or 10:00-12:00"
+
+var (
+	durationPattern = regexp.MustCompile(`^(?:(?:last|past)\s+)?(\d+)\s*(m|mins?|minutes?|h|hrs?|hours?|d|days?|w|wks?|weeks?)$`)
+	clockPattern    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?\s*(am|pm)?$`)
+	clockRange      = regexp.MustCompile(`^(?:from\s+)?(\d{1,2}(?::\d{2})?\s*(?:am|pm)?)\s*(?:-|to|until)\s*(\d{1,2}(?::\d{2})?\s*(?:am|pm)?)$`)
+)
+
+var durationUnits = map[byte]time.Duration{
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// parseTimeRange understands:
+//
+//	90m, 12h, 2d, 1w, "3 days", "last 2 hours"
+//	today, yesterday, this week, last week
+//	since 9am, since 21:30, since yesterday, since monday, since 2024-12-01
+//	10:00-12:00, "9am to 11am", "from 14:00 until 15:30"
+//	2024-12-01 for the whole day
+func parseTimeRange(words []string) (rangeSpec, error) {
+	text := strings.ToLower(strings.Join(strings.Fields(strings.Join(words, " ")), " "))
+	if text == "" {
+		return nil, fmt.Errorf("no time range given, %s", timeRangeHelp)
+	}
+
+	if m := durationPattern.FindStringSubmatch(text); m != nil {
+		number, err := strconv.Atoi(m[1])
+		if err != nil || number <= 0 {
+			return nil, fmt.Errorf("invalid time range %q, %s", text, timeRangeHelp)
+		}
+		return lastDuration(time.Duration(number) * durationUnits[m[2][0]]), nil
+	}
+
+	switch text {
+	case "today":
+		return func(now time.Time) TimeRange {
+			return TimeRange{Start: startOfDay(now), End: now}
+		}, nil
+	case "yesterday":
+		return func(now time.Time) TimeRange {
+			return TimeRange{Start: startOfDay(now).AddDate(0, 0, -1), End: startOfDay(now)}
+		}, nil
+	case "this week":
+		return func(now time.Time) TimeRange {
+			return TimeRange{Start: startOfWeek(now), End: now}
+		}, nil
+	case "last week":
+		return func(now time.Time) TimeRange {
+			return TimeRange{Start: startOfWeek(now).AddDate(0, 0, -7), End: startOfWeek(now)}
+		}, nil
+	}
+
+	if point, ok := strings.CutPrefix(text, "since "); ok {
+		since, err := parseTimePoint(point)
+		if err != nil {
+			return nil, err
+		}
+		return func(now time.Time) TimeRange {
+			return TimeRange{Start: since(now), End: now}
+		}, nil
+	}
+
+	if m := clockRange.FindStringSubmatch(text); m != nil {
+		from, fromErr := parseClock(m[1])
+		until, untilErr := parseClock(m[2])
+		if fromErr != nil || untilErr != nil {
+			return nil, fmt.Errorf("invalid time range %q, %s", text, timeRangeHelp)
+		}
+		return func(now time.Time) TimeRange {
+			// Both times are today, unless that's in the future or the range
+			// crosses midnight, in which case it started yesterday
+			start, end := from(startOfDay(now)), until(startOfDay(now))
+			if !end.After(start) {
+				start = start.AddDate(0, 0, -1)
+			}
+			if start.After(now) {
+				start, end = start.AddDate(0, 0, -1), end.AddDate(0, 0, -1)
+			}
+			return TimeRange{Start: start, End: end}
+		}, nil
+	}
+
+	// A date on its own is that whole day
+	if _, err := time.Parse("2006-01-02", text); err == nil {
+		return func(now time.Time) TimeRange {
+			day, _ := time.ParseInLocation("2006-01-02", text, now.Location())
+			return TimeRange{Start: day, End: day.AddDate(0, 0, 1)}
+		}, nil
+	}
+
+	// Any other point in time means since then, eg. "9am" or "monday"
+	if since, err := parseTimePoint(text); err == nil {
+		return func(now time.Time) TimeRange {
+			return TimeRange{Start: since(now), End: now}
+		}, nil
+	}
+	return nil, fmt.Errorf("I don't understand the time range %q, %s", text, timeRangeHelp)
+}
+
+// parseTimePoint parses a single point in the past, resolved against now
+func parseTimePoint(text string) (func(now time.Time) time.Time, error) {
+	switch text {
+	case "today":
+		return startOfDay, nil
+	case "yesterday":
+		return func(now time.Time) time.Time {
+			return startOfDay(now).AddDate(0, 0, -1)
+		}, nil
+	case "this week":
+		return startOfWeek, nil
+	}
+
+	// A weekday is the most recent one, which may be today
+	if weekday, ok := weekdays[text]; ok {
+		return func(now time.Time) time.Time {
+			daysAgo := (int(now.Weekday()) - int(weekday) + 7) % 7
+			return startOfDay(now).AddDate(0, 0, -daysAgo)
+		}, nil
+	}
+
+	// A time of day is the most recent time it was that time
+	if clock, err := parseClock(text); err == nil {
+		return func(now time.Time) time.Time {
+			point := clock(startOfDay(now))
+			if point.After(now) {
+				point = point.AddDate(0, 0, -1)
+			}
+			return point
+		}, nil
+	}
+
+	// Dates, with or without a time
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04"} {
+		if _, err := time.Parse(layout, text); err == nil {
+			return func(now time.Time) time.Time {
+				point, _ := time.ParseInLocation(layout, text, now.Location())
+				return point
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("I don't understand the time %q, %s", text, timeRangeHelp)
+}
+
+// parseClock parses a time of day like 9am, 9:30pm or 21:30. A bare number
+// isn't a time, it would be confused with a message count.
+func parseClock(text string) (func(day time.Time) time.Time, error) {
+	m := clockPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return nil, fmt.Errorf("invalid time %q", text)
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	switch m[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return nil, fmt.Errorf("invalid time %q", text)
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return nil, fmt.Errorf("invalid time %q", text)
+	}
+	return func(day time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+	}, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func startOfWeek(t time.Time) time.Time {
+	// Weeks start on Monday
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -daysSinceMonday)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	// Wednesday afternoon, in a zone that isn't UTC
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	now := time.Date(2024, 12, 4, 15, 30, 0, 0, loc)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 12, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		text  string
+		start time.Time
+		end   time.Time
+	}{
+		{"90m", now.Add(-90 * time.Minute), now},
+		{"12h", now.Add(-12 * time.Hour), now},
+		{"2d", now.Add(-48 * time.Hour), now},
+		{"1w", now.Add(-7 * 24 * time.Hour), now},
+		{"3 days", now.Add(-72 * time.Hour), now},
+		{"last 2 hours", now.Add(-2 * time.Hour), now},
+		{"today", at(4, 0, 0), now},
+		{"yesterday", at(3, 0, 0), at(4, 0, 0)},
+		{"this week", at(2, 0, 0), now},
+		{"last week", time.Date(2024, 11, 25, 0, 0, 0, 0, loc), at(2, 0, 0)},
+		{"since 9am", at(4, 9, 0), now},
+		{"since 9:45pm", at(3, 21, 45), now},
+		{"since 14:00", at(4, 14, 0), now},
+		{"since yesterday", at(3, 0, 0), now},
+		{"since monday", at(2, 0, 0), now},
+		{"since wed", at(4, 0, 0), now},
+		{"since 2024-12-01", at(1, 0, 0), now},
+		{"since 2024-12-01 18:30", at(1, 18, 30), now},
+		{"10:00-12:00", at(4, 10, 0), at(4, 12, 0)},
+		{"10:00 - 12:00", at(4, 10, 0), at(4, 12, 0)},
+		{"from 9am to 11am", at(4, 9, 0), at(4, 11, 0)},
+		{"22:00-02:00", at(3, 22, 0), at(4, 2, 0)},
+		{"16:00-17:00", at(3, 16, 0), at(3, 17, 0)},
+		{"2024-12-01", at(1, 0, 0), at(2, 0, 0)},
+		{"8am", at(4, 8, 0), now},
+		{"SINCE  Monday", at(2, 0, 0), now},
+	}
+	for _, test := range tests {
+		window, err := parseTimeRange(strings.Fields(test.text))
+		if err != nil {
+			t.Errorf("parseTimeRange(%q): unexpected error: %v", test.text, err)
+			continue
+		}
+		result := window(now)
+		if !result.Start.Equal(test.start) || !result.End.Equal(test.end) {
+			t.Errorf("parseTimeRange(%q): expected %v to %v, got %v to %v", test.text, test.start, test.end, result.Start, result.End)
+		}
+	}
+
+	for _, text := range []string{"", "12", "0h", "3y", "soon", "since", "since 25:00", "13pm-2pm", "2024-13-01", "10:61-11:00"} {
+		if _, err := parseTimeRange(strings.Fields(text)); err == nil {
+			t.Errorf("parseTimeRange(%q): expected an error", text)
+		}
+	}
+}
+
+func TestTimeRangeContains(t *testing.T) {
+	window := TimeRange{Start: time.UnixMilli(100), End: time.UnixMilli(200)}
+	for timestamp, expected := range map[int64]bool{99: false, 100: true, 199: true, 200: false} {
+		if window.Contains(timestamp) != expected {
+			t.Errorf("Contains(%d): expected %v", timestamp, expected)
+		}
+	}
+	if !(TimeRange{}).Contains(1) {
+		t.Errorf("an empty range should contain everything")
+	}
+}
@@ -2,58 +2,49 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"sync"
 )
 
-type dbQuery struct {
-	query     string
-	values    []interface{}
-	replyChan chan dbReply
-}
-
-type dbReply struct {
-	rows *sql.Rows
-	err  error
-}
-
 // ImageAnalysisFunc is a function type for image analysis providers
-type ImageAnalysisFunc func(attachmentId string) (string, error)
+type ImageAnalysisFunc func(ctx context.Context, attachmentId string) (string, error)
 
-type AppContext struct {
-	DbQueryChan        chan dbQuery
-	DbReplySummaryChan chan interface{}
-	DbReplyAskChan     chan interface{}
-	Recipients         []string
-	MessagePoster      func(string, string)
-	TraceContext       context.Context
-	ImageAnalyzer      ImageAnalysisFunc
-}
+// MessagePosterFunc sends a reply for the given request
+type MessagePosterFunc func(req *RequestContext, message string, attachment string)
 
-type TimeCountCalculator struct {
-	StartTime int
-	Count     int
-}
+// ReactionSenderFunc reacts to the message which triggered the request
+type ReactionSenderFunc func(req *RequestContext, emoji string)
 
-type GroupInfo struct {
-	GroupID string `json:"groupId"`
-}
+// TypingIndicatorFunc starts or stops showing the bot typing in the request's conversation
+type TypingIndicatorFunc func(req *RequestContext, typing bool)
 
-type SentMessage struct {
-	Message   string    `json:"message"`
-	GroupInfo GroupInfo `json:"groupInfo"`
-}
-
-type SyncMessage struct {
-	SentMessage SentMessage `json:"sentMessage"`
-}
-
-type Envelope struct {
-	SourceNumber string      `json:"sourceNumber"`
-	SourceName   string      `json:"sourceName"`
-	Timestamp    int64       `json:"timestamp"`
-	SyncMessage  SyncMessage `json:"syncMessage"`
-}
-
-type ChatbotMessage struct {
-	Envelope Envelope `json:"envelope"`
+// AppContext holds state shared by every request. It must not be modified
+// while processing a message, per-message state belongs in RequestContext.
+type AppContext struct {
+	Store           Store
+	MessagePoster   MessagePosterFunc
+	ReactionSender  ReactionSenderFunc  // nil if the bot doesn't react to commands
+	TypingIndicator TypingIndicatorFunc // nil if the bot doesn't show it's typing
+	TraceContext    context.Context
+	ImageAnalyzer   ImageAnalysisFunc
+	SummaryProvider LLMProvider
+	ChatProvider    LLMProvider       // nil if chat is disabled
+	Embedder        EmbeddingProvider // nil if retrieval for !ask is disabled
+	embedding       sync.WaitGroup    // Messages being embedded in the background
+}
+
+// RequestContext holds everything about a single incoming message.
+// Each message gets its own RequestContext so concurrent messages from
+// different groups never share state.
+type RequestContext struct {
+	GroupId      string          // The raw groupId, as stored in the database
+	Conversation string          // Where the incoming message was sent. Differs from GroupId for direct messages naming a group.
+	Recipient    string          // Where replies are sent, eg. group.<base64 groupId>
+	SourceName   string          // The display name of the sender
+	SourceNumber string          // The phone number of the sender, may be empty
+	SourceUuid   string          // The UUID of the sender
+	Timestamp    int64           // The timestamp of the incoming message in ms
+	Mentions     []Mention       // The members @mentioned in the message
+	Message      string          // The text of the incoming message
+	QuoteReply   bool            // Whether replies quote the incoming message, as they do for commands
+	TraceContext context.Context // The span for this message
 }
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// modelPrice is what a model costs, in US dollars
+type modelPrice struct {
+	Input  float64 // Per million input tokens
+	Output float64 // Per million output tokens
+	Image  float64 // Per image generated
+}
+
+// modelPrices are the list prices of the hosted models, matched against the
+// longest prefix of the model name the provider reports, eg. gpt-4o-2024-08-06.
+// Self-hosted models are free.
+var modelPrices = map[string]modelPrice{
+	"gpt-3.5-turbo":     {Input: 0.5, Output: 1.5},
+	"gpt-4o":            {Input: 2.5, Output: 10},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.6},
+	"o1-mini":           {Input: 1.1, Output: 4.4},
+	"dall-e-3":          {Image: 0.04},
+	"imagen-3.0":        {Image: 0.04},
+	"claude-3-7-sonnet": {Input: 3, Output: 15},
+	"claude-3-5-sonnet": {Input: 3, Output: 15},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4},
+	"gemini-1.5-flash":  {Input: 0.075, Output: 0.3},
+	"gemini-1.5-pro":    {Input: 1.25, Output: 5},
+	"gemini-2.0-flash":  {Input: 0.1, Output: 0.4},
+
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+	"text-embedding-ada-002": {Input: 0.1},
+}
+
+// priceFor returns the price of model, or false if it isn't known
+func priceFor(model string) (modelPrice, bool) {
+	var price modelPrice
+	matched := ""
+	for prefix, p := range modelPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			price, matched = p, prefix
+		}
+	}
+	return price, matched != ""
+}
+
+// meteredCommands are the things which call a paid provider, so are refused
+// once a budget has been used up
+var meteredCommands = map[string]bool{
+	"ask": true, "catchup": true, "chat": true, "image_analysis": true, "imagine": true, "schedule": true, "summary": true,
+}
+
+func usageCost(provider string, model string, usage Usage) float64 {
+	if provider == "local" || provider == "debug" {
+		return 0
+	}
+	price, ok := priceFor(model)
+	if !ok {
+		log.Printf("No price known for %s model %q, its usage is counted as free", provider, model)
+		return 0
+	}
+	return (float64(usage.InputTokens)*price.Input+float64(usage.OutputTokens)*price.Output)/1e6 +
+		float64(usage.Images)*price.Image
+}
+
+// usageOwner is who a request to a provider is made for. It's carried in the
+// request's context, so providers deep in a command don't need to know.
+type usageOwner struct {
+	GroupId string
+	Member  string
+	Command string
+}
+
+type usageOwnerKey struct{}
+
+func withUsageOwner(traceCtx context.Context, req *RequestContext, command string) context.Context {
+	if traceCtx == nil {
+		traceCtx = context.Background()
+	}
+	owner := usageOwner{GroupId: req.GroupId, Member: memberId(req.SourceUuid, req.SourceNumber), Command: command}
+	return context.WithValue(traceCtx, usageOwnerKey{}, owner)
+}
+
+func usageOwnerFrom(traceCtx context.Context) usageOwner {
+	owner, _ := traceCtx.Value(usageOwnerKey{}).(usageOwner)
+	return owner
+}
+
+// recordUsage stores what a request to a provider used, against whoever the
+// context says it was made for
+func recordUsage(traceCtx context.Context, store Store, provider string, model string, usage Usage) {
+	if store == nil {
+		return
+	}
+	owner := usageOwnerFrom(traceCtx)
+	record := UsageRecord{
+		GroupId:      owner.GroupId,
+		Member:       owner.Member,
+		Command:      owner.Command,
+		Provider:     provider,
+		Model:        model,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		Images:       usage.Images,
+		Cost:         usageCost(provider, model, usage),
+	}
+	// The request has already been paid for, so record it even if it was cancelled since
+	if err := store.RecordUsage(context.WithoutCancel(traceCtx), record); err != nil {
+		log.Println("Failed to record usage:", err)
+	}
+}
+
+// meteredProvider records the usage of every request made to a provider
+type meteredProvider struct {
+	LLMProvider
+	store Store
+}
+
+func meter(provider LLMProvider, store Store) LLMProvider {
+	if provider == nil || store == nil {
+		return provider
+	}
+	if _, ok := provider.(*meteredProvider); ok {
+		return provider
+	}
+	return &meteredProvider{LLMProvider: provider, store: store}
+}
+
+func (p *meteredProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	resp, err := p.LLMProvider.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(ctx, p.store, p.Name(), resp.Model, resp.Usage)
+	return resp, nil
+}
+
+// meteredEmbedder records the usage of every request made to an embedding provider
+type meteredEmbedder struct {
+	EmbeddingProvider
+	store Store
+}
+
+func meterEmbedder(embedder EmbeddingProvider, store Store) EmbeddingProvider {
+	if embedder == nil || store == nil {
+		return embedder
+	}
+	if _, ok := embedder.(*meteredEmbedder); ok {
+		return embedder
+	}
+	return &meteredEmbedder{EmbeddingProvider: embedder, store: store}
+}
+
+func (p *meteredEmbedder) Embed(ctx context.Context, texts []string) (*EmbeddingResponse, error) {
+	resp, err := p.EmbeddingProvider.Embed(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(ctx, p.store, p.Name(), p.Model(), resp.Usage)
+	return resp, nil
+}
+
+// monthlyBudget returns the budget in key, or zero if there isn't one.
+// Budgets are checked at startup.
+func monthlyBudget(key string) float64 {
+	budget, _ := strconv.ParseFloat(Config[key], 64)
+	return budget
+}
+
+func validateBudget(value string) error {
+	if value == "" {
+		return nil
+	}
+	budget, err := strconv.ParseFloat(value, 64)
+	if err != nil || budget < 0 {
+		return fmt.Errorf("%q isn't an amount of dollars", value)
+	}
+	return nil
+}
+
+func sumCost(totals []UsageRecord) float64 {
+	var cost float64
+	for _, total := range totals {
+		cost += total.Cost
+	}
+	return cost
+}
+
+// checkBudget returns an empty string if the group and member have budget left
+// this month, otherwise the reason they can't spend any more
+func (ctx *AppContext) checkBudget(traceCtx context.Context, groupId string, member string, now time.Time) string {
+	groupBudget, userBudget := monthlyBudget("GROUP_MONTHLY_BUDGET"), monthlyBudget("USER_MONTHLY_BUDGET")
+	if groupBudget == 0 && (userBudget == 0 || member == "") {
+		return ""
+	}
+	month := startOfMonth(now.In(ctx.groupLocation(traceCtx, groupId)))
+	resets := month.AddDate(0, 1, 0).Format("2 January")
+
+	// Failing to read the usage shouldn't stop the bot working, so it's
+	// logged and the request allowed
+	if groupBudget > 0 {
+		totals, err := ctx.Store.FetchUsage(traceCtx, groupId, "", month.UnixMilli())
+		if err != nil {
+			log.Println("Failed to fetch the group's usage:", err)
+		} else if sumCost(totals) >= groupBudget {
+			return fmt.Sprintf("Sorry, this group has used its $%.2f budget for %s. It resets on %s.", groupBudget, month.Format("January"), resets)
+		}
+	}
+	if userBudget > 0 && member != "" {
+		totals, err := ctx.Store.FetchUsage(traceCtx, "", member, month.UnixMilli())
+		if err != nil {
+			log.Println("Failed to fetch the member's usage:", err)
+		} else if sumCost(totals) >= userBudget {
+			return fmt.Sprintf("Sorry, you've used your $%.2f budget for %s. It resets on %s.", userBudget, month.Format("January"), resets)
+		}
+	}
+	return ""
+}
+
+// checkLimits returns an empty string if the sender can use name now,
+// otherwise the reason they can't. It takes a token from their rate limits.
+func (ctx *AppContext) checkLimits(req *RequestContext, name string) string {
+	now := time.Now()
+	if meteredCommands[name] {
+		if refusal := ctx.checkBudget(req.TraceContext, req.GroupId, memberId(req.SourceUuid, req.SourceNumber), now); refusal != "" {
+			return refusal
+		}
+	}
+	return ctx.checkRateLimit(req, name, now)
+}
+
+func init() {
+	Commands.Register(&Command{
+		Name:        "usage",
+		Description: "Show how much this group and you have spent on AI this month",
+		GroupTarget: true,
+		Handler: func(ctx *AppContext, req *RequestContext, args interface{}) error {
+			return ctx.usageCommand(req, time.Now())
+		},
+	})
+}
+
+func (ctx *AppContext) usageCommand(req *RequestContext, now time.Time) error {
+	month := startOfMonth(now.In(ctx.groupLocation(req.TraceContext, req.GroupId)))
+	groupTotals, err := ctx.Store.FetchUsage(req.TraceContext, req.GroupId, "", month.UnixMilli())
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't fetch the usage: "+err.Error(), "")
+		return err
+	}
+	member := memberId(req.SourceUuid, req.SourceNumber)
+	memberTotals, err := ctx.Store.FetchUsage(req.TraceContext, "", member, month.UnixMilli())
+	if err != nil {
+		ctx.MessagePoster(req, "Sorry, I couldn't fetch the usage: "+err.Error(), "")
+		return err
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "Usage for %s:\n", month.Format("January"))
+	fmt.Fprintf(&message, "This group: %s\n", describeSpend(sumCost(groupTotals), monthlyBudget("GROUP_MONTHLY_BUDGET"), "its"))
+
+	// The group's usage of each command, most expensive first
+	byCommand := map[string]UsageRecord{}
+	for _, total := range groupTotals {
+		sum := byCommand[total.Command]
+		sum.Command = total.Command
+		sum.InputTokens += total.InputTokens
+		sum.OutputTokens += total.OutputTokens
+		sum.Images += total.Images
+		sum.Cost += total.Cost
+		byCommand[total.Command] = sum
+	}
+	var commands []UsageRecord
+	for _, sum := range byCommand {
+		commands = append(commands, sum)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		if commands[i].Cost != commands[j].Cost {
+			return commands[i].Cost > commands[j].Cost
+		}
+		return commands[i].Command < commands[j].Command
+	})
+	for _, sum := range commands {
+		fmt.Fprintf(&message, "%s: $%.2f, %s\n", describeLimited(sum.Command), sum.Cost, describeUsage(sum))
+	}
+
+	if member != "" {
+		fmt.Fprintf(&message, "You, in every group: %s\n", describeSpend(sumCost(memberTotals), monthlyBudget("USER_MONTHLY_BUDGET"), "your"))
+	}
+	ctx.MessagePoster(req, message.String(), "")
+	return nil
+}
+
+func describeSpend(cost float64, budget float64, whose string) string {
+	if budget > 0 {
+		return fmt.Sprintf("$%.2f of %s $%.2f budget", cost, whose, budget)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+func describeUsage(usage UsageRecord) string {
+	var parts []string
+	if tokens := usage.InputTokens + usage.OutputTokens; tokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens", tokens))
+	}
+	if usage.Images == 1 {
+		parts = append(parts, "1 image")
+	} else if usage.Images > 1 {
+		parts = append(parts, fmt.Sprintf("%d images", usage.Images))
+	}
+	if len(parts) == 0 {
+		return "nothing counted"
+	}
+	return strings.Join(parts, ", ")
+}
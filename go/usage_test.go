@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// pricedProvider reports the usage a hosted provider would
+type pricedProvider struct{}
+
+func (p *pricedProvider) Name() string                   { return "openai" }
+func (p *pricedProvider) ContextWindow() int             { return 128000 }
+func (p *pricedProvider) EstimateTokens(text string) int { return estimateTokens(text, 4) }
+func (p *pricedProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	return &LLMResponse{Text: "the summary", Model: "gpt-4o-2024-08-06", Usage: Usage{InputTokens: 200000, OutputTokens: 50000}}, nil
+}
+
+func TestUsageCost(t *testing.T) {
+	tests := []struct {
+		provider string
+		model    string
+		usage    Usage
+		expected float64
+	}{
+		{"openai", "gpt-4o-2024-08-06", Usage{InputTokens: 1000000, OutputTokens: 1000000}, 12.5},
+		{"openai", "gpt-4o-mini-2024-07-18", Usage{InputTokens: 1000000}, 0.15},
+		{"openai", "dall-e-3", Usage{Images: 2}, 0.08},
+		{"google", imageGenModels["google"], Usage{Images: 2}, 0.08},
+		{"openai", "text-embedding-3-small", Usage{InputTokens: 1000000}, 0.02},
+		{"claude", "claude-3-5-haiku-20241022", Usage{OutputTokens: 500000}, 2},
+		{"local", "gpt-4o", Usage{InputTokens: 1000000}, 0},
+		{"google", "gemini-99", Usage{InputTokens: 1000000}, 0},
+	}
+	for _, test := range tests {
+		if cost := usageCost(test.provider, test.model, test.usage); math.Abs(cost-test.expected) > 1e-9 {
+			t.Errorf("usageCost(%s, %s): expected %v, got %v", test.provider, test.model, test.expected, cost)
+		}
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	Config = map[string]string{"GROUP_MONTHLY_BUDGET": "1.50", "USER_MONTHLY_BUDGET": "0.75", "TIMEZONE": "UTC"}
+	limiter = newRateLimiter()
+	store := newTestStore(t)
+	ctx := &AppContext{Store: store, SummaryProvider: &pricedProvider{}, TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	msg := StoredMessage{Timestamp: time.Now().UnixMilli(), SourceName: "Carol", Message: "hello", GroupId: "groupOne"}
+	if err := store.SaveMessage(context.Background(), &msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	member := func(uuid string) *RequestContext {
+		return &RequestContext{GroupId: "groupOne", SourceUuid: uuid, TraceContext: context.Background()}
+	}
+
+	// Each summary costs $1.00, which is recorded against whoever asked
+	ctx.dispatchCommand(member("alice"), "!summary 5")
+	ctx.dispatchCommand(member("alice"), "!summary 5")
+	ctx.dispatchCommand(member("bob"), "!summary 5")
+	ctx.dispatchCommand(member("bob"), "!summary 5")
+	month := time.Now().UTC().Format("January")
+	expected := []string{
+		"the summary",
+		"Sorry, you've used your $0.75 budget for " + month + ".",
+		"the summary",
+		"Sorry, this group has used its $1.50 budget for " + month + ".",
+	}
+	if len(replies) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, replies)
+	}
+	for i := range expected {
+		if !strings.HasPrefix(replies[i], expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], replies[i])
+		}
+	}
+
+	// Free commands still work
+	replies = nil
+	ctx.dispatchCommand(member("alice"), "!usage")
+	if len(replies) != 1 {
+		t.Fatalf("unexpected replies %q", replies)
+	}
+	for _, line := range []string{"Usage for " + month + ":\n", "This group: $2.00 of its $1.50 budget\n",
+		"!summary: $2.00, 500000 tokens\n", "You, in every group: $1.00 of your $0.75 budget\n"} {
+		if !strings.Contains(replies[0], line) {
+			t.Errorf("expected the usage to contain %q, got %q", line, replies[0])
+		}
+	}
+
+	totals, err := store.FetchUsage(context.Background(), "groupOne", "bob", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(totals) != 1 || totals[0].Command != "summary" || totals[0].InputTokens != 200000 || totals[0].Cost != 1 {
+		t.Errorf("unexpected usage for Bob %+v", totals)
+	}
+}
+
+func TestEmbeddingsAreMetered(t *testing.T) {
+	Config = map[string]string{"GROUP_MONTHLY_BUDGET": "1", "TIMEZONE": "UTC"}
+	store := newTestStore(t)
+	ctx := &AppContext{Store: store, Embedder: meterEmbedder(&keywordEmbedder{keyword: "hiking"}, store), TraceContext: context.Background()}
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {}
+	receive := func(groupId string, message string) {
+		t.Helper()
+		req := &RequestContext{GroupId: groupId, SourceUuid: "alice", Timestamp: time.Now().UnixMilli(), TraceContext: context.Background()}
+		if err := ctx.saveMessage(req, message, nil, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ctx.embedding.Wait()
+	}
+
+	// Embedding a message is paid for by its group and sender
+	receive("groupOne", "hiking on Sunday?")
+	totals, err := store.FetchUsage(context.Background(), "groupOne", "alice", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(totals) != 1 || totals[0].Command != "embedding" || totals[0].InputTokens != 1 {
+		t.Errorf("unexpected usage %+v", totals)
+	}
+
+	// Once the group's budget is used up, messages are still saved but not embedded
+	if err := store.RecordUsage(context.Background(), UsageRecord{GroupId: "groupTwo", Command: "summary", Cost: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	receive("groupTwo", "hiking on Monday?")
+	embeddings, err := store.FetchEmbeddings(context.Background(), "groupTwo", "keyword")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	messages, err := store.FetchRange(context.Background(), "groupTwo", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(embeddings) != 0 || len(messages) != 1 {
+		t.Errorf("expected the message to be saved without embedding it, got %d embeddings of %+v", len(embeddings), messages)
+	}
+}
+
+func TestUnpricedImagesAreRefusedUnderABudget(t *testing.T) {
+	Config = map[string]string{"IMAGE_GEN_PROVIDER": "openai", "GROUP_MONTHLY_BUDGET": "1"}
+	imageGenModels["openai"] = "dall-e-99"
+	t.Cleanup(func() { imageGenModels["openai"] = openai.CreateImageModelDallE3 })
+	ctx := &AppContext{Store: newTestStore(t), TraceContext: context.Background()}
+	var replies []string
+	ctx.MessagePoster = func(req *RequestContext, message, _ string) {
+		replies = append(replies, message)
+	}
+	req := &RequestContext{GroupId: "groupOne", SourceUuid: "alice", TraceContext: context.Background()}
+	if err := ctx.imagineCommand(req, "a cat", "!imagine"); err == nil {
+		t.Errorf("expected an error")
+	}
+	if len(replies) != 1 || replies[0] != "Sorry, I can't tell what images cost, so I can't make one while there's a budget." {
+		t.Errorf("unexpected replies %q", replies)
+	}
+}
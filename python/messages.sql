@@ -1,3 +1,4 @@
+-- The Go bot creates and upgrades this schema itself, see go/migrations/
 CREATE TABLE IF NOT EXISTS `messages` (
   `id` integer not null primary key autoincrement,
   `timestamp` UNSIGNED BIG INT null,
@@ -6,4 +7,7 @@ CREATE TABLE IF NOT EXISTS `messages` (
   `message` TEXT not null,
   `groupId` TEXT not null,
   `mentions` TEXT,
-  `created_at` datetime not null default CURRENT_TIMESTAMP);
+  `created_at` datetime not null default CURRENT_TIMESTAMP,
+  `attachments` TEXT,
+  `replyToTimestamp` UNSIGNED BIG INT null,
+  `replyToAuthor` TEXT);